@@ -21,8 +21,10 @@ func main() {
 	})
 	slog.SetDefault(slog.New(handler))
 
-	supportedModes := []string{"single", "continuous", "traceroute"}
+	supportedModes := []string{"single", "continuous", "traceroute", "multipath", "pmtu"}
 	mode := flag.String("mode", "single", fmt.Sprintf("One of %v", supportedModes))
+	method := flag.String("method", "icmp", "Traceroute method, one of icmp, udp, or tcp")
+	paris := flag.Bool("paris", false, "Trace in Paris mode")
 	flag.Parse()
 	if !slices.Contains(supportedModes, *mode) {
 		slog.Error("Unsupported mode", "supported", supportedModes)
@@ -38,7 +40,17 @@ func main() {
 		continuousPing()
 	case "traceroute":
 		slog.Debug("traceroute mode")
-		traceroute()
+		traceroute(network.TraceOpts{
+			Method: network.TraceMethod(*method),
+			Paris:  *paris,
+			DNS:    network.NewReverseDNS(network.ReverseDNSOpts{}),
+		})
+	case "pmtu":
+		slog.Debug("pmtu mode")
+		pmtu()
+	case "multipath":
+		slog.Debug("multipath mode")
+		multipath(network.TraceOpts{Method: network.TraceMethod(*method)})
 	default:
 		slog.Error("Mode not supported yet", "mode", *mode)
 		os.Exit(1)
@@ -62,7 +74,7 @@ func singlePing() {
 		IP: dest,
 	}
 
-	err = icmpPing.Ping(opts)
+	_, err = icmpPing.Ping(opts)
 	if err != nil {
 		slog.Error("Ping failed", "error", err)
 	}
@@ -113,30 +125,82 @@ func addIp(pl *network.PingLoop, ip string) {
 	pl.AddIpAddr(ra)
 }
 
-func traceroute() {
+func traceroute(opts network.TraceOpts) {
 	dest, err := net.ResolveIPAddr("ip4:icmp", "8.8.8.8")
 	if err != nil {
 		slog.Error("Failed to resolve IP address")
 		os.Exit(1)
 	}
 
-	hops, err := network.Traceroute(dest)
+	hops, err := network.Traceroute(dest, opts)
 	if err != nil {
 		slog.Error("Error from traceroute", "error", err)
 	}
 
 	var hopStr strings.Builder
 	for _, hop := range hops {
-		ip := hop.IP.String()
-		if ip == "" {
+		fmt.Fprintf(&hopStr, "%2d ", hop.TTL)
+		if hop.IP == nil {
+			hopStr.WriteString("*\n")
 			continue
 		}
-		hopStr.WriteString(ip)
+		hopStr.WriteString(hop.IP.String())
 		if len(hop.Domains) > 0 {
 			d := strings.Join(hop.Domains, ",")
 			fmt.Fprintf(&hopStr, " (%s)", d)
 		}
+		fmt.Fprintf(&hopStr, " %v loss=%.0f%% %s%s", hop.RTTs, hop.Loss()*100, hop.ICMPType, hop.TCPFlags)
+		if hop.Unreachable != "" {
+			fmt.Fprintf(&hopStr, " %s", hop.Unreachable)
+		}
+		for _, l := range hop.MPLS {
+			fmt.Fprintf(&hopStr, " [MPLS Label=%d TC=%d S=%t TTL=%d]", l.Label, l.TC, l.S, l.TTL)
+		}
+		if hop.TunnelDepth() > 0 {
+			fmt.Fprintf(&hopStr, " [tunnel depth %d]", hop.TunnelDepth())
+		}
+		hopStr.WriteByte('\n')
+	}
+	slog.Debug(hopStr.String())
+}
+
+func multipath(opts network.TraceOpts) {
+	dest, err := net.ResolveIPAddr("ip4:icmp", "8.8.8.8")
+	if err != nil {
+		slog.Error("Failed to resolve IP address")
+		os.Exit(1)
+	}
+
+	hops, err := network.Multipath(dest, opts, 16)
+	if err != nil {
+		slog.Error("Error from multipath", "error", err)
+	}
+
+	var hopStr strings.Builder
+	for _, hop := range hops {
+		fmt.Fprintf(&hopStr, "%2d", hop.TTL)
+		if len(hop.NextHops) == 0 {
+			hopStr.WriteString(" *")
+		}
+		for _, nh := range hop.NextHops {
+			fmt.Fprintf(&hopStr, " %s %v", nh.IP, nh.Flows)
+		}
 		hopStr.WriteByte('\n')
 	}
 	slog.Debug(hopStr.String())
 }
+
+func pmtu() {
+	dest, err := net.ResolveIPAddr("ip4:icmp", "8.8.8.8")
+	if err != nil {
+		slog.Error("Failed to resolve IP address")
+		os.Exit(1)
+	}
+
+	result, err := network.DiscoverPMTU(dest, network.PMTUOpts{})
+	if err != nil {
+		slog.Error("Error from pmtu", "error", err)
+		os.Exit(1)
+	}
+	slog.Debug("Path MTU", "mtu", result.MTU, "hop", result.Hop, "reported", result.ReportedMTU)
+}
@@ -1,24 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"network_monitor/internal/config"
+	"network_monitor/internal/export"
 	"network_monitor/internal/monitoring"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
 )
 
 var BuildTime string = "not set"
 
+// shutdownTimeout is how long the last pushes can take once a signal asks
+// the monitor to stop
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	opts := config.NewOpts()
 	registry := prometheus.NewRegistry()
-	metrics := config.NewMetrics(registry)
+	metrics := config.NewMetrics(registry, opts.TargetLabels)
 
 	var handler slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
 		Level:     opts.LogLevel,
@@ -29,6 +40,9 @@ func main() {
 	})
 	slog.SetDefault(slog.New(handler))
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	slog.Info("Starting Network Monitor", "ips", strings.Join(opts.PingIps, ","), "build", BuildTime)
 	slog.Debug("Configuration options",
 		"PingIps", opts.PingIps,
@@ -41,8 +55,87 @@ func main() {
 		slog.Error("Failed to create new pinger", "error", err)
 		os.Exit(1)
 	}
+	sinkOpts := export.BatchOpts{
+		Size:          opts.SinkBatchSize,
+		FlushInterval: time.Duration(opts.SinkFlushInterval) * time.Second,
+		QueueSize:     opts.SinkQueueSize,
+		Retries:       opts.SinkRetries,
+		Timeout:       10 * time.Second,
+	}
+	var batchers []*export.Batcher
+	if opts.InfluxURL != "" {
+		sink, err := export.NewInflux(opts.InfluxURL, opts.InfluxToken)
+		if err != nil {
+			slog.Error("Failed to create InfluxDB sink", "error", err)
+			os.Exit(1)
+		}
+		batcher := export.NewBatcher("influx", sink, sinkOpts, metrics)
+		batcher.Run(ctx)
+		manager.AddSink(batcher)
+		batchers = append(batchers, batcher)
+	}
+	if opts.GraphiteAddr != "" {
+		batcher := export.NewBatcher("graphite", export.NewGraphite(opts.GraphiteAddr, opts.GraphitePrefix, opts.GraphiteTags), sinkOpts, metrics)
+		batcher.Run(ctx)
+		manager.AddSink(batcher)
+		batchers = append(batchers, batcher)
+	}
 	manager.Run()
 
+	var otlp *export.OTLP
+	if opts.OTLPEndpoint != "" {
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			slog.Warn("OTLP export failed", "error", err)
+		}))
+		otlp, err = export.NewOTLP(ctx, registry, export.OTLPOpts{
+			Endpoint:   opts.OTLPEndpoint,
+			Protocol:   opts.OTLPProtocol,
+			Interval:   time.Duration(opts.OTLPInterval) * time.Second,
+			Timeout:    10 * time.Second,
+			Headers:    opts.OTLPHeaders,
+			Build:      BuildTime,
+			Attributes: opts.OTLPResourceAttributes,
+		})
+		if err != nil {
+			slog.Error("Failed to start OTLP export", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Pushing metrics with OTLP", "endpoint", opts.OTLPEndpoint, "protocol", opts.OTLPProtocol)
+	}
+
+	var remoteWrite *export.RemoteWrite
+	if opts.RemoteWriteURL != "" {
+		remoteWrite, err = export.NewRemoteWrite(registry, export.RemoteWriteOpts{
+			URL:         opts.RemoteWriteURL,
+			Interval:    time.Duration(opts.RemoteWriteInterval) * time.Second,
+			Timeout:     30 * time.Second,
+			Headers:     opts.RemoteWriteHeaders,
+			Labels:      opts.RemoteWriteLabels,
+			WALDir:      opts.RemoteWriteWALDir,
+			WALMaxBytes: int64(opts.RemoteWriteWALMaxMB) << 20,
+			WALMaxAge:   time.Duration(opts.RemoteWriteWALMaxAge) * time.Second,
+		}, metrics)
+		if err != nil {
+			slog.Error("Failed to start remote write", "error", err)
+			os.Exit(1)
+		}
+		remoteWrite.Run(ctx)
+		slog.Info("Pushing metrics with remote write", "url", opts.RemoteWriteURL, "wal", opts.RemoteWriteWALDir)
+	}
+
+	var pushgateway *export.Pushgateway
+	if opts.PushgatewayURL != "" {
+		pushgateway = export.NewPushgateway(registry, export.PushgatewayOpts{
+			URL:      opts.PushgatewayURL,
+			Job:      opts.PushgatewayJob,
+			Interval: time.Duration(opts.PushgatewayInterval) * time.Second,
+			Timeout:  30 * time.Second,
+			Grouping: opts.PushgatewayGrouping,
+		}, metrics)
+		pushgateway.Run(ctx)
+		slog.Info("Pushing metrics to the Pushgateway", "url", opts.PushgatewayURL)
+	}
+
 	http.Handle("/metrics",
 		promhttp.HandlerFor(
 			registry,
@@ -51,10 +144,67 @@ func main() {
 			}),
 	)
 
-	err = http.ListenAndServe(fmt.Sprintf(":%s", opts.ServerPort), nil)
-	if err != nil {
-		slog.Error("Failed to start server", "error", err)
-		os.Exit(1)
+	http.HandleFunc("/trace", manager.HandleTrace)
+	http.HandleFunc("/mtr", manager.HandleMTRReport)
+
+	var api *monitoring.TargetAPI
+	if opts.APITokensFile != "" {
+		api, err = manager.NewTargetAPI()
+		if err != nil {
+			slog.Error("Failed to start targets API", "error", err)
+			os.Exit(1)
+		}
+		http.Handle("/api/v1/", api.Handler())
+	}
+
+	// Discoveries send thousands of probes, so they're only served when
+	// asked for and need a token when there are any
+	if opts.MultipathEndpoint {
+		var multipath http.Handler = http.HandlerFunc(manager.HandleMultipath)
+		if api != nil {
+			multipath = api.Authenticate(multipath)
+		}
+		http.Handle("/multipath", multipath)
 	}
+
+	server := &http.Server{Addr: fmt.Sprintf(":%s", opts.ServerPort)}
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to start server", "error", err)
+			os.Exit(1)
+		}
+	}()
 	slog.Debug("Serving metrics at /metrics", "port", opts.ServerPort)
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if otlp != nil {
+		if err := otlp.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Failed to push metrics with OTLP before exiting", "error", err)
+		}
+	}
+	// Sinks write what they had queued when the signal came, and the
+	// metrics are pushed, or kept in the remote write WAL, one last time
+	var done []<-chan struct{}
+	for _, b := range batchers {
+		done = append(done, b.Done())
+	}
+	if remoteWrite != nil {
+		done = append(done, remoteWrite.Done())
+	}
+	if pushgateway != nil {
+		done = append(done, pushgateway.Done())
+	}
+	for _, d := range done {
+		select {
+		case <-d:
+		case <-shutdownCtx.Done():
+		}
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Failed to stop server", "error", err)
+	}
 }
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"network_monitor/internal/discovery"
+	"network_monitor/internal/utils"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+var otlpProtocols = []string{"grpc", "http"}
+
+// ParseKeyValues reads a comma-separated list of key=value pairs, such as
+// "site=lon1, env=prod"
+func ParseKeyValues(s string) (map[string]string, error) {
+	pairs, err := utils.GetIps(s)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("Expected key=value, got %q", pair)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("Key %s is given more than once", key)
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// AddHeader adds a "Name: value" header to headers, the value can hold
+// commas and colons
+func AddHeader(headers map[string]string, s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+		return fmt.Errorf("Expected name: value, got %q", s)
+	}
+	if _, ok := headers[name]; ok {
+		return fmt.Errorf("Header %s is given more than once", name)
+	}
+	headers[name] = value
+	return nil
+}
+
+// validLabelNames checks the keys of labels are Prometheus label names
+func validLabelNames(labels map[string]string) error {
+	for name := range labels {
+		if !discovery.ValidLabelName(name) {
+			return fmt.Errorf("Invalid label name %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	values, err := ParseKeyValues("site=lon1, env = prod,token=a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values["site"] != "lon1" || values["env"] != "prod" || values["token"] != "a=b" {
+		t.Errorf("Unexpected values %v", values)
+	}
+
+	for _, s := range []string{"", "site", "=lon1", "site=lon1,site=lon2"} {
+		if _, err := ParseKeyValues(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestAddHeader(t *testing.T) {
+	headers := make(map[string]string)
+	for _, s := range []string{"Authorization: Bearer a,b=c", "X-Scope-OrgID:tenant:1"} {
+		if err := AddHeader(headers, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(headers) != 2 || headers["Authorization"] != "Bearer a,b=c" || headers["X-Scope-OrgID"] != "tenant:1" {
+		t.Errorf("Unexpected headers %v", headers)
+	}
+
+	for _, s := range []string{"Authorization", ": token", "Bad Name: x", "Authorization: again"} {
+		if err := AddHeader(headers, s); err == nil {
+			t.Errorf("Expected an error adding %q", s)
+		}
+	}
+}
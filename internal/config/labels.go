@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"network_monitor/internal/discovery"
+	"network_monitor/internal/utils"
+	"slices"
+	"strings"
+)
+
+// reservedLabels are set by the monitor on ping metrics, so they can't be
+// target labels
+var reservedLabels = []string{"ip", "interface", "role", "group", "class"}
+
+// validTargetLabel checks a target label name can be added to the ping
+// metrics
+func validTargetLabel(name string) error {
+	if !discovery.ValidLabelName(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("Invalid label name %q", name)
+	}
+	if slices.Contains(reservedLabels, name) {
+		return fmt.Errorf("Label %q is set by the monitor", name)
+	}
+	return nil
+}
+
+// Group is the settings shared by the targets in a group
+type Group struct {
+	Labels map[string]string
+	Trace  TraceMode // Overrides the trace policy for the group's targets when set
+}
+
+// ParseGroups reads a comma-separated list of group names, each followed by
+// the semicolon-separated labels its targets get, such as
+// "dns;provider=cloudflare;criticality=high, office;site=london". A trace
+// entry sets when the group's targets are traced instead of being a label,
+// such as "office;trace=never"
+func ParseGroups(groupsString string) (map[string]Group, error) {
+	specs, err := utils.GetIps(groupsString)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]Group)
+	for _, spec := range specs {
+		parts := strings.Split(spec, ";")
+		name := strings.TrimSpace(parts[0])
+		if _, ok := groups[name]; ok {
+			return nil, fmt.Errorf("Group %s is given more than once", name)
+		}
+
+		group := Group{Labels: make(map[string]string)}
+		for _, label := range parts[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(label), "=")
+			if !ok {
+				return nil, fmt.Errorf("Expected name=value for label %q of group %s", label, name)
+			}
+			if key == "trace" {
+				if group.Trace, err = parseTraceMode(value); err != nil {
+					return nil, fmt.Errorf("Invalid trace mode of group %s: %w", name, err)
+				}
+				continue
+			}
+			if err := validTargetLabel(key); err != nil {
+				return nil, fmt.Errorf("Invalid label of group %s: %w", name, err)
+			}
+			group.Labels[key] = value
+		}
+		groups[name] = group
+	}
+	return groups, nil
+}
+
+// targetLabelNames returns the names of the labels added to the ping
+// metrics, which have to be known before they're registered. They're the
+// labels given on the command line, and any others given to targets or
+// groups in the configuration
+func targetLabelNames(declared []string, targets []Target, groups map[string]Group) ([]string, error) {
+	names := make([]string, 0)
+	for _, name := range declared {
+		if err := validTargetLabel(name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	for _, t := range targets {
+		names = slices.AppendSeq(names, maps.Keys(t.Labels))
+	}
+	for _, group := range groups {
+		names = slices.AppendSeq(names, maps.Keys(group.Labels))
+	}
+
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseGroups(t *testing.T) {
+	groups, err := ParseGroups("dns;provider=cloudflare;criticality=high, office;trace=never")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dns := groups["dns"]
+	if len(groups) != 2 || dns.Labels["provider"] != "cloudflare" || dns.Labels["criticality"] != "high" || dns.Trace != "" {
+		t.Errorf("Unexpected groups %v", groups)
+	}
+	if office, ok := groups["office"]; !ok || len(office.Labels) != 0 || office.Trace != TraceNever {
+		t.Errorf("Expected office to have no labels and never be traced, got %v", groups["office"])
+	}
+
+	for _, s := range []string{"dns;provider", "dns;role=x", "dns;1st=x", "dns, dns", "dns;trace=sometimes"} {
+		if _, err := ParseGroups(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestTargetLabelNames(t *testing.T) {
+	targets, err := ParseTargets("8.8.8.8;label.provider=google, 1.1.1.1;label.provider=cloudflare;label.tier=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := map[string]Group{"dns": {Labels: map[string]string{"criticality": "high"}}}
+
+	names, err := targetLabelNames([]string{"site"}, targets, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(names, []string{"criticality", "provider", "site", "tier"}) {
+		t.Errorf("Unexpected label names %v", names)
+	}
+
+	if _, err := targetLabelNames([]string{"interface"}, nil, nil); err == nil {
+		t.Error("Expected an error for a reserved label")
+	}
+}
@@ -3,34 +3,142 @@ package config
 import (
 	"flag"
 	"log/slog"
+	"network_monitor/internal/discovery"
 	"network_monitor/internal/utils"
 	"os"
+	"slices"
 )
 
 const defaultIps = "8.8.8.8"
 
 type Opts struct {
-	PingIps               []string
-	PingInterval          int // In seconds
-	TraceFrequency        int // In iterations
-	TraceTimeoutThreshold int
-	LogLevel              slog.Level
-	ServerPort            string
+	Targets                []Target
+	PingIps                []string // The IPs of Targets
+	PingInterfaces         []string // Targets without a bind are pinged over each of these
+	PingInterval           int      // In seconds
+	TraceFrequency         int      // In iterations
+	TraceTimeoutThreshold  int
+	TraceWorkers           int
+	MultipathEndpoint      bool // Serves multipath discovery at /multipath
+	TraceMethod            string
+	TracePort              int
+	TraceParis             bool
+	TraceProbes            int // Probes sent per hop
+	TraceMaxHops           int
+	TraceMaxSilentHops     int
+	TracePolicy            TracePolicy
+	TraceTimeout           int    // In seconds
+	ASNDatabase            string // Path to an MMDB or iptoasn TSV file
+	RDNSPositiveTTL        int    // In seconds
+	RDNSNegativeTTL        int    // In seconds
+	RDNSTimeout            int    // In seconds
+	RDNSConcurrency        int
+	PMTUInterval           int // In seconds, 0 disables path MTU discovery
+	PMTUMax                int
+	PMTUAlertBelow         int
+	DiscoverInterval       int    // In seconds, 0 disables gateway discovery
+	ISPEdgeProbeIP         string // Traced towards to find the ISP edge
+	Sweeps                 []Sweep
+	SweepInterval          int // In seconds
+	SweepExpireAfter       int // Sweeps a host can miss before it's forgotten
+	FileSDPaths            []string
+	FileSDRefresh          int // In seconds
+	KubernetesMeshes       []discovery.KubernetesMesh
+	KubernetesNodeSelector string // Label selector for node meshes
+	KubernetesPodSelector  string // Label selector for pod meshes
+	KubernetesNamespace    string
+	KubernetesNodeName     string
+	KubernetesPodName      string
+	KubernetesInterval     int              // In seconds
+	Groups                 map[string]Group // Labels and trace mode of each group
+	TargetLabels           []string         // Target labels added to the ping metrics
+	APITokensFile          string           // Enables the targets API when set
+	APIStateFile           string           // Where targets added through the API are kept
+	APIAuditFile           string
+	OTLPEndpoint           string // Enables OTLP export when set
+	OTLPProtocol           string
+	OTLPInterval           int // In seconds
+	OTLPHeaders            map[string]string
+	OTLPResourceAttributes map[string]string
+	RemoteWriteURL         string // Enables remote_write when set
+	RemoteWriteInterval    int    // In seconds
+	RemoteWriteHeaders     map[string]string
+	RemoteWriteLabels      map[string]string // Added to every pushed series
+	RemoteWriteWALDir      string
+	RemoteWriteWALMaxMB    int
+	RemoteWriteWALMaxAge   int    // In seconds, 0 keeps requests until they're sent
+	PushgatewayURL         string // Enables pushing to a Pushgateway when set
+	PushgatewayJob         string
+	PushgatewayInterval    int // In seconds
+	PushgatewayGrouping    map[string]string
+	InfluxURL              string // Enables the InfluxDB sink when set
+	InfluxToken            string
+	GraphiteAddr           string // Enables the Graphite sink when set
+	GraphitePrefix         string
+	GraphiteTags           bool
+	SinkBatchSize          int
+	SinkFlushInterval      int // In seconds
+	SinkQueueSize          int
+	SinkRetries            int
+	MTRIps                 []string
+	MTRInterval            int // In seconds
+	MTRWindow              int // In rounds
+	LogLevel               slog.Level
+	ServerPort             string
 }
 
 func NewOpts() Opts {
-	pingIps, err := utils.GetIps(defaultIps)
+	targets, err := ParseTargets(defaultIps)
 	if err != nil {
 		slog.Error("Default IPs can't be parsed", "error", err, "ips", defaultIps)
 		os.Exit(1)
 	}
 
+	tracePolicy, err := ParseTracePolicy(defaultTracePolicy)
+	if err != nil {
+		slog.Error("Default trace policy can't be parsed", "error", err, "policy", defaultTracePolicy)
+		os.Exit(1)
+	}
+
 	opts := Opts{
-		PingIps:               pingIps,
+		Targets:               targets,
+		PingIps:               targetIps(targets),
 		LogLevel:              slog.LevelInfo,
 		PingInterval:          15,
 		TraceFrequency:        20,
 		TraceTimeoutThreshold: 5,
+		TraceWorkers:          2,
+		TraceMethod:           "icmp",
+		TraceProbes:           3,
+		TraceMaxHops:          30,
+		TraceMaxSilentHops:    5,
+		TraceTimeout:          3,
+		TracePolicy:           tracePolicy,
+		RDNSPositiveTTL:       3600,
+		RDNSNegativeTTL:       300,
+		RDNSTimeout:           2,
+		RDNSConcurrency:       4,
+		PMTUMax:               1500,
+		ISPEdgeProbeIP:        "8.8.8.8",
+		SweepInterval:         300,
+		SweepExpireAfter:      3,
+		FileSDRefresh:         300,
+		KubernetesPodSelector: "app=network-monitor",
+		KubernetesInterval:    60,
+		OTLPProtocol:          "grpc",
+		OTLPInterval:          60,
+		RemoteWriteInterval:   15,
+		RemoteWriteWALDir:     "/var/lib/network_monitor/remote_write_wal",
+		RemoteWriteWALMaxMB:   64,
+		PushgatewayJob:        "network-monitor",
+		PushgatewayInterval:   60,
+		GraphitePrefix:        "network_monitor",
+		SinkBatchSize:         1000,
+		SinkFlushInterval:     10,
+		SinkQueueSize:         10000,
+		SinkRetries:           3,
+		MTRInterval:           5,
+		MTRWindow:             10,
 		ServerPort:            "8080",
 	}
 
@@ -40,25 +148,280 @@ func NewOpts() Opts {
 }
 
 func (o *Opts) ParseFlags() {
-	stringIps := flag.String("ping-ips", defaultIps, "A comma-separated list of IPs to ping")
+	stringIps := flag.String("ping-ips", defaultIps, "A comma-separated list of IPs to ping. Each can be followed by ;-separated probe options: size, pattern (hex), df, dscp, tos, iface, src, trace, group or label.<name>")
+	pingInterfaces := flag.String("ping-interfaces", "", "A comma-separated list of interfaces to ping every IP over, instead of following the routing table")
 	pingInterval := flag.Int("ping-interval", o.PingInterval, "Interval betweeen pings in seconds")
 	traceFrequency := flag.Int("trace-frequency", o.TraceFrequency, "Will run a trace every x iterations of the loop")
 	traceTimeoutThreshold := flag.Int("trace-timeout-threshold", o.TraceTimeoutThreshold, "Will run a trace after x timeouts")
+	traceWorkers := flag.Int("trace-workers", o.TraceWorkers, "Number of traces that can run at the same time")
+	multipathEndpoint := flag.Bool("multipath-endpoint", false, "Serve multipath discovery at /multipath. It needs a bearer token from -api-tokens-file when that's set")
+	traceMethod := flag.String("trace-method", o.TraceMethod, "One of icmp, udp, or tcp")
+	tracePort := flag.Int("trace-port", o.TracePort, "Destination port for tcp traces, or base port for udp traces. Defaults to 80 and 33434")
+	traceParis := flag.Bool("trace-paris", o.TraceParis, "Keep every probe in a trace on the same path through load balancers")
+	traceProbes := flag.Int("trace-probes", o.TraceProbes, "Number of probes sent to each hop of a trace")
+	traceMaxHops := flag.Int("trace-max-hops", o.TraceMaxHops, "Maximum number of hops a trace will probe")
+	traceMaxSilentHops := flag.Int("trace-max-silent-hops", o.TraceMaxSilentHops, "A trace stops after x consecutive hops with no reply")
+	traceTimeout := flag.Int("trace-timeout", o.TraceTimeout, "Time to wait for trace replies in seconds")
+	tracePolicy := flag.String("trace-policy", defaultTracePolicy, "A comma-separated list of cidr=mode rules deciding when IPs are traced, the most specific CIDR wins. Modes are periodic, threshold, both or never, and rfc1918, cgnat, link-local, ula, loopback or default can be used as CIDRs")
+	asnDatabase := flag.String("asn-db", o.ASNDatabase, "Path to a MaxMind MMDB or iptoasn.com TSV file for looking up the AS of trace hops")
+	rdnsPositiveTTL := flag.Int("rdns-positive-ttl", o.RDNSPositiveTTL, "How long to cache hop names in seconds")
+	rdnsNegativeTTL := flag.Int("rdns-negative-ttl", o.RDNSNegativeTTL, "How long to cache failed hop name lookups in seconds")
+	rdnsTimeout := flag.Int("rdns-timeout", o.RDNSTimeout, "Timeout for each hop name lookup in seconds")
+	rdnsConcurrency := flag.Int("rdns-concurrency", o.RDNSConcurrency, "Number of hop name lookups that can run at the same time")
+	pmtuInterval := flag.Int("pmtu-interval", o.PMTUInterval, "Interval between path MTU discoveries in seconds, 0 disables them")
+	pmtuMax := flag.Int("pmtu-max", o.PMTUMax, "Largest packet size path MTU discovery will try")
+	pmtuAlertBelow := flag.Int("pmtu-alert-below", o.PMTUAlertBelow, "Warn when the path MTU to an IP drops below this size")
+	discoverInterval := flag.Int("discover-interval", o.DiscoverInterval, "Interval between default gateway and ISP edge discoveries in seconds, 0 disables them")
+	ispEdgeProbeIP := flag.String("isp-edge-probe-ip", o.ISPEdgeProbeIP, "IP traced towards to find the first public hop")
+	sweepCidrs := flag.String("sweep-cidrs", "", "A comma-separated list of IPv4 subnets to find live hosts in. Each can be followed by ;-separated options: rate, promote, iface or src")
+	sweepInterval := flag.Int("sweep-interval", o.SweepInterval, "Interval between sweeps of each subnet in seconds")
+	sweepExpireAfter := flag.Int("sweep-expire-after", o.SweepExpireAfter, "Sweeps in a row a host can miss before it's forgotten, its series deleted and it stops being pinged if promoted")
+	sweepRate := flag.Int("sweep-rate", 20, "Echo requests sent per second while sweeping a subnet")
+	sweepPromote := flag.Bool("sweep-promote", false, "Ping hosts found by sweeps like any other IP")
+	sweepMaxHosts := flag.Int("sweep-max-hosts", 1024, "Largest number of hosts a swept subnet can have")
+	fileSDPaths := flag.String("file-sd", "", "A comma-separated list of Prometheus file_sd JSON or YAML files to ping the targets of, the file names can be globs")
+	fileSDRefresh := flag.Int("file-sd-refresh", o.FileSDRefresh, "Interval between rereading every file_sd file in seconds, changes are usually seen straight away")
+	k8sMesh := flag.String("k8s-mesh", "", "A comma-separated list of nodes or pods, to ping every other Kubernetes node or monitor pod")
+	k8sNodeSelector := flag.String("k8s-node-selector", o.KubernetesNodeSelector, "Label selector for the nodes in a node mesh")
+	k8sPodSelector := flag.String("k8s-pod-selector", o.KubernetesPodSelector, "Label selector for the monitor pods in a pod mesh")
+	k8sNamespace := flag.String("k8s-namespace", "", "Namespace of the monitor pods, defaults to the namespace the monitor runs in")
+	k8sNodeName := flag.String("k8s-node-name", os.Getenv("NODE_NAME"), "Name of the node the monitor runs on, defaults to $NODE_NAME")
+	k8sPodName := flag.String("k8s-pod-name", os.Getenv("POD_NAME"), "Name of the pod the monitor runs in, defaults to $POD_NAME")
+	k8sInterval := flag.Int("k8s-interval", o.KubernetesInterval, "Interval between listing Kubernetes peers in seconds")
+	groups := flag.String("groups", "", "A comma-separated list of target groups, each followed by ;-separated name=value labels that the targets in the group get. trace=mode sets when the group's targets are traced")
+	targetLabels := flag.String("target-labels", "", "A comma-separated list of label names added to the ping metrics, for labels given to targets by discovery or the API. Labels given to targets and groups on the command line are always added")
+	apiTokensFile := flag.String("api-tokens-file", "", "File of name:token lines, one for each client of the targets API. The API is disabled without it")
+	apiStateFile := flag.String("api-state-file", "", "File that targets added through the API are saved to, so they're kept across restarts")
+	apiAuditFile := flag.String("api-audit-file", "", "File that changes made through the API are logged to as JSON, instead of the main log")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "URL of an OpenTelemetry collector to push metrics to with OTLP, such as http://collector:4317 for grpc or http://collector:4318 for http. Endpoints starting with http:// don't use TLS")
+	otlpProtocol := flag.String("otlp-protocol", o.OTLPProtocol, "One of grpc or http")
+	otlpInterval := flag.Int("otlp-interval", o.OTLPInterval, "Interval between OTLP pushes in seconds")
+	otlpHeaders := flag.String("otlp-headers", "", "A comma-separated list of name=value headers sent with every OTLP push")
+	otlpResourceAttributes := flag.String("otlp-resource-attributes", "", "A comma-separated list of name=value attributes describing the monitor in OTLP pushes, such as site=lon1")
+	remoteWriteURL := flag.String("remote-write-url", "", "URL of a Prometheus remote_write endpoint to push metrics to, such as http://prometheus:9090/api/v1/write")
+	remoteWriteInterval := flag.Int("remote-write-interval", o.RemoteWriteInterval, "Interval between collections pushed with remote_write in seconds")
+	flag.Func("remote-write-header", "A name: value header sent with every remote_write request, such as \"Authorization: Bearer token\". Can be given more than once", func(s string) error {
+		if o.RemoteWriteHeaders == nil {
+			o.RemoteWriteHeaders = make(map[string]string)
+		}
+		return AddHeader(o.RemoteWriteHeaders, s)
+	})
+	remoteWriteLabels := flag.String("remote-write-labels", "", "A comma-separated list of name=value labels added to every series pushed with remote_write. job and instance default to network-monitor and the host name")
+	remoteWriteWALDir := flag.String("remote-write-wal-dir", o.RemoteWriteWALDir, "Directory that remote_write requests are kept in until they're sent")
+	remoteWriteWALMaxMB := flag.Int("remote-write-wal-max-mb", o.RemoteWriteWALMaxMB, "Largest size of the remote_write WAL in MiB, the oldest requests are dropped beyond it")
+	remoteWriteWALMaxAge := flag.Int("remote-write-wal-max-age", 0, "Oldest remote_write request to send in seconds, older ones are dropped. Set it to the out-of-order window of the receiver, which rejects older samples. 0 keeps requests until they're sent")
+	pushgatewayURL := flag.String("pushgateway-url", "", "URL of a Pushgateway to push metrics to")
+	pushgatewayJob := flag.String("pushgateway-job", o.PushgatewayJob, "Job name metrics are pushed to the Pushgateway under")
+	pushgatewayInterval := flag.Int("pushgateway-interval", o.PushgatewayInterval, "Interval between pushes to the Pushgateway in seconds")
+	pushgatewayGrouping := flag.String("pushgateway-grouping", "", "A comma-separated list of name=value labels grouping the pushed metrics. instance defaults to the host name")
+	influxURL := flag.String("influx-url", "", "InfluxDB write URL to send every probe result to, such as http://influx:8086/api/v2/write?org=ops&bucket=pings or udp://telegraf:8089")
+	influxToken := flag.String("influx-token", os.Getenv("INFLUX_TOKEN"), "API token for InfluxDB writes over HTTP, defaults to $INFLUX_TOKEN")
+	graphiteAddr := flag.String("graphite-addr", "", "host:port of a Carbon plaintext listener to send every probe result to")
+	graphitePrefix := flag.String("graphite-prefix", o.GraphitePrefix, "Prefix of the Graphite paths")
+	graphiteTags := flag.Bool("graphite-tags", o.GraphiteTags, "Send the target as Graphite tags instead of in the path")
+	sinkBatchSize := flag.Int("sink-batch-size", o.SinkBatchSize, "Largest number of probe results written to a sink at once")
+	sinkFlushInterval := flag.Int("sink-flush-interval", o.SinkFlushInterval, "Longest time probe results wait before they're written to a sink in seconds")
+	sinkQueueSize := flag.Int("sink-queue-size", o.SinkQueueSize, "Number of probe results that can wait for each sink, beyond it they're dropped")
+	sinkRetries := flag.Int("sink-retries", o.SinkRetries, "Number of times a failed write to a sink is retried before its results are dropped")
+	mtrIps := flag.String("mtr-ips", "", "A comma-separated list of IPs to continuously probe every hop of")
+	mtrInterval := flag.Int("mtr-interval", o.MTRInterval, "Interval between MTR rounds in seconds")
+	mtrWindow := flag.Int("mtr-window", o.MTRWindow, "Number of recent MTR rounds that hop statistics cover")
 	logLevel := flag.String("log-level", o.LogLevel.String(), "One of ERROR, WARN, INFO, or DEBUG")
 	serverPort := flag.String("server-port", o.ServerPort, "Port to serve metrics on")
 
 	flag.Parse()
 
-	pingIps, err := utils.GetIps(*stringIps)
+	targets, err := ParseTargets(*stringIps)
 	if err != nil {
-		slog.Error("Default IPs can't be parsed", "error", err, "ips", stringIps)
+		slog.Error("Ping IPs can't be parsed", "error", err, "ips", *stringIps)
+		os.Exit(1)
+	}
+
+	if *pingInterfaces != "" {
+		o.PingInterfaces, err = utils.GetIps(*pingInterfaces)
+		if err != nil {
+			slog.Error("Ping interfaces can't be parsed", "error", err, "interfaces", *pingInterfaces)
+			os.Exit(1)
+		}
+	}
+
+	o.Targets = expandInterfaces(targets, o.PingInterfaces)
+	o.PingIps = targetIps(targets)
+
+	if *sweepCidrs != "" {
+		defaults := Sweep{Rate: *sweepRate, Promote: *sweepPromote}
+		o.Sweeps, err = ParseSweeps(*sweepCidrs, defaults, *sweepMaxHosts)
+		if err != nil {
+			slog.Error("Sweep CIDRs can't be parsed", "error", err, "cidrs", *sweepCidrs)
+			os.Exit(1)
+		}
+	}
+	if *sweepExpireAfter < 1 {
+		slog.Error("Sweep expiry must be at least 1 sweep", "expire_after", *sweepExpireAfter)
 		os.Exit(1)
 	}
+	if *remoteWriteWALMaxAge < 0 {
+		slog.Error("Remote write WAL max age can't be negative", "max_age", *remoteWriteWALMaxAge)
+		os.Exit(1)
+	}
+
+	if *fileSDPaths != "" {
+		o.FileSDPaths, err = utils.GetIps(*fileSDPaths)
+		if err != nil {
+			slog.Error("file_sd paths can't be parsed", "error", err, "paths", *fileSDPaths)
+			os.Exit(1)
+		}
+	}
+
+	if *k8sMesh != "" {
+		meshes, err := utils.GetIps(*k8sMesh)
+		if err == nil {
+			o.KubernetesMeshes, err = parseMeshes(meshes)
+		}
+		if err != nil {
+			slog.Error("Kubernetes mesh can't be parsed", "error", err, "mesh", *k8sMesh)
+			os.Exit(1)
+		}
+	}
 
-	o.PingIps = pingIps
+	if *groups != "" {
+		o.Groups, err = ParseGroups(*groups)
+		if err != nil {
+			slog.Error("Groups can't be parsed", "error", err, "groups", *groups)
+			os.Exit(1)
+		}
+	}
+
+	declared := make([]string, 0)
+	if *targetLabels != "" {
+		declared, err = utils.GetIps(*targetLabels)
+		if err != nil {
+			slog.Error("Target labels can't be parsed", "error", err, "labels", *targetLabels)
+			os.Exit(1)
+		}
+	}
+	if len(o.KubernetesMeshes) > 0 {
+		declared = append(declared, "src_node", "dst_node")
+	}
+	o.TargetLabels, err = targetLabelNames(declared, targets, o.Groups)
+	if err != nil {
+		slog.Error("Target labels can't be parsed", "error", err, "labels", *targetLabels)
+		os.Exit(1)
+	}
+
+	if !slices.Contains(otlpProtocols, *otlpProtocol) {
+		slog.Error("Unknown OTLP protocol", "protocol", *otlpProtocol)
+		os.Exit(1)
+	}
+	if *otlpHeaders != "" {
+		o.OTLPHeaders, err = ParseKeyValues(*otlpHeaders)
+		if err != nil {
+			slog.Error("OTLP headers can't be parsed", "error", err)
+			os.Exit(1)
+		}
+	}
+	if *otlpResourceAttributes != "" {
+		o.OTLPResourceAttributes, err = ParseKeyValues(*otlpResourceAttributes)
+		if err != nil {
+			slog.Error("OTLP resource attributes can't be parsed", "error", err, "attributes", *otlpResourceAttributes)
+			os.Exit(1)
+		}
+	}
+
+	if *remoteWriteLabels != "" {
+		o.RemoteWriteLabels, err = ParseKeyValues(*remoteWriteLabels)
+		if err == nil {
+			err = validLabelNames(o.RemoteWriteLabels)
+		}
+		if err != nil {
+			slog.Error("Remote write labels can't be parsed", "error", err, "labels", *remoteWriteLabels)
+			os.Exit(1)
+		}
+	}
+	if *pushgatewayGrouping != "" {
+		o.PushgatewayGrouping, err = ParseKeyValues(*pushgatewayGrouping)
+		if err == nil {
+			err = validLabelNames(o.PushgatewayGrouping)
+		}
+		if err != nil {
+			slog.Error("Pushgateway grouping can't be parsed", "error", err, "grouping", *pushgatewayGrouping)
+			os.Exit(1)
+		}
+	}
+
+	if *sinkBatchSize < 1 || *sinkFlushInterval < 1 || *sinkQueueSize < 0 || *sinkRetries < 0 {
+		slog.Error("Sink batch size and flush interval must be at least 1, and the queue size and retries can't be negative")
+		os.Exit(1)
+	}
+
+	if *mtrIps != "" {
+		o.MTRIps, err = utils.GetIps(*mtrIps)
+		if err != nil {
+			slog.Error("MTR IPs can't be parsed", "error", err, "ips", *mtrIps)
+			os.Exit(1)
+		}
+	}
 	o.PingInterval = *pingInterval
 	o.TraceFrequency = *traceFrequency
 	o.TraceTimeoutThreshold = *traceTimeoutThreshold
+	o.TraceWorkers = *traceWorkers
+	o.MultipathEndpoint = *multipathEndpoint
+	o.TraceMethod = *traceMethod
+	o.TracePort = *tracePort
+	o.TraceParis = *traceParis
+	o.TraceProbes = *traceProbes
+	o.TraceMaxHops = *traceMaxHops
+	o.TraceMaxSilentHops = *traceMaxSilentHops
+	o.TraceTimeout = *traceTimeout
+	o.TracePolicy, err = ParseTracePolicy(*tracePolicy)
+	if err != nil {
+		slog.Error("Trace policy can't be parsed", "error", err, "policy", *tracePolicy)
+		os.Exit(1)
+	}
+	o.ASNDatabase = *asnDatabase
+	o.RDNSPositiveTTL = *rdnsPositiveTTL
+	o.RDNSNegativeTTL = *rdnsNegativeTTL
+	o.RDNSTimeout = *rdnsTimeout
+	o.RDNSConcurrency = *rdnsConcurrency
+	o.PMTUInterval = *pmtuInterval
+	o.PMTUMax = *pmtuMax
+	o.PMTUAlertBelow = *pmtuAlertBelow
+	o.DiscoverInterval = *discoverInterval
+	o.ISPEdgeProbeIP = *ispEdgeProbeIP
+	o.SweepInterval = *sweepInterval
+	o.SweepExpireAfter = *sweepExpireAfter
+	o.FileSDRefresh = *fileSDRefresh
+	o.KubernetesNodeSelector = *k8sNodeSelector
+	o.KubernetesPodSelector = *k8sPodSelector
+	o.KubernetesNamespace = *k8sNamespace
+	o.KubernetesNodeName = *k8sNodeName
+	o.KubernetesPodName = *k8sPodName
+	o.KubernetesInterval = *k8sInterval
+	o.APITokensFile = *apiTokensFile
+	o.APIStateFile = *apiStateFile
+	o.APIAuditFile = *apiAuditFile
+	o.OTLPEndpoint = *otlpEndpoint
+	o.OTLPProtocol = *otlpProtocol
+	o.OTLPInterval = *otlpInterval
+	o.RemoteWriteURL = *remoteWriteURL
+	o.RemoteWriteInterval = *remoteWriteInterval
+	o.RemoteWriteWALDir = *remoteWriteWALDir
+	o.RemoteWriteWALMaxMB = *remoteWriteWALMaxMB
+	o.RemoteWriteWALMaxAge = *remoteWriteWALMaxAge
+	o.PushgatewayURL = *pushgatewayURL
+	o.PushgatewayJob = *pushgatewayJob
+	o.PushgatewayInterval = *pushgatewayInterval
+	o.InfluxURL = *influxURL
+	o.InfluxToken = *influxToken
+	o.GraphiteAddr = *graphiteAddr
+	o.GraphitePrefix = *graphitePrefix
+	o.GraphiteTags = *graphiteTags
+	o.SinkBatchSize = *sinkBatchSize
+	o.SinkFlushInterval = *sinkFlushInterval
+	o.SinkQueueSize = *sinkQueueSize
+	o.SinkRetries = *sinkRetries
+	o.MTRInterval = *mtrInterval
+	o.MTRWindow = *mtrWindow
 	o.ServerPort = *serverPort
 
 	switch *logLevel {
@@ -72,3 +435,15 @@ func (o *Opts) ParseFlags() {
 		o.LogLevel = slog.LevelError
 	}
 }
+
+func parseMeshes(values []string) ([]discovery.KubernetesMesh, error) {
+	meshes := make([]discovery.KubernetesMesh, 0, len(values))
+	for _, value := range values {
+		mesh, err := discovery.ParseKubernetesMesh(value)
+		if err != nil {
+			return nil, err
+		}
+		meshes = append(meshes, mesh)
+	}
+	return meshes, nil
+}
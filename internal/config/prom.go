@@ -1,42 +1,341 @@
 package config
 
 import (
+	"slices"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Metrics struct {
-	TotalPingsCounter  *prometheus.CounterVec
-	TotalTimoutCounter *prometheus.CounterVec
-	DurationHist       *prometheus.HistogramVec
+	TotalPingsCounter    *prometheus.CounterVec
+	TotalTimoutCounter   *prometheus.CounterVec
+	DurationHist         *prometheus.HistogramVec
+	CorruptedCounter     *prometheus.CounterVec
+	RepliesCounter       *prometheus.CounterVec
+	ClockAnomalies       *prometheus.CounterVec
+	TimestampDiscrepancy prometheus.Histogram
+	ReplyTTL             *prometheus.GaugeVec
+	ReplyHops            *prometheus.GaugeVec
+	ReplyTTLChanges      *prometheus.CounterVec
+	MTRLoss              *prometheus.GaugeVec
+	MTRLast              *prometheus.GaugeVec
+	MTRAvg               *prometheus.GaugeVec
+	MTRBest              *prometheus.GaugeVec
+	MTRWorst             *prometheus.GaugeVec
+	MTRStdDev            *prometheus.GaugeVec
+	RDNSCacheHits        prometheus.Counter
+	RDNSCacheMisses      prometheus.Counter
+	PMTU                 *prometheus.GaugeVec
+	PMTUBelowThreshold   *prometheus.GaugeVec
+	PMTUFragNeeded       *prometheus.GaugeVec
+	SweepHostsAlive      *prometheus.GaugeVec
+	SweepHosts           *prometheus.GaugeVec
+	SweepHostUp          *prometheus.GaugeVec
+	GroupTargets         *prometheus.GaugeVec
+	GroupTargetsUp       *prometheus.GaugeVec
+	GroupUpRatio         *prometheus.GaugeVec
+	RemoteWriteSent      prometheus.Counter
+	RemoteWriteFailed    prometheus.Counter
+	RemoteWriteDropped   *prometheus.CounterVec
+	RemoteWriteWALBytes  prometheus.Gauge
+	RemoteWriteWALQueued prometheus.Gauge
+	PushgatewayFailed    prometheus.Counter
+	SinkResultsWritten   *prometheus.CounterVec
+	SinkResultsDropped   *prometheus.CounterVec
+	SinkWriteErrors      *prometheus.CounterVec
 }
 
-func NewMetrics(reg *prometheus.Registry) *Metrics {
+// NewMetrics registers the metrics, with targetLabels added to the ping
+// metrics for labels given to targets such as by discovery providers
+func NewMetrics(reg *prometheus.Registry, targetLabels []string) *Metrics {
+	// The same IP can be pinged over several interfaces, role is set for
+	// discovered targets such as the default gateway
+	pingLabels := slices.Concat([]string{"ip", "interface", "role", "group"}, targetLabels)
+	mtrLabels := []string{"ip", "interface", "hop", "addr"}
+
 	m := &Metrics{
-		prometheus.NewCounterVec(
+		TotalPingsCounter: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "ping_total",
 				Help: "Total number of pings made",
 			},
-			[]string{"ip"},
+			pingLabels,
 		),
-		prometheus.NewCounterVec(
+		TotalTimoutCounter: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "ping_total_timeouts",
 				Help: "Total number of requests which timed out",
 			},
-			[]string{"ip"},
+			pingLabels,
 		),
-		prometheus.NewHistogramVec(
+		DurationHist: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "ping_request_duration_seconds",
 				Help:    "Duration of the ping request in seconds",
 				Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.0, 4.0, 8.0, 16.0},
 			},
-			[]string{"ip"},
+			pingLabels,
+		),
+		CorruptedCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ping_corrupted_replies_total",
+				Help: "Total number of replies whose payload didn't match what was sent",
+			},
+			pingLabels,
+		),
+		RepliesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ping_replies_total",
+				Help: "Total number of echo replies by whether they were on time, late, duplicate or out of order",
+			},
+			slices.Concat(pingLabels, []string{"class"}),
+		),
+		ClockAnomalies: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ping_clock_anomalies_total",
+				Help: "Total number of replies during which the wall clock jumped, their RTT is measured with the monotonic clock",
+			},
+			pingLabels,
+		),
+		TimestampDiscrepancy: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "ping_timestamp_discrepancy_seconds",
+				Help:    "How much longer the RTT measured in userspace is than the one from kernel timestamps",
+				Buckets: []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1},
+			},
+		),
+		ReplyTTL: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ping_reply_ttl",
+				Help: "IP TTL of the latest echo reply",
+			},
+			pingLabels,
+		),
+		ReplyHops: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ping_reply_hops",
+				Help: "Number of hops on the return path, inferred from the reply TTL",
+			},
+			pingLabels,
+		),
+		ReplyTTLChanges: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ping_reply_ttl_changes_total",
+				Help: "Total number of times the reply TTL changed, a sign the return path changed",
+			},
+			pingLabels,
+		),
+		MTRLoss: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mtr_hop_loss_ratio",
+				Help: "Fraction of MTR probes to the hop that went unanswered",
+			},
+			mtrLabels,
+		),
+		MTRLast: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mtr_hop_last_seconds",
+				Help: "Round trip time of the latest MTR reply from the hop",
+			},
+			mtrLabels,
+		),
+		MTRAvg: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mtr_hop_avg_seconds",
+				Help: "Average round trip time of MTR replies from the hop",
+			},
+			mtrLabels,
+		),
+		MTRBest: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mtr_hop_best_seconds",
+				Help: "Fastest round trip time of MTR replies from the hop",
+			},
+			mtrLabels,
+		),
+		MTRWorst: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mtr_hop_worst_seconds",
+				Help: "Slowest round trip time of MTR replies from the hop",
+			},
+			mtrLabels,
+		),
+		MTRStdDev: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mtr_hop_stddev_seconds",
+				Help: "Standard deviation of the round trip time of MTR replies from the hop",
+			},
+			mtrLabels,
+		),
+		RDNSCacheHits: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "rdns_cache_hits_total",
+				Help: "Total number of reverse DNS lookups answered from the cache",
+			},
+		),
+		RDNSCacheMisses: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "rdns_cache_misses_total",
+				Help: "Total number of reverse DNS lookups sent to the resolver",
+			},
+		),
+		PMTU: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pmtu_bytes",
+				Help: "Largest packet that reaches the IP without fragmentation",
+			},
+			[]string{"ip", "interface"},
+		),
+		PMTUBelowThreshold: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pmtu_below_threshold",
+				Help: "1 if the path MTU to the IP is below the configured alert threshold",
+			},
+			[]string{"ip", "interface"},
+		),
+		PMTUFragNeeded: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "pmtu_fragmentation_needed_info",
+				Help: "The hop that sent fragmentation needed during the latest path MTU discovery, and the MTU it reported",
+			},
+			[]string{"ip", "interface", "hop", "reported_mtu"},
+		),
+		SweepHostsAlive: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sweep_hosts_alive",
+				Help: "Number of hosts in the subnet that replied to the latest sweep",
+			},
+			[]string{"cidr"},
+		),
+		SweepHosts: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sweep_hosts",
+				Help: "Number of host addresses in the swept subnet",
+			},
+			[]string{"cidr"},
+		),
+		SweepHostUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sweep_host_up",
+				Help: "1 if the host replied to the latest sweep, for every host that has replied to one since it was last forgotten",
+			},
+			[]string{"cidr", "ip"},
+		),
+		GroupTargets: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "group_targets",
+				Help: "Number of targets in the group, counting each interface a target is pinged over",
+			},
+			[]string{"group"},
+		),
+		GroupTargetsUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "group_targets_up",
+				Help: "Number of targets in the group that replied in their latest interval",
+			},
+			[]string{"group"},
+		),
+		GroupUpRatio: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "group_up_ratio",
+				Help: "Fraction of the targets in the group that replied in their latest interval",
+			},
+			[]string{"group"},
+		),
+		RemoteWriteSent: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "remote_write_requests_sent_total",
+				Help: "Number of remote_write requests accepted by the endpoint",
+			},
+		),
+		RemoteWriteFailed: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "remote_write_requests_failed_total",
+				Help: "Number of remote_write attempts that failed and will be retried",
+			},
+		),
+		RemoteWriteDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "remote_write_requests_dropped_total",
+				Help: "Number of remote_write requests given up on, because the endpoint rejected them, the WAL was full or they expired",
+			},
+			[]string{"reason"},
+		),
+		RemoteWriteWALBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "remote_write_wal_bytes",
+				Help: "Size of the remote_write requests waiting in the WAL",
+			},
+		),
+		RemoteWriteWALQueued: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "remote_write_wal_requests",
+				Help: "Number of remote_write requests waiting in the WAL",
+			},
+		),
+		PushgatewayFailed: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "pushgateway_pushes_failed_total",
+				Help: "Number of pushes to the Pushgateway that failed",
+			},
+		),
+		SinkResultsWritten: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sink_results_written_total",
+				Help: "Number of probe results written to each sink",
+			},
+			[]string{"sink"},
+		),
+		SinkResultsDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sink_results_dropped_total",
+				Help: "Number of probe results dropped because the sink's queue was full or writing them kept failing",
+			},
+			[]string{"sink", "reason"},
+		),
+		SinkWriteErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sink_write_errors_total",
+				Help: "Number of failed attempts to write a batch to each sink",
+			},
+			[]string{"sink"},
 		),
 	}
 	reg.MustRegister(m.TotalPingsCounter)
 	reg.MustRegister(m.TotalTimoutCounter)
 	reg.MustRegister(m.DurationHist)
+	reg.MustRegister(m.CorruptedCounter)
+	reg.MustRegister(m.RepliesCounter)
+	reg.MustRegister(m.ClockAnomalies)
+	reg.MustRegister(m.TimestampDiscrepancy)
+	reg.MustRegister(m.ReplyTTL)
+	reg.MustRegister(m.ReplyHops)
+	reg.MustRegister(m.ReplyTTLChanges)
+	reg.MustRegister(m.MTRLoss)
+	reg.MustRegister(m.MTRLast)
+	reg.MustRegister(m.MTRAvg)
+	reg.MustRegister(m.MTRBest)
+	reg.MustRegister(m.MTRWorst)
+	reg.MustRegister(m.MTRStdDev)
+	reg.MustRegister(m.RDNSCacheHits)
+	reg.MustRegister(m.RDNSCacheMisses)
+	reg.MustRegister(m.PMTU)
+	reg.MustRegister(m.PMTUBelowThreshold)
+	reg.MustRegister(m.PMTUFragNeeded)
+	reg.MustRegister(m.SweepHostsAlive)
+	reg.MustRegister(m.SweepHosts)
+	reg.MustRegister(m.SweepHostUp)
+	reg.MustRegister(m.GroupTargets)
+	reg.MustRegister(m.GroupTargetsUp)
+	reg.MustRegister(m.GroupUpRatio)
+	reg.MustRegister(m.RemoteWriteSent)
+	reg.MustRegister(m.RemoteWriteFailed)
+	reg.MustRegister(m.RemoteWriteDropped)
+	reg.MustRegister(m.RemoteWriteWALBytes)
+	reg.MustRegister(m.RemoteWriteWALQueued)
+	reg.MustRegister(m.PushgatewayFailed)
+	reg.MustRegister(m.SinkResultsWritten)
+	reg.MustRegister(m.SinkResultsDropped)
+	reg.MustRegister(m.SinkWriteErrors)
 	return m
 }
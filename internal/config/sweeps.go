@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"network_monitor/internal/network"
+	"network_monitor/internal/utils"
+	"strconv"
+	"strings"
+)
+
+// maxSweepHosts is the most hosts a sweep can have whatever -sweep-max-hosts
+// is set to, sweep echo sequences have to be unique
+const maxSweepHosts = 65536
+
+type Sweep struct {
+	CIDR    *net.IPNet
+	Rate    int  // Echo requests sent per second
+	Promote bool // Hosts that reply become regular targets
+	Bind    network.Bind
+}
+
+// ParseSweeps reads a comma-separated list of IPv4 CIDRs, each of which can
+// be followed by semicolon-separated options, such as
+// "192.168.1.0/24;rate=50;promote=true, 10.0.0.0/24;iface=eth1". Subnets with
+// more than maxHosts hosts are rejected
+func ParseSweeps(sweepsString string, defaults Sweep, maxHosts int) ([]Sweep, error) {
+	specs, err := utils.GetIps(sweepsString)
+	if err != nil {
+		return nil, err
+	}
+
+	sweeps := make([]Sweep, 0, len(specs))
+	for _, spec := range specs {
+		s, err := parseSweep(spec, defaults, min(maxHosts, maxSweepHosts))
+		if err != nil {
+			return nil, err
+		}
+		sweeps = append(sweeps, s)
+	}
+
+	return sweeps, nil
+}
+
+func parseSweep(spec string, defaults Sweep, maxHosts int) (Sweep, error) {
+	parts := strings.Split(spec, ";")
+	cidr := strings.TrimSpace(parts[0])
+
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return Sweep{}, err
+	}
+	if subnet.IP.To4() == nil {
+		return Sweep{}, fmt.Errorf("Only IPv4 subnets can be swept, got %s", cidr)
+	}
+	if size := network.SubnetSize(subnet); size.Cmp(big.NewInt(int64(maxHosts))) > 0 {
+		return Sweep{}, fmt.Errorf("%s has %s hosts, more than the limit of %d", cidr, size, maxHosts)
+	}
+
+	s := defaults
+	s.CIDR = subnet
+	for _, opt := range parts[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(opt), "=")
+		if !ok {
+			return Sweep{}, fmt.Errorf("Expected key=value for option %q of %s", opt, cidr)
+		}
+		if err := s.setOpt(key, value); err != nil {
+			return Sweep{}, fmt.Errorf("Invalid option %q of %s: %w", opt, cidr, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Sweep) setOpt(key string, value string) error {
+	var err error
+	switch key {
+	case "rate":
+		s.Rate, err = parseRange(value, 1, 10000)
+	case "promote":
+		s.Promote, err = strconv.ParseBool(value)
+	case "iface":
+		s.Bind.Interface = value
+	case "src":
+		s.Bind.Source = net.ParseIP(value).To4()
+		if s.Bind.Source == nil {
+			err = fmt.Errorf("Must be an IPv4 address")
+		}
+	default:
+		err = fmt.Errorf("Unknown option")
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseSweeps(t *testing.T) {
+	defaults := Sweep{Rate: 20}
+	sweeps, err := ParseSweeps("192.168.1.0/24, 10.0.0.0/28;rate=5;promote=true;iface=eth1", defaults, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sweeps) != 2 || sweeps[0].CIDR.String() != "192.168.1.0/24" || sweeps[1].CIDR.String() != "10.0.0.0/28" {
+		t.Fatalf("Unexpected sweeps: %v", sweeps)
+	}
+	if sweeps[0].Rate != 20 || sweeps[0].Promote {
+		t.Errorf("Expected default options, got %#v", sweeps[0])
+	}
+	if sweeps[1].Rate != 5 || !sweeps[1].Promote || sweeps[1].Bind.Interface != "eth1" {
+		t.Errorf("Expected rate 5, promotion and eth1, got %#v", sweeps[1])
+	}
+}
+
+func TestParseSweepsInvalid(t *testing.T) {
+	for _, s := range []string{
+		"192.168.1.1",
+		"10.0.0.0/8",
+		"192.168.0.0/21",
+		"fd00::/120",
+		"192.168.1.0/24;rate=0",
+		"192.168.1.0/24;promote",
+		"192.168.1.0/24;colour=blue",
+	} {
+		if _, err := ParseSweeps(s, Sweep{}, 1024); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net"
+	"network_monitor/internal/network"
+	"network_monitor/internal/utils"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Target struct {
+	IP    string
+	Probe network.ProbeOpts
+	Bind  network.Bind
+	Trace TraceMode // Overrides the trace policy when set
+	Group string
+	// Added to the ping metrics, along with the labels of the group
+	Labels map[string]string
+}
+
+// ParseTargets reads a comma-separated list of IPs, each of which can be
+// followed by semicolon-separated probe options, such as
+// "8.8.8.8;size=1400;df=true;dscp=46, 1.1.1.1;pattern=a5;iface=wwan0"
+func ParseTargets(targetsString string) ([]Target, error) {
+	specs, err := utils.GetIps(targetsString)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]Target, 0, len(specs))
+	for _, spec := range specs {
+		t, err := parseTarget(spec)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+func parseTarget(spec string) (Target, error) {
+	parts := strings.Split(spec, ";")
+	t := Target{IP: strings.TrimSpace(parts[0])}
+
+	for _, opt := range parts[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(opt), "=")
+		if !ok {
+			return Target{}, fmt.Errorf("Expected key=value for option %q of %s", opt, t.IP)
+		}
+		if err := t.setOpt(key, value); err != nil {
+			return Target{}, fmt.Errorf("Invalid option %q of %s: %w", opt, t.IP, err)
+		}
+	}
+
+	return t, nil
+}
+
+// NewTarget makes a target from an IP and the options that can follow it in
+// a list of targets
+func NewTarget(ip string, options map[string]string) (Target, error) {
+	t := Target{IP: ip}
+	for _, key := range slices.Sorted(maps.Keys(options)) {
+		if err := t.setOpt(key, options[key]); err != nil {
+			return Target{}, fmt.Errorf("Invalid option %s=%s of %s: %w", key, options[key], ip, err)
+		}
+	}
+	return t, nil
+}
+
+func (t *Target) setOpt(key string, value string) error {
+	var err error
+	switch key {
+	case "size":
+		t.Probe.Size, err = parseRange(value, 8, 65507)
+	case "pattern":
+		t.Probe.Pattern, err = hex.DecodeString(value)
+		if err == nil && (len(t.Probe.Pattern) == 0 || len(t.Probe.Pattern) > 16) {
+			err = fmt.Errorf("Pattern must be 1 to 16 bytes")
+		}
+	case "df":
+		t.Probe.DF, err = strconv.ParseBool(value)
+	case "dscp":
+		var dscp int
+		dscp, err = parseRange(value, 0, 63)
+		t.Probe.TOS = dscp << 2
+	case "tos":
+		t.Probe.TOS, err = parseRange(value, 0, 255)
+	case "iface":
+		t.Bind.Interface = value
+	case "src":
+		t.Bind.Source = net.ParseIP(value).To4()
+		if t.Bind.Source == nil {
+			err = fmt.Errorf("Must be an IPv4 address")
+		}
+	case "trace":
+		t.Trace, err = parseTraceMode(value)
+	case "group":
+		t.Group = value
+		if value == "" {
+			err = fmt.Errorf("Group can't be empty")
+		}
+	default:
+		name, ok := strings.CutPrefix(key, "label.")
+		if !ok {
+			return fmt.Errorf("Unknown option")
+		}
+		if err := validTargetLabel(name); err != nil {
+			return err
+		}
+		if t.Labels == nil {
+			t.Labels = make(map[string]string)
+		}
+		t.Labels[name] = value
+	}
+	return err
+}
+
+func parseRange(value string, min int, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("Must be between %d and %d", min, max)
+	}
+	return n, nil
+}
+
+// expandInterfaces pings every target that isn't already bound to an
+// interface or source address over each of the interfaces instead
+func expandInterfaces(targets []Target, ifaces []string) []Target {
+	if len(ifaces) == 0 {
+		return targets
+	}
+
+	expanded := make([]Target, 0, len(targets)*len(ifaces))
+	for _, t := range targets {
+		if t.Bind.Interface != "" || t.Bind.Source != nil {
+			expanded = append(expanded, t)
+			continue
+		}
+		for _, iface := range ifaces {
+			bound := t
+			bound.Bind.Interface = iface
+			expanded = append(expanded, bound)
+		}
+	}
+	return expanded
+}
+
+// targetIps returns the IPs of the targets, once each however many
+// interfaces they're pinged over
+func targetIps(targets []Target) []string {
+	ips := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if !slices.Contains(ips, t.IP) {
+			ips = append(ips, t.IP)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"bytes"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestParseTargetsWithoutOptions(t *testing.T) {
+	targets, err := ParseTargets("8.8.8.8, 1.1.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(targets) != 2 || targets[0].IP != "8.8.8.8" || targets[1].IP != "1.1.1.1" {
+		t.Errorf("Unexpected targets: %#v", targets)
+	}
+	if targets[0].Probe.Size != 0 || targets[0].Probe.DF || targets[0].Probe.TOS != 0 {
+		t.Errorf("Expected default probe options, got %#v", targets[0].Probe)
+	}
+}
+
+func TestParseTargetsWithOptions(t *testing.T) {
+	targets, err := ParseTargets("8.8.8.8;size=1400;pattern=a5ff;df=true;dscp=46;trace=threshold")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	probe := targets[0].Probe
+	if probe.Size != 1400 {
+		t.Errorf("Expected size 1400, got %d", probe.Size)
+	}
+	if !bytes.Equal(probe.Pattern, []byte{0xa5, 0xff}) {
+		t.Errorf("Expected pattern a5ff, got %x", probe.Pattern)
+	}
+	if !probe.DF {
+		t.Error("Expected DF to be set")
+	}
+	// DSCP 46 (EF) is a TOS byte of 184
+	if probe.TOS != 184 {
+		t.Errorf("Expected TOS 184, got %d", probe.TOS)
+	}
+	if targets[0].Trace != TraceThreshold {
+		t.Errorf("Expected trace mode threshold, got %q", targets[0].Trace)
+	}
+}
+
+func TestParseTargetsInvalidOptions(t *testing.T) {
+	for _, s := range []string{
+		"8.8.8.8;size=4",
+		"8.8.8.8;dscp=64",
+		"8.8.8.8;pattern=zz",
+		"8.8.8.8;colour=blue",
+		"8.8.8.8;df",
+		"8.8.8.8;trace=sometimes",
+	} {
+		if _, err := ParseTargets(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestParseTargetsWithBind(t *testing.T) {
+	targets, err := ParseTargets("8.8.8.8;iface=wwan0, 1.1.1.1;src=10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if targets[0].Bind.Interface != "wwan0" {
+		t.Errorf("Expected interface wwan0, got %q", targets[0].Bind.Interface)
+	}
+	if !targets[1].Bind.Source.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("Expected source 10.0.0.2, got %s", targets[1].Bind.Source)
+	}
+
+	if _, err := ParseTargets("8.8.8.8;src=eth0"); err == nil {
+		t.Error("Expected an error for a source that isn't an IP")
+	}
+}
+
+func TestExpandInterfaces(t *testing.T) {
+	targets, err := ParseTargets("8.8.8.8, 1.1.1.1;iface=wg0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expanded := expandInterfaces(targets, []string{"eth0", "wwan0"})
+	var got []string
+	for _, t := range expanded {
+		got = append(got, t.IP+"%"+t.Bind.Interface)
+	}
+	want := []string{"8.8.8.8%eth0", "8.8.8.8%wwan0", "1.1.1.1%wg0"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if ips := targetIps(expanded); !slices.Equal(ips, []string{"8.8.8.8", "1.1.1.1"}) {
+		t.Errorf("Expected each IP once, got %v", ips)
+	}
+}
+
+func TestParseTargetsWithGroupAndLabels(t *testing.T) {
+	targets, err := ParseTargets("8.8.8.8;group=dns;label.provider=google;label.criticality=high")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if targets[0].Group != "dns" {
+		t.Errorf("Expected group dns, got %q", targets[0].Group)
+	}
+	if len(targets[0].Labels) != 2 || targets[0].Labels["provider"] != "google" || targets[0].Labels["criticality"] != "high" {
+		t.Errorf("Unexpected labels %v", targets[0].Labels)
+	}
+
+	for _, s := range []string{"8.8.8.8;group=", "8.8.8.8;label.not-valid=x", "8.8.8.8;label.ip=x", "8.8.8.8;label.__x=y"} {
+		if _, err := ParseTargets(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"network_monitor/internal/network"
+	"network_monitor/internal/utils"
+	"strings"
+)
+
+// TraceMode is when traces run for a target
+type TraceMode string
+
+const (
+	TracePeriodic  TraceMode = "periodic"  // Every -trace-frequency intervals
+	TraceThreshold TraceMode = "threshold" // After -trace-timeout-threshold timeouts in a row
+	TraceBoth      TraceMode = "both"
+	TraceNever     TraceMode = "never"
+)
+
+func parseTraceMode(value string) (TraceMode, error) {
+	switch mode := TraceMode(value); mode {
+	case TracePeriodic, TraceThreshold, TraceBoth, TraceNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("Must be one of periodic, threshold, both or never")
+}
+
+func (m TraceMode) Periodic() bool {
+	return m == TracePeriodic || m == TraceBoth
+}
+
+func (m TraceMode) Threshold() bool {
+	return m == TraceThreshold || m == TraceBoth
+}
+
+// Names that can be used in place of CIDRs in a trace policy
+var namedRanges = map[string][]*net.IPNet{
+	"rfc1918":    network.RFC1918,
+	"cgnat":      network.CGNAT,
+	"link-local": network.LinkLocal,
+	"ula":        network.ULA,
+	"loopback":   network.Loopback,
+}
+
+// defaultTracePolicy skips traces to addresses that can't be on a path across
+// the internet, there's nothing to find on the way to them
+const defaultTracePolicy = "rfc1918=never, cgnat=never, link-local=never, ula=never, loopback=never, default=both"
+
+type traceRule struct {
+	net  *net.IPNet
+	mode TraceMode
+}
+
+// TracePolicy picks the trace mode of an IP from the most specific CIDR it's
+// in, or the default mode when it isn't in any
+type TracePolicy struct {
+	rules       []traceRule
+	defaultMode TraceMode
+}
+
+// ParseTracePolicy reads a comma-separated list of cidr=mode rules, such as
+// "10.0.0.0/8=threshold, cgnat=never, default=both". A named range such as
+// rfc1918, cgnat, link-local, ula or loopback can be given instead of a CIDR
+func ParseTracePolicy(policyString string) (TracePolicy, error) {
+	rules, err := utils.GetIps(policyString)
+	if err != nil {
+		return TracePolicy{}, err
+	}
+
+	policy := TracePolicy{defaultMode: TraceBoth}
+	for _, rule := range rules {
+		cidr, value, ok := strings.Cut(rule, "=")
+		if !ok {
+			return TracePolicy{}, fmt.Errorf("Expected cidr=mode for trace policy rule %q", rule)
+		}
+		mode, err := parseTraceMode(strings.TrimSpace(value))
+		if err != nil {
+			return TracePolicy{}, fmt.Errorf("Invalid trace policy rule %q: %w", rule, err)
+		}
+
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "default" {
+			policy.defaultMode = mode
+			continue
+		}
+		nets, ok := namedRanges[cidr]
+		if !ok {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return TracePolicy{}, fmt.Errorf("Invalid trace policy rule %q: %w", rule, err)
+			}
+			nets = []*net.IPNet{n}
+		}
+		for _, n := range nets {
+			policy.rules = append(policy.rules, traceRule{net: n, mode: mode})
+		}
+	}
+
+	return policy, nil
+}
+
+// Mode returns the trace mode of ip
+func (p TracePolicy) Mode(ip net.IP) TraceMode {
+	mode, longest := p.defaultMode, -1
+	for _, rule := range p.rules {
+		ones, _ := rule.net.Mask.Size()
+		if ones > longest && rule.net.Contains(ip) {
+			mode, longest = rule.mode, ones
+		}
+	}
+	if mode == "" {
+		return TraceBoth
+	}
+	return mode
+}
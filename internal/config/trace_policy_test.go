@@ -0,0 +1,74 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDefaultTracePolicy(t *testing.T) {
+	policy, err := ParseTracePolicy(defaultTracePolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, expected := range map[string]TraceMode{
+		"8.8.8.8":     TraceBoth,
+		"192.168.1.1": TraceNever,
+		"10.1.2.3":    TraceNever,
+		"172.20.0.1":  TraceNever,
+		"100.64.0.1":  TraceNever,
+		"169.254.0.1": TraceNever,
+		"127.0.0.1":   TraceNever,
+		"fd00::1":     TraceNever,
+		"2001:db8::1": TraceBoth,
+	} {
+		if mode := policy.Mode(net.ParseIP(ip)); mode != expected {
+			t.Errorf("Mode(%s) = %s, expected %s", ip, mode, expected)
+		}
+	}
+}
+
+func TestTracePolicyMostSpecific(t *testing.T) {
+	policy, err := ParseTracePolicy("rfc1918=threshold, 10.1.0.0/16=never, 10.1.2.0/24=periodic, default=never")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, expected := range map[string]TraceMode{
+		"8.8.8.8":     TraceNever,
+		"10.2.0.1":    TraceThreshold,
+		"10.1.0.1":    TraceNever,
+		"10.1.2.3":    TracePeriodic,
+		"192.168.0.1": TraceThreshold,
+	} {
+		if mode := policy.Mode(net.ParseIP(ip)); mode != expected {
+			t.Errorf("Mode(%s) = %s, expected %s", ip, mode, expected)
+		}
+	}
+}
+
+func TestParseTracePolicyInvalid(t *testing.T) {
+	for _, policy := range []string{
+		"10.0.0.0/8",
+		"10.0.0.0/8=sometimes",
+		"10.0.0.0=never",
+		"private=never",
+	} {
+		if _, err := ParseTracePolicy(policy); err == nil {
+			t.Errorf("Expected an error parsing %q", policy)
+		}
+	}
+}
+
+func TestTraceModes(t *testing.T) {
+	for mode, expected := range map[TraceMode][2]bool{
+		TracePeriodic:  {true, false},
+		TraceThreshold: {false, true},
+		TraceBoth:      {true, true},
+		TraceNever:     {false, false},
+	} {
+		if mode.Periodic() != expected[0] || mode.Threshold() != expected[1] {
+			t.Errorf("%s: Periodic() = %t, Threshold() = %t, expected %v", mode, mode.Periodic(), mode.Threshold(), expected)
+		}
+	}
+}
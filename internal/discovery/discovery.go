@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Target is an IP or hostname found by a provider, with the labels it was
+// given
+type Target struct {
+	Host   string
+	Labels map[string]string
+}
+
+// Update is every target a source has, replacing what it had before. A
+// provider can have many sources, such as one per file
+type Update struct {
+	Source  string
+	Targets []Target
+}
+
+// Provider finds targets and sends them as updates until ctx is done
+type Provider interface {
+	Run(ctx context.Context, updates chan<- Update)
+}
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidLabelName reports whether name can be used as a Prometheus label
+func ValidLabelName(name string) bool {
+	return labelNameRe.MatchString(name)
+}
+
+// ValidateLabels checks labels have valid Prometheus label names and drops
+// the ones starting with __, which are reserved for internal use
+func ValidateLabels(labels map[string]string) (map[string]string, error) {
+	valid := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if !ValidLabelName(name) {
+			return nil, fmt.Errorf("Invalid label name %q", name)
+		}
+		if !strings.HasPrefix(name, "__") {
+			valid[name] = value
+		}
+	}
+	return valid, nil
+}
+
+// targetHost strips the port from targets written as host:port, as
+// Prometheus targets usually are
+func targetHost(target string) string {
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return strings.Trim(target, "[]")
+}
@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.yaml.in/yaml/v2"
+)
+
+// fileSDGroup is a target group in the Prometheus file_sd format
+type fileSDGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// fileSDSettle is how long a file has to go without changing before it's
+// reread
+const fileSDSettle = 100 * time.Millisecond
+
+// FileSD reads targets from JSON or YAML files in the Prometheus file_sd
+// format. Files are reloaded when inotify reports a change, and all of them
+// every refresh in case an event was missed or inotify isn't available
+type FileSD struct {
+	patterns []string // Paths, the file name can be a glob
+	refresh  time.Duration
+	watch    bool
+	files    []string // Files read so far, so removed ones can be emptied
+}
+
+func NewFileSD(patterns []string, refresh time.Duration) *FileSD {
+	// The names in events and from globs are clean, so paths like ./x.json
+	// wouldn't match them
+	cleaned := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		cleaned[i] = filepath.Clean(pattern)
+	}
+	return &FileSD{patterns: cleaned, refresh: refresh, watch: true}
+}
+
+func (f *FileSD) Run(ctx context.Context, updates chan<- Update) {
+	var events chan fsnotify.Event
+	if f.watch {
+		watcher, err := f.newWatcher()
+		if err != nil {
+			slog.Warn("Unable to watch file_sd files, falling back to polling", "error", err, "refresh", f.refresh)
+		} else {
+			defer watcher.Close()
+			events = watcher.Events
+			go func() {
+				for err := range watcher.Errors {
+					slog.Warn("Error watching file_sd files", "error", err)
+				}
+			}()
+		}
+	}
+
+	f.refreshAll(updates)
+	ticker := time.NewTicker(f.refresh)
+	defer ticker.Stop()
+
+	// Files are reread once events for them stop, writing a file in place
+	// truncates it first and would otherwise be read empty
+	changed := make([]string, 0)
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.refreshAll(updates)
+		case event := <-events:
+			if file := filepath.Clean(event.Name); f.matches(file) {
+				slog.Debug("file_sd file changed", "file", file, "op", event.Op)
+				if !slices.Contains(changed, file) {
+					changed = append(changed, file)
+				}
+				settled = time.After(fileSDSettle)
+			}
+		case <-settled:
+			for _, file := range changed {
+				f.refreshFile(file, updates)
+			}
+			changed = changed[:0]
+		}
+	}
+}
+
+// newWatcher watches the directories the files are in rather than the
+// files, so files that are replaced by renaming a new one over them, or
+// created later, are seen
+func (f *FileSD) newWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0, len(f.patterns))
+	for _, pattern := range f.patterns {
+		dir := filepath.Dir(pattern)
+		if slices.Contains(dirs, dir) {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return watcher, nil
+}
+
+func (f *FileSD) matches(file string) bool {
+	file = filepath.Clean(file)
+	for _, pattern := range f.patterns {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FileSD) refreshAll(updates chan<- Update) {
+	files := make([]string, 0)
+	for _, pattern := range f.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			slog.Error("Invalid file_sd path", "error", err, "path", pattern)
+			continue
+		}
+		files = append(files, matches...)
+	}
+
+	for _, file := range f.files {
+		if !slices.Contains(files, file) {
+			f.refreshFile(file, updates)
+		}
+	}
+	for _, file := range files {
+		f.refreshFile(file, updates)
+	}
+}
+
+// refreshFile sends the targets in file, or none if it's been removed. The
+// targets are left as they were if the file can't be parsed
+func (f *FileSD) refreshFile(file string, updates chan<- Update) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		if i := slices.Index(f.files, file); i != -1 {
+			f.files = slices.Delete(f.files, i, i+1)
+			updates <- Update{Source: file}
+		}
+		return
+	}
+	if err != nil {
+		slog.Error("Error reading file_sd file", "error", err, "file", file)
+		return
+	}
+
+	targets, err := parseFileSD(data, filepath.Ext(file))
+	if err != nil {
+		slog.Error("Error parsing file_sd file", "error", err, "file", file)
+		return
+	}
+	if !slices.Contains(f.files, file) {
+		f.files = append(f.files, file)
+	}
+	updates <- Update{Source: file, Targets: targets}
+}
+
+func parseFileSD(data []byte, ext string) ([]Target, error) {
+	var groups []fileSDGroup
+	var err error
+	switch ext {
+	case ".json":
+		err = json.Unmarshal(data, &groups)
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(data, &groups)
+	default:
+		return nil, fmt.Errorf("Unknown file_sd extension %q, expected .json, .yml or .yaml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]Target, 0)
+	for _, group := range groups {
+		labels, err := ValidateLabels(group.Labels)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range group.Targets {
+			targets = append(targets, Target{Host: targetHost(target), Labels: labels})
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,145 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseFileSDJSON(t *testing.T) {
+	data := `[
+		{"targets": ["10.0.0.1", "10.0.0.2:9100"], "labels": {"site": "office", "__meta_x": "dropped"}},
+		{"targets": ["[fd00::1]:9100", "router.example.com"]}
+	]`
+	targets, err := parseFileSD([]byte(data), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := []string{"10.0.0.1", "10.0.0.2", "fd00::1", "router.example.com"}
+	if len(targets) != len(hosts) {
+		t.Fatalf("Expected %d targets, got %v", len(hosts), targets)
+	}
+	for i, host := range hosts {
+		if targets[i].Host != host {
+			t.Errorf("Expected target %d to be %s, got %s", i, host, targets[i].Host)
+		}
+	}
+	if len(targets[0].Labels) != 1 || targets[0].Labels["site"] != "office" {
+		t.Errorf("Expected only the site label, got %v", targets[0].Labels)
+	}
+	if len(targets[2].Labels) != 0 {
+		t.Errorf("Expected no labels, got %v", targets[2].Labels)
+	}
+}
+
+func TestParseFileSDYAML(t *testing.T) {
+	data := `
+- targets:
+    - 10.0.0.1
+  labels:
+    site: office
+`
+	targets, err := parseFileSD([]byte(data), ".yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0].Host != "10.0.0.1" || targets[0].Labels["site"] != "office" {
+		t.Errorf("Unexpected targets: %v", targets)
+	}
+}
+
+func TestParseFileSDInvalid(t *testing.T) {
+	for data, ext := range map[string]string{
+		`[{"targets": ["10.0.0.1"], "labels": {"not-valid": "x"}}]`: ".json",
+		`[{"targets": "10.0.0.1"}]`:                                 ".json",
+		"- targets: [10.0.0.1]\n  colour: blue":                     ".yaml",
+		`[]`:                                                        ".txt",
+	} {
+		if _, err := parseFileSD([]byte(data), ext); err == nil {
+			t.Errorf("Expected an error parsing %s", data)
+		}
+	}
+}
+
+func receive(t *testing.T, updates chan Update) Update {
+	t.Helper()
+	select {
+	case update := <-updates:
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an update")
+	}
+	return Update{}
+}
+
+func testFileSD(t *testing.T, watch bool) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "targets.json")
+	if err := os.WriteFile(file, []byte(`[{"targets": ["10.0.0.1"]}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := time.Hour
+	if !watch {
+		refresh = 50 * time.Millisecond
+	}
+	f := NewFileSD([]string{filepath.Join(dir, "*.json")}, refresh)
+	f.watch = watch
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan Update)
+	go f.Run(ctx, updates)
+
+	update := receive(t, updates)
+	if update.Source != file || len(update.Targets) != 1 || update.Targets[0].Host != "10.0.0.1" {
+		t.Fatalf("Unexpected first update: %v", update)
+	}
+
+	if err := os.WriteFile(file, []byte(`[{"targets": ["10.0.0.1", "10.0.0.2"]}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for update = receive(t, updates); len(update.Targets) != 2; update = receive(t, updates) {
+	}
+
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	for update = receive(t, updates); len(update.Targets) != 0; update = receive(t, updates) {
+	}
+	if update.Source != file {
+		t.Errorf("Expected the removed file to be emptied, got %v", update)
+	}
+}
+
+func TestFileSDWatch(t *testing.T) {
+	testFileSD(t, true)
+}
+
+func TestFileSDPoll(t *testing.T) {
+	testFileSD(t, false)
+}
+
+func TestFileSDMatchesUncleanPaths(t *testing.T) {
+	tests := []struct {
+		pattern string
+		file    string
+	}{
+		{"./targets/*.json", "targets/a.json"},
+		{"targets//*.json", "targets/a.json"},
+		{"/etc/nm/../nm/*.yml", "/etc/nm/a.yml"},
+		{"targets/*.json", "./targets/a.json"},
+	}
+	for _, test := range tests {
+		f := NewFileSD([]string{test.pattern}, time.Hour)
+		if !f.matches(test.file) {
+			t.Errorf("Expected %s to match %s", test.file, test.pattern)
+		}
+	}
+	if f := NewFileSD([]string{"./targets/*.json"}, time.Hour); f.matches("other/a.json") {
+		t.Error("Expected a file in another directory not to match")
+	}
+}
@@ -0,0 +1,100 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// KubernetesMesh is which peers the monitor pings, with one monitor on each
+// node every peer pings every other
+type KubernetesMesh string
+
+const (
+	MeshNodes KubernetesMesh = "nodes" // The internal IP of each node
+	MeshPods  KubernetesMesh = "pods"  // The IP of each pod of the monitor
+)
+
+func ParseKubernetesMesh(value string) (KubernetesMesh, error) {
+	switch mesh := KubernetesMesh(value); mesh {
+	case MeshNodes, MeshPods:
+		return mesh, nil
+	}
+	return "", fmt.Errorf("Unknown Kubernetes mesh %q, expected nodes or pods", value)
+}
+
+type KubernetesOpts struct {
+	Mesh      KubernetesMesh
+	Selector  string // Label selector for the nodes or pods
+	Namespace string // Namespace of the pods
+	NodeName  string // The node the monitor runs on
+	PodName   string // The pod the monitor runs in
+	Interval  time.Duration
+}
+
+// Kubernetes lists the peers of the monitor every interval. Targets are
+// labelled with src_node, the node the monitor runs on, and dst_node, the
+// node of the peer
+type Kubernetes struct {
+	client KubernetesClient
+	opts   KubernetesOpts
+}
+
+func NewKubernetes(client KubernetesClient, opts KubernetesOpts) *Kubernetes {
+	return &Kubernetes{client: client, opts: opts}
+}
+
+func (k *Kubernetes) Run(ctx context.Context, updates chan<- Update) {
+	ticker := time.NewTicker(k.opts.Interval)
+	defer ticker.Stop()
+	for {
+		targets, err := k.peers(ctx)
+		if err != nil {
+			slog.Error("Error listing Kubernetes peers", "error", err, "mesh", k.opts.Mesh)
+		} else {
+			updates <- Update{Source: string(k.opts.Mesh), Targets: targets}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// peers returns the ready peers other than the monitor itself
+func (k *Kubernetes) peers(ctx context.Context) ([]Target, error) {
+	targets := make([]Target, 0)
+	add := func(ip string, node string) {
+		targets = append(targets, Target{
+			Host:   ip,
+			Labels: map[string]string{"src_node": k.opts.NodeName, "dst_node": node},
+		})
+	}
+
+	switch k.opts.Mesh {
+	case MeshNodes:
+		nodes, err := k.client.Nodes(ctx, k.opts.Selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			if node.Name != k.opts.NodeName && node.Ready && node.InternalIP != "" {
+				add(node.InternalIP, node.Name)
+			}
+		}
+	case MeshPods:
+		pods, err := k.client.Pods(ctx, k.opts.Namespace, k.opts.Selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			if pod.Name != k.opts.PodName && pod.Ready && pod.IP != "" {
+				add(pod.IP, pod.NodeName)
+			}
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,192 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+type KubernetesNode struct {
+	Name       string
+	InternalIP string
+	Ready      bool
+}
+
+type KubernetesPod struct {
+	Name     string
+	NodeName string
+	IP       string
+	Ready    bool
+}
+
+// KubernetesClient lists the nodes and pods that match a label selector
+type KubernetesClient interface {
+	Nodes(ctx context.Context, selector string) ([]KubernetesNode, error)
+	Pods(ctx context.Context, namespace string, selector string) ([]KubernetesPod, error)
+}
+
+// restClient talks to the API server with plain HTTP requests, listing is all
+// discovery needs so client-go would be a lot of weight for little use
+type restClient struct {
+	host      string
+	http      *http.Client
+	tokenFile string
+}
+
+// NewInClusterClient returns a client that uses the service account of the
+// pod it runs in
+func NewInClusterClient() (KubernetesClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("Not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT aren't set")
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("No certificates in the service account CA")
+	}
+
+	httpClient := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return &restClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		http:      httpClient,
+		tokenFile: serviceAccountDir + "/token",
+	}, nil
+}
+
+// InClusterNamespace returns the namespace of the pod the monitor runs in
+func InClusterNamespace() string {
+	namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(namespace))
+}
+
+type objectMeta struct {
+	Name string `json:"name"`
+}
+
+type condition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+func ready(conditions []condition) bool {
+	for _, c := range conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+type nodeList struct {
+	Items []struct {
+		Metadata objectMeta `json:"metadata"`
+		Status   struct {
+			Addresses []struct {
+				Type    string `json:"type"`
+				Address string `json:"address"`
+			} `json:"addresses"`
+			Conditions []condition `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type podList struct {
+	Items []struct {
+		Metadata objectMeta `json:"metadata"`
+		Spec     struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+		Status struct {
+			PodIP      string      `json:"podIP"`
+			Conditions []condition `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+func (c *restClient) Nodes(ctx context.Context, selector string) ([]KubernetesNode, error) {
+	var list nodeList
+	if err := c.list(ctx, "/api/v1/nodes", selector, &list); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]KubernetesNode, 0, len(list.Items))
+	for _, item := range list.Items {
+		node := KubernetesNode{Name: item.Metadata.Name, Ready: ready(item.Status.Conditions)}
+		for _, addr := range item.Status.Addresses {
+			if addr.Type == "InternalIP" && node.InternalIP == "" {
+				node.InternalIP = addr.Address
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (c *restClient) Pods(ctx context.Context, namespace string, selector string) ([]KubernetesPod, error) {
+	var list podList
+	if err := c.list(ctx, "/api/v1/namespaces/"+url.PathEscape(namespace)+"/pods", selector, &list); err != nil {
+		return nil, err
+	}
+
+	pods := make([]KubernetesPod, 0, len(list.Items))
+	for _, item := range list.Items {
+		pods = append(pods, KubernetesPod{
+			Name:     item.Metadata.Name,
+			NodeName: item.Spec.NodeName,
+			IP:       item.Status.PodIP,
+			Ready:    ready(item.Status.Conditions),
+		})
+	}
+	return pods, nil
+}
+
+func (c *restClient) list(ctx context.Context, path string, selector string, into any) error {
+	u := c.host + path
+	if selector != "" {
+		u += "?" + url.Values{"labelSelector": {selector}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	// Service account tokens are rotated, so it's reread for every request
+	token, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Listing %s failed with status %s", path, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(into)
+}
@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+type fakeKubernetesClient struct {
+	nodes    []KubernetesNode
+	pods     []KubernetesPod
+	selector string
+}
+
+func (c *fakeKubernetesClient) Nodes(ctx context.Context, selector string) ([]KubernetesNode, error) {
+	c.selector = selector
+	return c.nodes, nil
+}
+
+func (c *fakeKubernetesClient) Pods(ctx context.Context, namespace string, selector string) ([]KubernetesPod, error) {
+	c.selector = namespace + "/" + selector
+	return c.pods, nil
+}
+
+func hosts(targets []Target) []string {
+	h := make([]string, 0, len(targets))
+	for _, t := range targets {
+		h = append(h, t.Host)
+	}
+	return h
+}
+
+func TestKubernetesNodeMesh(t *testing.T) {
+	client := &fakeKubernetesClient{nodes: []KubernetesNode{
+		{Name: "a", InternalIP: "10.0.0.1", Ready: true},
+		{Name: "b", InternalIP: "10.0.0.2", Ready: true},
+		{Name: "c", InternalIP: "10.0.0.3", Ready: false},
+		{Name: "d", InternalIP: "10.0.0.4", Ready: true},
+	}}
+	k := NewKubernetes(client, KubernetesOpts{Mesh: MeshNodes, Selector: "role=worker", NodeName: "a", Interval: time.Hour})
+
+	targets, err := k.peers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.selector != "role=worker" {
+		t.Errorf("Expected selector role=worker, got %q", client.selector)
+	}
+	if got := hosts(targets); !slices.Equal(got, []string{"10.0.0.2", "10.0.0.4"}) {
+		t.Errorf("Expected the other ready nodes, got %v", got)
+	}
+	if targets[0].Labels["src_node"] != "a" || targets[0].Labels["dst_node"] != "b" {
+		t.Errorf("Unexpected labels %v", targets[0].Labels)
+	}
+}
+
+func TestKubernetesPodMesh(t *testing.T) {
+	client := &fakeKubernetesClient{pods: []KubernetesPod{
+		{Name: "nm-1", NodeName: "a", IP: "10.244.0.5", Ready: true},
+		{Name: "nm-2", NodeName: "b", IP: "10.244.1.7", Ready: true},
+		{Name: "nm-3", NodeName: "c", IP: "", Ready: false},
+	}}
+	k := NewKubernetes(client, KubernetesOpts{Mesh: MeshPods, Selector: "app=network-monitor", Namespace: "monitoring",
+		NodeName: "a", PodName: "nm-1", Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan Update)
+	go k.Run(ctx, updates)
+
+	update := receive(t, updates)
+	if client.selector != "monitoring/app=network-monitor" {
+		t.Errorf("Expected pods in monitoring with app=network-monitor, got %q", client.selector)
+	}
+	if update.Source != "pods" || !slices.Equal(hosts(update.Targets), []string{"10.244.1.7"}) {
+		t.Fatalf("Unexpected update %v", update)
+	}
+	if update.Targets[0].Labels["src_node"] != "a" || update.Targets[0].Labels["dst_node"] != "b" {
+		t.Errorf("Unexpected labels %v", update.Targets[0].Labels)
+	}
+}
+
+func TestRESTClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/nodes":
+			if r.URL.Query().Get("labelSelector") != "role=worker" {
+				t.Errorf("Unexpected selector %q", r.URL.Query().Get("labelSelector"))
+			}
+			w.Write([]byte(`{"items": [{"metadata": {"name": "a"}, "status": {
+				"addresses": [{"type": "Hostname", "address": "a"}, {"type": "InternalIP", "address": "10.0.0.1"}],
+				"conditions": [{"type": "MemoryPressure", "status": "False"}, {"type": "Ready", "status": "True"}]}}]}`))
+		case "/api/v1/namespaces/monitoring/pods":
+			w.Write([]byte(`{"items": [{"metadata": {"name": "nm-1"}, "spec": {"nodeName": "a"},
+				"status": {"podIP": "10.244.0.5", "conditions": [{"type": "Ready", "status": "False"}]}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client := &restClient{host: server.URL, http: server.Client(), tokenFile: tokenFile}
+
+	nodes, err := client.Nodes(context.Background(), "role=worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0] != (KubernetesNode{Name: "a", InternalIP: "10.0.0.1", Ready: true}) {
+		t.Errorf("Unexpected nodes %v", nodes)
+	}
+
+	pods, err := client.Pods(context.Background(), "monitoring", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0] != (KubernetesPod{Name: "nm-1", NodeName: "a", IP: "10.244.0.5", Ready: false}) {
+		t.Errorf("Unexpected pods %v", pods)
+	}
+
+	if err := os.WriteFile(tokenFile, []byte("wrong"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Nodes(context.Background(), ""); err == nil {
+		t.Error("Expected an error for an unauthorised request")
+	}
+}
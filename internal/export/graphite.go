@@ -0,0 +1,147 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Dots separate the nodes of a path, so they're replaced inside one
+	graphiteNodeEscaper = strings.NewReplacer(".", "_", " ", "_", ";", "_", "=", "_", "%", "_")
+	graphiteTagEscaper  = strings.NewReplacer(" ", "_", ";", "_", "~", "_", "!", "_", "^", "_")
+)
+
+// Graphite writes results to Carbon with the plaintext protocol over TCP.
+// Paths are prefix.ping.<ip>.<interface>.<field>, or prefix.ping.<field>
+// with the target as tags when tagged
+type Graphite struct {
+	addr   string
+	prefix string
+	tagged bool
+	conn   net.Conn
+	dialer net.Dialer
+
+	// The last write that failed and how much of it Carbon got, so retrying
+	// it doesn't send those lines again
+	failed []byte
+	sent   int
+}
+
+func NewGraphite(addr string, prefix string, tagged bool) *Graphite {
+	return &Graphite{addr: addr, prefix: strings.TrimSuffix(prefix, "."), tagged: tagged}
+}
+
+// path returns the series name of field for r
+func (g *Graphite) path(r Result, field string) string {
+	var b strings.Builder
+	if g.prefix != "" {
+		b.WriteString(g.prefix)
+		b.WriteByte('.')
+	}
+	b.WriteString("ping.")
+
+	if !g.tagged {
+		iface := r.Interface
+		if iface == "" {
+			iface = "default"
+		}
+		b.WriteString(graphiteNodeEscaper.Replace(r.IP))
+		b.WriteByte('.')
+		b.WriteString(graphiteNodeEscaper.Replace(iface))
+		b.WriteByte('.')
+		b.WriteString(field)
+		return b.String()
+	}
+
+	b.WriteString(field)
+	tags := map[string]string{
+		"ip":        r.IP,
+		"interface": r.Interface,
+		"role":      r.Role,
+		"group":     r.Group,
+	}
+	for k, v := range r.Labels {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		// Tag values can't be empty
+		if tags[k] == "" {
+			continue
+		}
+		b.WriteByte(';')
+		b.WriteString(graphiteTagEscaper.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(graphiteTagEscaper.Replace(tags[k]))
+	}
+	return b.String()
+}
+
+func (g *Graphite) appendLine(b []byte, r Result, field string, value float64) []byte {
+	b = append(b, g.path(r, field)...)
+	b = append(b, ' ')
+	b = strconv.AppendFloat(b, value, 'f', -1, 64)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, r.Time.Unix(), 10)
+	return append(b, '\n')
+}
+
+// Write sends rtt_ms for replies, timeout as 1 or 0, and ttl when known.
+// Results for a series within the same second overwrite each other. Retrying
+// a write that failed part way only sends the lines Carbon didn't get
+func (g *Graphite) Write(ctx context.Context, results []Result) error {
+	var b []byte
+	for _, r := range results {
+		if r.Class == ResultTimeout {
+			b = g.appendLine(b, r, "timeout", 1)
+			continue
+		}
+		b = g.appendLine(b, r, "timeout", 0)
+		b = g.appendLine(b, r, "rtt_ms", float64(r.RTT.Nanoseconds())/1e6)
+		if r.TTL > 0 {
+			b = g.appendLine(b, r, "ttl", float64(r.TTL))
+		}
+	}
+
+	start := 0
+	if bytes.Equal(b, g.failed) {
+		start = g.sent
+	}
+	g.failed, g.sent = b, start
+
+	if g.conn == nil {
+		conn, err := g.dialer.DialContext(ctx, "tcp", g.addr)
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		g.conn.SetWriteDeadline(deadline)
+	}
+	n, err := g.conn.Write(b[start:])
+	if err != nil {
+		// Carbon drops a line cut off by the connection closing, so it's sent
+		// again with the rest
+		g.sent += bytes.LastIndexByte(b[start:start+n], '\n') + 1
+		// Reconnect on the next write, Carbon may have restarted
+		g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	g.failed, g.sent = nil, 0
+	return nil
+}
+
+func (g *Graphite) Close() error {
+	if g.conn == nil {
+		return nil
+	}
+	return g.conn.Close()
+}
@@ -0,0 +1,176 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGraphitePaths(t *testing.T) {
+	r := Result{IP: "1.1.1.1", Group: "dns", Labels: map[string]string{"site": "lon 1"}}
+
+	plain := NewGraphite("", "network_monitor.", false)
+	if got := plain.path(r, "rtt_ms"); got != "network_monitor.ping.1_1_1_1.default.rtt_ms" {
+		t.Errorf("Unexpected path %s", got)
+	}
+	r.Interface = "eth0.100"
+	if got := plain.path(r, "rtt_ms"); got != "network_monitor.ping.1_1_1_1.eth0_100.rtt_ms" {
+		t.Errorf("Unexpected path %s", got)
+	}
+
+	tagged := NewGraphite("", "", true)
+	if got := tagged.path(r, "rtt_ms"); got != "ping.rtt_ms;group=dns;interface=eth0.100;ip=1.1.1.1;site=lon_1" {
+		t.Errorf("Unexpected tagged path %s", got)
+	}
+}
+
+// listenGraphite accepts connections and passes on every line received
+func listenGraphite(t *testing.T) (net.Listener, chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return ln, lines
+}
+
+func TestGraphiteWrite(t *testing.T) {
+	ln, lines := listenGraphite(t)
+	defer ln.Close()
+
+	g := NewGraphite(ln.Addr().String(), "nm", false)
+	defer g.Close()
+	results := []Result{
+		{Time: testTime, IP: "1.1.1.1", Class: "on_time", RTT: 1500 * time.Microsecond, TTL: 57},
+		{Time: testTime, IP: "8.8.8.8", Class: ResultTimeout},
+	}
+	if err := g.Write(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for range 4 {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Only received %q", got)
+		}
+	}
+	want := []string{
+		"nm.ping.1_1_1_1.default.timeout 0 1700000000",
+		"nm.ping.1_1_1_1.default.rtt_ms 1.5 1700000000",
+		"nm.ping.1_1_1_1.default.ttl 57 1700000000",
+		"nm.ping.8_8_8_8.default.timeout 1 1700000000",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected\n%q\ngot\n%q", want, got)
+	}
+}
+
+func TestGraphiteReconnects(t *testing.T) {
+	ln, _ := listenGraphite(t)
+	addr := ln.Addr().String()
+	g := NewGraphite(addr, "", false)
+	defer g.Close()
+	results := []Result{{Time: testTime, IP: "1.1.1.1", Class: ResultTimeout}}
+	if err := g.Write(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+
+	// Carbon restarting breaks the connection
+	ln.Close()
+	g.conn.Close()
+	if err := g.Write(context.Background(), results); err == nil {
+		t.Fatal("Expected an error writing to a closed connection")
+	}
+
+	ln, lines := listenGraphite(t)
+	defer ln.Close()
+	g.addr = ln.Addr().String()
+	if err := g.Write(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lines:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing received after reconnecting")
+	}
+}
+
+// cutConn accepts limit bytes then fails, like a connection Carbon closed
+type cutConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *cutConn) Write(b []byte) (int, error) {
+	return min(len(b), c.limit), errors.New("connection reset")
+}
+
+func (c *cutConn) Close() error {
+	return nil
+}
+
+func TestGraphiteRetryResumes(t *testing.T) {
+	ln, lines := listenGraphite(t)
+	defer ln.Close()
+
+	g := NewGraphite(ln.Addr().String(), "nm", false)
+	defer g.Close()
+	results := []Result{
+		{Time: testTime, IP: "1.1.1.1", Class: ResultTimeout},
+		{Time: testTime, IP: "8.8.8.8", Class: ResultTimeout},
+		{Time: testTime, IP: "9.9.9.9", Class: ResultTimeout},
+	}
+	// The first line and part of the second get through
+	g.conn = &cutConn{limit: len("nm.ping.1_1_1_1.default.timeout 1 1700000000\n") + 5}
+	if err := g.Write(context.Background(), results); err == nil {
+		t.Fatal("Expected an error from the cut connection")
+	}
+	if err := g.Write(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for range 2 {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Only received %q", got)
+		}
+	}
+	want := []string{
+		"nm.ping.8_8_8_8.default.timeout 1 1700000000",
+		"nm.ping.9_9_9_9.default.timeout 1 1700000000",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected\n%q\ngot\n%q", want, got)
+	}
+	select {
+	case line := <-lines:
+		t.Errorf("Unexpected line %q", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
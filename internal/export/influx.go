@@ -0,0 +1,167 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	influxMeasurement = "ping"
+	// Datagrams are kept under a typical MTU so they aren't fragmented
+	influxMaxDatagram = 1400
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	influxTagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+// appendInfluxLine appends r in InfluxDB line protocol, tags with empty
+// values are left out as the protocol doesn't allow them
+func appendInfluxLine(b []byte, r Result) []byte {
+	tags := map[string]string{
+		"ip":        r.IP,
+		"interface": r.Interface,
+		"role":      r.Role,
+		"group":     r.Group,
+		"class":     r.Class,
+	}
+	for k, v := range r.Labels {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+
+	b = append(b, influxMeasurementEscaper.Replace(influxMeasurement)...)
+	// Sorted tags are faster for InfluxDB to index
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		if tags[k] == "" {
+			continue
+		}
+		b = append(b, ',')
+		b = append(b, influxTagEscaper.Replace(k)...)
+		b = append(b, '=')
+		b = append(b, influxTagEscaper.Replace(tags[k])...)
+	}
+
+	timeout := r.Class == ResultTimeout
+	b = append(b, " timeout="...)
+	b = strconv.AppendBool(b, timeout)
+	if !timeout {
+		b = append(b, ",seq="...)
+		b = strconv.AppendInt(b, int64(r.Seq), 10)
+		b = append(b, 'i')
+		b = append(b, ",rtt_ms="...)
+		b = strconv.AppendFloat(b, float64(r.RTT.Nanoseconds())/1e6, 'f', -1, 64)
+		b = append(b, ",corrupted="...)
+		b = strconv.AppendBool(b, r.Corrupted)
+	}
+	if r.TTL > 0 {
+		b = append(b, ",ttl="...)
+		b = strconv.AppendInt(b, int64(r.TTL), 10)
+		b = append(b, 'i')
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, r.Time.UnixNano(), 10)
+	return append(b, '\n')
+}
+
+// InfluxHTTP writes results to the write endpoint of InfluxDB, either
+// /write?db= of 1.x or /api/v2/write?org=&bucket= of 2.x and later
+type InfluxHTTP struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// InfluxUDP writes results to the UDP listener of InfluxDB or Telegraf
+type InfluxUDP struct {
+	conn net.Conn
+}
+
+// NewInflux returns the sink for rawURL, which is sent to over UDP when its
+// scheme is udp
+func NewInflux(rawURL string, token string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &InfluxHTTP{url: rawURL, token: token, client: &http.Client{}}, nil
+	case "udp":
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return &InfluxUDP{conn: conn}, nil
+	default:
+		return nil, fmt.Errorf("Unknown InfluxDB URL scheme %q, expected http, https or udp", u.Scheme)
+	}
+}
+
+func (i *InfluxHTTP) Write(ctx context.Context, results []Result) error {
+	var body []byte
+	for _, r := range results {
+		body = appendInfluxLine(body, r)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB write failed with status %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+func (i *InfluxHTTP) Close() error {
+	return nil
+}
+
+// Write sends as many lines in each datagram as fit
+func (i *InfluxUDP) Write(ctx context.Context, results []Result) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		i.conn.SetWriteDeadline(deadline)
+	}
+	var datagram, line []byte
+	for _, r := range results {
+		line = appendInfluxLine(line[:0], r)
+		if len(datagram) > 0 && len(datagram)+len(line) > influxMaxDatagram {
+			if _, err := i.conn.Write(datagram); err != nil {
+				return err
+			}
+			datagram = datagram[:0]
+		}
+		datagram = append(datagram, line...)
+	}
+	if len(datagram) > 0 {
+		_, err := i.conn.Write(datagram)
+		return err
+	}
+	return nil
+}
+
+func (i *InfluxUDP) Close() error {
+	return i.conn.Close()
+}
@@ -0,0 +1,122 @@
+package export
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTime = time.Unix(1_700_000_000, 123)
+
+func TestAppendInfluxLine(t *testing.T) {
+	reply := Result{
+		Time:      testTime,
+		IP:        "1.1.1.1",
+		Interface: "eth0",
+		Group:     "dns",
+		Labels:    map[string]string{"site": "new york", "empty": "", "ip": "ignored"},
+		Class:     "on_time",
+		Seq:       12,
+		RTT:       12345 * time.Microsecond,
+		TTL:       57,
+	}
+	want := "ping,class=on_time,group=dns,interface=eth0,ip=1.1.1.1,site=new\\ york timeout=false,seq=12i,rtt_ms=12.345,corrupted=false,ttl=57i 1700000000000000123\n"
+	if got := string(appendInfluxLine(nil, reply)); got != want {
+		t.Errorf("Expected\n%sgot\n%s", want, got)
+	}
+
+	timeout := Result{Time: testTime, IP: "8.8.8.8", Role: "gateway", Class: ResultTimeout}
+	want = "ping,class=timeout,ip=8.8.8.8,role=gateway timeout=true 1700000000000000123\n"
+	if got := string(appendInfluxLine(nil, timeout)); got != want {
+		t.Errorf("Expected\n%sgot\n%s", want, got)
+	}
+}
+
+func TestInfluxHTTP(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "pings" || r.Header.Get("Authorization") != "Token t0ken" {
+			http.Error(w, "unexpected request", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewInflux(server.URL+"/api/v2/write?org=ops&bucket=pings", "t0ken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []Result{{Time: testTime, IP: "1.1.1.1", Class: "on_time"}, {Time: testTime, IP: "8.8.8.8", Class: ResultTimeout}}
+	if err := sink.Write(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(<-bodies, "\n"); lines != 2 {
+		t.Errorf("Expected 2 lines, got %d", lines)
+	}
+
+	bad, err := NewInflux(server.URL+"/api/v2/write?org=ops&bucket=pings", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bad.Write(context.Background(), results); err == nil {
+		t.Error("Expected an error for a rejected write")
+	}
+}
+
+func TestInfluxUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewInflux("udp://"+conn.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	// Around 100 bytes a line, so they need several datagrams
+	results := make([]Result, 40)
+	for i := range results {
+		results[i] = Result{Time: testTime, IP: "192.168.100.100", Interface: "wwan0", Class: "on_time", Seq: i, RTT: time.Millisecond}
+	}
+	if err := sink.Write(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, datagrams := 0, 0
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for lines < len(results) {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > influxMaxDatagram {
+			t.Errorf("Datagram of %d bytes is over the limit", n)
+		}
+		if buf[n-1] != '\n' {
+			t.Error("Datagram split a line")
+		}
+		datagrams++
+		lines += strings.Count(string(buf[:n]), "\n")
+	}
+	if datagrams < 2 {
+		t.Errorf("Expected the lines to be split over several datagrams, got %d", datagrams)
+	}
+}
+
+func TestNewInfluxUnknownScheme(t *testing.T) {
+	if _, err := NewInflux("tcp://localhost:8086", ""); err == nil {
+		t.Error("Expected an error for a tcp URL")
+	}
+}
@@ -0,0 +1,103 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promBridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+)
+
+const serviceName = "network-monitor"
+
+type OTLPOpts struct {
+	Endpoint   string // URL of the collector, http:// endpoints don't use TLS
+	Protocol   string // grpc or http
+	Interval   time.Duration
+	Timeout    time.Duration
+	Headers    map[string]string // Sent with every export, such as API keys
+	Build      string
+	Attributes map[string]string // Added to the resource, such as the site
+}
+
+// OTLP pushes everything in a Prometheus registry to an OpenTelemetry
+// collector, counters become sums and histograms keep their buckets
+type OTLP struct {
+	provider *sdkmetric.MeterProvider
+}
+
+// NewOTLP starts exporting the metrics gathered from g every interval. Export
+// errors go to the handler set with otel.SetErrorHandler
+func NewOTLP(ctx context.Context, g prometheus.Gatherer, opts OTLPOpts) (*OTLP, error) {
+	exporter, err := newOTLPExporter(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := newResource(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(opts.Interval),
+		sdkmetric.WithTimeout(opts.Timeout),
+		sdkmetric.WithProducer(promBridge.NewMetricProducer(promBridge.WithGatherer(g))),
+	)
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(res),
+	)
+	return &OTLP{provider: provider}, nil
+}
+
+func newOTLPExporter(ctx context.Context, opts OTLPOpts) (sdkmetric.Exporter, error) {
+	switch opts.Protocol {
+	case "grpc":
+		return otlpmetricgrpc.New(ctx,
+			otlpmetricgrpc.WithEndpointURL(opts.Endpoint),
+			otlpmetricgrpc.WithHeaders(opts.Headers),
+			otlpmetricgrpc.WithTimeout(opts.Timeout),
+		)
+	case "http":
+		return otlpmetrichttp.New(ctx,
+			otlpmetrichttp.WithEndpointURL(opts.Endpoint),
+			otlpmetrichttp.WithHeaders(opts.Headers),
+			otlpmetrichttp.WithTimeout(opts.Timeout),
+		)
+	default:
+		return nil, fmt.Errorf("Unknown OTLP protocol %q", opts.Protocol)
+	}
+}
+
+// newResource describes the monitor, attributes given in the options or in
+// $OTEL_RESOURCE_ATTRIBUTES override the detected ones
+func newResource(ctx context.Context, opts OTLPOpts) (*resource.Resource, error) {
+	attrs := []attribute.KeyValue{
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(opts.Build),
+	}
+	for k, v := range opts.Attributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	return resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithHost(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attrs...),
+		resource.WithFromEnv(),
+	)
+}
+
+// Shutdown exports the metrics one last time and stops
+func (o *OTLP) Shutdown(ctx context.Context) error {
+	return o.provider.Shutdown(ctx)
+}
@@ -0,0 +1,161 @@
+package export
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// stubCollector keeps every export request it receives
+type stubCollector struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	requests chan *collectormetrics.ExportMetricsServiceRequest
+}
+
+func newStubCollector() *stubCollector {
+	return &stubCollector{requests: make(chan *collectormetrics.ExportMetricsServiceRequest, 10)}
+}
+
+func (c *stubCollector) Export(_ context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	c.requests <- req
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func (c *stubCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/metrics" || r.Header.Get("X-Api-Key") != "k3y" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &collectormetrics.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.requests <- req
+
+	resp, _ := proto.Marshal(&collectormetrics.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+func newTestRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	pings := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ping_total", Help: "Pings"}, []string{"ip"})
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "ping_duration_seconds", Help: "Durations"}, []string{"ip"})
+	reg.MustRegister(pings, durations)
+	pings.WithLabelValues("1.1.1.1").Add(3)
+	durations.WithLabelValues("1.1.1.1").Observe(0.02)
+	return reg
+}
+
+// checkExport checks the request has the resource attributes and the
+// registry's metrics
+func checkExport(t *testing.T, req *collectormetrics.ExportMetricsServiceRequest) {
+	t.Helper()
+	if len(req.ResourceMetrics) != 1 {
+		t.Fatalf("Expected 1 resource, got %d", len(req.ResourceMetrics))
+	}
+	rm := req.ResourceMetrics[0]
+
+	attrs := make(map[string]string)
+	for _, kv := range rm.Resource.Attributes {
+		attrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	for k, v := range map[string]string{"service.name": serviceName, "service.version": "test", "site": "lon1"} {
+		if attrs[k] != v {
+			t.Errorf("Expected resource attribute %s=%s, got %q", k, v, attrs[k])
+		}
+	}
+	if attrs["host.name"] == "" {
+		t.Error("Expected the host.name resource attribute")
+	}
+
+	metrics := make(map[string]*metricspb.Metric)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+	sum := metrics["ping_total"].GetSum()
+	if sum == nil || !sum.IsMonotonic || len(sum.DataPoints) != 1 || sum.DataPoints[0].GetAsDouble() != 3 {
+		t.Errorf("Expected ping_total as a monotonic sum of 3, got %v", metrics["ping_total"])
+	}
+	hist := metrics["ping_duration_seconds"].GetHistogram()
+	if hist == nil || len(hist.DataPoints) != 1 || hist.DataPoints[0].Count != 1 {
+		t.Errorf("Expected ping_duration_seconds as a histogram of 1 observation, got %v", metrics["ping_duration_seconds"])
+	}
+}
+
+// exportOnce stops an exporter that's just started, which exports once as
+// the interval hasn't passed
+func exportOnce(t *testing.T, collector *stubCollector, opts OTLPOpts) {
+	t.Helper()
+	opts.Interval = time.Hour
+	opts.Timeout = 5 * time.Second
+	opts.Build = "test"
+	opts.Attributes = map[string]string{"site": "lon1"}
+
+	ctx := context.Background()
+	o, err := NewOTLP(ctx, newTestRegistry(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case req := <-collector.requests:
+		checkExport(t, req)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collector received nothing")
+	}
+}
+
+func TestOTLPGRPC(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := newStubCollector()
+	server := grpc.NewServer()
+	collectormetrics.RegisterMetricsServiceServer(server, collector)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	exportOnce(t, collector, OTLPOpts{Endpoint: "http://" + ln.Addr().String(), Protocol: "grpc"})
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	collector := newStubCollector()
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	exportOnce(t, collector, OTLPOpts{
+		Endpoint: server.URL,
+		Protocol: "http",
+		Headers:  map[string]string{"X-Api-Key": "k3y"},
+	})
+}
+
+func TestOTLPUnknownProtocol(t *testing.T) {
+	_, err := NewOTLP(context.Background(), newTestRegistry(), OTLPOpts{Endpoint: "http://localhost:4317", Protocol: "thrift"})
+	if err == nil {
+		t.Error("Expected an error for an unknown protocol")
+	}
+}
@@ -0,0 +1,78 @@
+package export
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"network_monitor/internal/config"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+type PushgatewayOpts struct {
+	URL      string
+	Job      string
+	Interval time.Duration
+	Timeout  time.Duration
+	Grouping map[string]string // instance is set if missing
+}
+
+// Pushgateway replaces the monitor's group on a Pushgateway with the
+// registry every interval. Nothing is buffered, pushes that fail are lost
+type Pushgateway struct {
+	pusher  *push.Pusher
+	opts    PushgatewayOpts
+	metrics *config.Metrics
+	done    chan struct{}
+}
+
+func NewPushgateway(g prometheus.Gatherer, opts PushgatewayOpts, metrics *config.Metrics) *Pushgateway {
+	pusher := push.New(opts.URL, opts.Job).
+		Gatherer(g).
+		Client(&http.Client{Timeout: opts.Timeout})
+
+	grouping := defaultLabels(opts.Grouping)
+	delete(grouping, "job")
+	for k, v := range grouping {
+		pusher = pusher.Grouping(k, v)
+	}
+	return &Pushgateway{pusher: pusher, opts: opts, metrics: metrics, done: make(chan struct{})}
+}
+
+// Run pushes every interval until ctx is done, then pushes one last time
+// with a short deadline
+func (p *Pushgateway) Run(ctx context.Context) {
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.opts.Interval)
+		defer ticker.Stop()
+		for {
+			// A push cut off by stopping is made again below
+			if err := p.pusher.PushContext(ctx); err != nil && ctx.Err() == nil {
+				p.failed(err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				final, cancel := context.WithTimeout(context.Background(), drainTimeout)
+				defer cancel()
+				if err := p.pusher.PushContext(final); err != nil {
+					p.failed(err)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// Done is closed once Run has stopped and the last push is done
+func (p *Pushgateway) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *Pushgateway) failed(err error) {
+	slog.Warn("Failed to push to the Pushgateway", "error", err)
+	p.metrics.PushgatewayFailed.Inc()
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"context"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"network_monitor/internal/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPushgateway(t *testing.T) {
+	pushes := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pushes <- r
+		bodies <- string(body)
+	}))
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	metrics := config.NewMetrics(reg, nil)
+	metrics.TotalPingsCounter.WithLabelValues("1.1.1.1", "", "", "").Inc()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	NewPushgateway(reg, PushgatewayOpts{
+		URL:      server.URL,
+		Job:      "network-monitor",
+		Interval: time.Hour,
+		Timeout:  5 * time.Second,
+		Grouping: map[string]string{"instance": "pi-branch1", "site": "lon1"},
+	}, metrics).Run(ctx)
+
+	select {
+	case r := <-pushes:
+		if r.Method != http.MethodPut {
+			t.Errorf("Expected a PUT replacing the group, got %s", r.Method)
+		}
+		// Labels in the grouping key can come in any order
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/metrics/"), "/")
+		grouping := make(map[string]string)
+		for i := 0; i+1 < len(parts); i += 2 {
+			grouping[parts[i]] = parts[i+1]
+		}
+		want := map[string]string{"job": "network-monitor", "instance": "pi-branch1", "site": "lon1"}
+		if !maps.Equal(grouping, want) {
+			t.Errorf("Expected a push to the group %v, got %s", want, r.URL.Path)
+		}
+		if body := <-bodies; !strings.Contains(body, "ping_total") {
+			t.Error("Expected ping_total in the push")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing was pushed")
+	}
+}
+
+func TestPushgatewayPushesWhenStopping(t *testing.T) {
+	pushes := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes <- struct{}{}
+	}))
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	metrics := config.NewMetrics(reg, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := NewPushgateway(reg, PushgatewayOpts{
+		URL:      server.URL,
+		Job:      "network-monitor",
+		Interval: time.Hour,
+		Timeout:  5 * time.Second,
+	}, metrics)
+	p.Run(ctx)
+
+	select {
+	case <-pushes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing was pushed")
+	}
+	cancel()
+
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("The Pushgateway push didn't stop")
+	}
+	if len(pushes) != 1 {
+		t.Errorf("Expected a last push when stopping, got %d", len(pushes))
+	}
+}
@@ -0,0 +1,231 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"network_monitor/internal/config"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	remoteWriteMinBackoff = time.Second
+	remoteWriteMaxBackoff = time.Minute
+)
+
+type RemoteWriteOpts struct {
+	URL         string
+	Interval    time.Duration
+	Timeout     time.Duration
+	Headers     map[string]string
+	Labels      map[string]string // Added to every series, job and instance are set if missing
+	WALDir      string
+	WALMaxBytes int64
+	WALMaxAge   time.Duration // Older requests are dropped, unless it's 0
+}
+
+// RemoteWrite pushes a Prometheus registry to a remote_write endpoint. Each
+// collection is kept in a WAL on disk until the endpoint accepts it, so
+// samples taken while the uplink is down are sent once it's back
+type RemoteWrite struct {
+	g          prometheus.Gatherer
+	opts       RemoteWriteOpts
+	wal        *wal
+	client     *http.Client
+	metrics    *config.Metrics
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	done       chan struct{}
+}
+
+// rejectedError is returned for requests the endpoint will never accept,
+// retrying them would block everything behind them
+type rejectedError struct {
+	status int
+	body   string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("Remote write rejected with status %d: %s", e.status, e.body)
+}
+
+func NewRemoteWrite(g prometheus.Gatherer, opts RemoteWriteOpts, metrics *config.Metrics) (*RemoteWrite, error) {
+	w, err := openWAL(opts.WALDir, opts.WALMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	w.OnChange = func(records int, bytes int64) {
+		metrics.RemoteWriteWALQueued.Set(float64(records))
+		metrics.RemoteWriteWALBytes.Set(float64(bytes))
+	}
+	w.OnDrop = func() {
+		metrics.RemoteWriteDropped.WithLabelValues("wal_full").Inc()
+	}
+	w.changed()
+
+	opts.Labels = defaultLabels(opts.Labels)
+	return &RemoteWrite{
+		g:          g,
+		opts:       opts,
+		wal:        w,
+		client:     &http.Client{Timeout: opts.Timeout},
+		metrics:    metrics,
+		minBackoff: remoteWriteMinBackoff,
+		maxBackoff: remoteWriteMaxBackoff,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// defaultLabels fills in the job and instance labels a scrape would add
+func defaultLabels(labels map[string]string) map[string]string {
+	out := map[string]string{"job": serviceName}
+	if host, err := os.Hostname(); err == nil {
+		out["instance"] = host
+	}
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+// Run collects the registry every interval and sends what's in the WAL
+// until ctx is done. The registry is then collected one last time, to be
+// sent with the rest of the WAL on the next start
+func (r *RemoteWrite) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+		for {
+			if err := r.collect(time.Now()); err != nil {
+				slog.Warn("Failed to collect metrics for remote write", "error", err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		}
+	})
+	wg.Go(func() { r.sendLoop(ctx) })
+	go func() {
+		wg.Wait()
+		close(r.done)
+	}()
+}
+
+// Done is closed once Run has stopped and the last collection is in the WAL
+func (r *RemoteWrite) Done() <-chan struct{} {
+	return r.done
+}
+
+// collect gathers the registry and appends it to the WAL as one request
+func (r *RemoteWrite) collect(now time.Time) error {
+	families, err := r.g.Gather()
+	if err != nil {
+		return err
+	}
+	req := encodeWriteRequest(toSeries(families, r.opts.Labels, now.UnixMilli()))
+	return r.wal.append(now.UnixNano(), snappy.Encode(nil, req))
+}
+
+// sendLoop sends the oldest request in the WAL until it's accepted or
+// rejected, backing off while the endpoint can't be reached
+func (r *RemoteWrite) sendLoop(ctx context.Context) {
+	backoff := r.minBackoff
+	for {
+		rec, ok := r.wal.oldest()
+		if !ok {
+			select {
+			case <-r.wal.ready:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// The receiver would reject samples older than its out-of-order window
+		if r.opts.WALMaxAge > 0 && time.Since(time.Unix(0, rec.seq)) > r.opts.WALMaxAge {
+			slog.Debug("Dropping expired remote write request", "seq", rec.seq)
+			r.metrics.RemoteWriteDropped.WithLabelValues("expired").Inc()
+			r.wal.remove(rec)
+			continue
+		}
+
+		data, err := r.wal.read(rec)
+		if err != nil {
+			// Dropped to make room since it was returned
+			if !os.IsNotExist(err) {
+				slog.Warn("Failed to read WAL record", "error", err, "seq", rec.seq)
+			}
+			r.wal.remove(rec)
+			continue
+		}
+
+		err = r.send(ctx, data)
+		var rejected *rejectedError
+		switch {
+		case ctx.Err() != nil:
+			// Cut off by stopping, the request stays in the WAL
+			return
+		case err == nil:
+			r.metrics.RemoteWriteSent.Inc()
+			r.wal.remove(rec)
+			backoff = r.minBackoff
+		case errors.As(err, &rejected):
+			slog.Warn("Dropping remote write request", "error", err)
+			r.metrics.RemoteWriteDropped.WithLabelValues("rejected").Inc()
+			r.wal.remove(rec)
+		default:
+			slog.Debug("Remote write failed, retrying", "error", err, "backoff", backoff)
+			r.metrics.RemoteWriteFailed.Inc()
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, r.maxBackoff)
+		}
+	}
+}
+
+func (r *RemoteWrite) send(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.opts.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range r.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", serviceName)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	// Too many requests and server errors can succeed later
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return &rejectedError{status: resp.StatusCode, body: string(body)}
+	}
+	return fmt.Errorf("Remote write failed with status %d: %s", resp.StatusCode, body)
+}
@@ -0,0 +1,261 @@
+package export
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"network_monitor/internal/config"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// flakyEndpoint fails with each status in fail before accepting requests
+type flakyEndpoint struct {
+	t        *testing.T
+	mu       sync.Mutex
+	fail     []int
+	received [][]series
+}
+
+func (e *flakyEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Authorization") != "Bearer s3cret" {
+		http.Error(w, "unexpected headers", http.StatusBadRequest)
+		return
+	}
+	if len(e.fail) > 0 {
+		status := e.fail[0]
+		e.fail = e.fail[1:]
+		http.Error(w, "try later", status)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		e.t.Error(err)
+		return
+	}
+	req, err := snappy.Decode(nil, body)
+	if err != nil {
+		e.t.Error(err)
+		return
+	}
+	e.received = append(e.received, decodeWriteRequest(e.t, req))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *flakyEndpoint) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.received)
+}
+
+func TestRemoteWriteDeliversAfterOutage(t *testing.T) {
+	endpoint := &flakyEndpoint{t: t, fail: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusBadGateway}}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	pings := prometheus.NewCounter(prometheus.CounterOpts{Name: "ping_total", Help: "Pings"})
+	reg.MustRegister(pings)
+
+	r, err := NewRemoteWrite(reg, RemoteWriteOpts{
+		URL:         server.URL,
+		Timeout:     5 * time.Second,
+		Headers:     map[string]string{"Authorization": "Bearer s3cret"},
+		Labels:      map[string]string{"instance": "pi-branch1"},
+		WALDir:      t.TempDir(),
+		WALMaxBytes: 1 << 20,
+	}, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.minBackoff = time.Millisecond
+	r.maxBackoff = 5 * time.Millisecond
+
+	// Collected during the outage, before anything is sent
+	start := time.UnixMilli(1_700_000_000_000)
+	for i := range 3 {
+		pings.Inc()
+		if err := r.collect(start.Add(time.Duration(i) * time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.sendLoop(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for endpoint.count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	if len(endpoint.received) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(endpoint.received))
+	}
+	for i, req := range endpoint.received {
+		var found bool
+		for _, s := range req {
+			if s.labels[0].value != "ping_total" {
+				continue
+			}
+			found = true
+			if s.value != float64(i+1) || s.timestamp != start.Add(time.Duration(i)*time.Minute).UnixMilli() {
+				t.Errorf("Request %d: expected %d at its collection time, got %v at %d", i, i+1, s.value, s.timestamp)
+			}
+			if got := labelsString(s); got != "__name__=ping_total instance=pi-branch1 job=network-monitor " {
+				t.Errorf("Unexpected labels %s", got)
+			}
+		}
+		if !found {
+			t.Errorf("Request %d has no ping_total sample", i)
+		}
+	}
+
+	if v := testutil.ToFloat64(metrics.RemoteWriteFailed); v != 3 {
+		t.Errorf("Expected 3 failed attempts, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.RemoteWriteWALQueued); v != 0 {
+		t.Errorf("Expected an empty WAL, got %v requests", v)
+	}
+}
+
+func TestRemoteWriteDropsRejectedRequests(t *testing.T) {
+	endpoint := &flakyEndpoint{t: t, fail: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	r, err := NewRemoteWrite(reg, RemoteWriteOpts{
+		URL:         server.URL,
+		Timeout:     5 * time.Second,
+		Headers:     map[string]string{"Authorization": "Bearer s3cret"},
+		WALDir:      t.TempDir(),
+		WALMaxBytes: 1 << 20,
+	}, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := r.collect(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.sendLoop(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for endpoint.count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if endpoint.count() != 1 {
+		t.Fatalf("Expected the request after the rejected one to be sent, got %d", endpoint.count())
+	}
+	if v := testutil.ToFloat64(metrics.RemoteWriteDropped.WithLabelValues("rejected")); v != 1 {
+		t.Errorf("Expected 1 rejected request, got %v", v)
+	}
+}
+
+func TestRemoteWriteDropsExpiredRequests(t *testing.T) {
+	endpoint := &flakyEndpoint{t: t}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	r, err := NewRemoteWrite(reg, RemoteWriteOpts{
+		URL:         server.URL,
+		Timeout:     5 * time.Second,
+		Headers:     map[string]string{"Authorization": "Bearer s3cret"},
+		WALDir:      t.TempDir(),
+		WALMaxBytes: 1 << 20,
+		WALMaxAge:   time.Hour,
+	}, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, at := range []time.Time{now.Add(-2 * time.Hour), now} {
+		if err := r.collect(at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.sendLoop(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for endpoint.count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if endpoint.count() != 1 {
+		t.Fatalf("Expected only the recent request to be sent, got %d", endpoint.count())
+	}
+	if v := testutil.ToFloat64(metrics.RemoteWriteDropped.WithLabelValues("expired")); v != 1 {
+		t.Errorf("Expected 1 expired request, got %v", v)
+	}
+}
+
+func TestRemoteWriteStops(t *testing.T) {
+	// The endpoint hangs, so stopping cuts the request off
+	requests := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Read so the server notices when the client goes
+		io.ReadAll(r.Body)
+		requests <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	r, err := NewRemoteWrite(reg, RemoteWriteOpts{
+		URL:         server.URL,
+		Interval:    time.Hour,
+		Timeout:     time.Minute,
+		WALDir:      t.TempDir(),
+		WALMaxBytes: 1 << 20,
+	}, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Run(ctx)
+	select {
+	case <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Nothing was sent")
+	}
+	cancel()
+
+	select {
+	case <-r.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Remote write didn't stop")
+	}
+	// The request that was cut off and the last collection are kept
+	if v := testutil.ToFloat64(metrics.RemoteWriteWALQueued); v != 2 {
+		t.Errorf("Expected 2 requests left in the WAL, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.RemoteWriteFailed); v != 0 {
+		t.Errorf("Expected stopping not to count as a failure, got %v", v)
+	}
+}
@@ -0,0 +1,146 @@
+package export
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+// series is one sample of a time series, as remote_write sends it
+type series struct {
+	labels    []label // Sorted by name
+	value     float64
+	timestamp int64 // In milliseconds
+}
+
+// toSeries flattens gathered metric families into samples at now, histograms
+// and summaries become their _bucket, _sum and _count series as they're
+// scraped. Labels of the metric win over extra labels with the same name
+func toSeries(families []*dto.MetricFamily, extra map[string]string, now int64) []series {
+	var out []series
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			ts := now
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(name string, value float64, more ...label) {
+				out = append(out, series{labels: seriesLabels(name, m.GetLabel(), extra, more), value: value, timestamp: ts})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					infSeen = infSeen || math.IsInf(b.GetUpperBound(), 1)
+					add(name+"_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !infSeen {
+					add(name+"_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			}
+		}
+	}
+	return out
+}
+
+// seriesLabels returns the sorted labels of a series, empty values are left
+// out as Prometheus treats them as missing, so extra labels fill them in
+func seriesLabels(name string, pairs []*dto.LabelPair, extra map[string]string, more []label) []label {
+	labels := []label{{"__name__", name}}
+	seen := make(map[string]bool)
+	for _, p := range pairs {
+		if p.GetValue() != "" {
+			seen[p.GetName()] = true
+			labels = append(labels, label{p.GetName(), p.GetValue()})
+		}
+	}
+	for _, l := range more {
+		seen[l.name] = true
+		labels = append(labels, l)
+	}
+	for n, v := range extra {
+		if !seen[n] && v != "" {
+			labels = append(labels, label{n, v})
+		}
+	}
+	slices.SortFunc(labels, func(a, b label) int { return strings.Compare(a.name, b.name) })
+	return labels
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Field numbers of the remote_write protobuf messages
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// encodeWriteRequest encodes a prometheus.WriteRequest holding one sample
+// for each series
+func encodeWriteRequest(all []series) []byte {
+	var b, ts, msg []byte
+	for _, s := range all {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, labelName, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, labelValue, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+			ts = protowire.AppendTag(ts, timeSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, sampleValue, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, sampleTimestamp, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, timeSeriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
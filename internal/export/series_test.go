@@ -0,0 +1,109 @@
+package export
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes what encodeWriteRequest encodes
+func decodeWriteRequest(t *testing.T, b []byte) []series {
+	t.Helper()
+	var out []series
+	each := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			n = fn(num, typ, b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+
+	each(b, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		var s series
+		each(ts, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			switch num {
+			case timeSeriesLabels:
+				var l label
+				each(msg, func(num protowire.Number, _ protowire.Type, b []byte) int {
+					v, n := protowire.ConsumeString(b)
+					if num == labelName {
+						l.name = v
+					} else {
+						l.value = v
+					}
+					return n
+				})
+				s.labels = append(s.labels, l)
+			case timeSeriesSamples:
+				each(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if typ == protowire.Fixed64Type {
+						v, n := protowire.ConsumeFixed64(b)
+						s.value = math.Float64frombits(v)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					s.timestamp = int64(v)
+					return n
+				})
+			}
+			return n
+		})
+		out = append(out, s)
+		return n
+	})
+	return out
+}
+
+func labelsString(s series) string {
+	var str string
+	for _, l := range s.labels {
+		str += l.name + "=" + l.value + " "
+	}
+	return str
+}
+
+func TestToSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pings := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ping_total", Help: "Pings"}, []string{"ip", "role"})
+	durations := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ping_duration_seconds", Help: "Durations", Buckets: []float64{0.01, 0.1}})
+	reg.MustRegister(pings, durations)
+	pings.WithLabelValues("1.1.1.1", "").Add(3)
+	durations.Observe(0.05)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeWriteRequest(t, encodeWriteRequest(toSeries(families, map[string]string{"site": "lon1", "ip": "none", "role": "unknown"}, 1000)))
+
+	var lines []string
+	for _, s := range got {
+		if s.timestamp != 1000 {
+			t.Errorf("Expected timestamp 1000, got %d", s.timestamp)
+		}
+		lines = append(lines, labelsString(s)+formatFloat(s.value))
+	}
+	want := []string{
+		"__name__=ping_duration_seconds_bucket ip=none le=0.01 role=unknown site=lon1 0",
+		"__name__=ping_duration_seconds_bucket ip=none le=0.1 role=unknown site=lon1 1",
+		"__name__=ping_duration_seconds_bucket ip=none le=+Inf role=unknown site=lon1 1",
+		"__name__=ping_duration_seconds_sum ip=none role=unknown site=lon1 0.05",
+		"__name__=ping_duration_seconds_count ip=none role=unknown site=lon1 1",
+		"__name__=ping_total ip=1.1.1.1 role=unknown site=lon1 3",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("Expected\n%q\ngot\n%q", want, lines)
+	}
+}
@@ -0,0 +1,176 @@
+package export
+
+import (
+	"context"
+	"log/slog"
+	"network_monitor/internal/config"
+	"time"
+)
+
+// drainTimeout is how long the results left when a batcher stops, and the
+// last push to the Pushgateway, have to be written, so stopping isn't held
+// up by an endpoint that's down
+const drainTimeout = 5 * time.Second
+
+// ResultTimeout is the class of results for requests that weren't answered
+const ResultTimeout = "timeout"
+
+// Result is the outcome of one echo request, a reply or a timeout
+type Result struct {
+	Time      time.Time
+	IP        string
+	Interface string
+	Role      string
+	Group     string
+	Labels    map[string]string // Target labels, shared so they mustn't be changed
+	Class     string            // Reply class, or ResultTimeout
+	Seq       int               // Echo sequence, 0 for timeouts
+	RTT       time.Duration     // 0 for timeouts
+	TTL       int               // IP TTL of the reply, 0 when unknown
+	Corrupted bool
+}
+
+// Sink writes batches of results to a time series database
+type Sink interface {
+	// Write is only called from one goroutine, it mustn't keep results
+	Write(ctx context.Context, results []Result) error
+	Close() error
+}
+
+type BatchOpts struct {
+	Size          int // Results written at once
+	FlushInterval time.Duration
+	QueueSize     int // Results waiting to be written, beyond it they're dropped
+	Retries       int // Attempts after the first before a batch is dropped
+	Timeout       time.Duration
+}
+
+// Batcher queues results for a sink and writes them in batches, when the
+// sink is too slow or down results are dropped rather than blocking pings
+type Batcher struct {
+	name       string
+	sink       Sink
+	opts       BatchOpts
+	queue      chan Result
+	metrics    *config.Metrics
+	minBackoff time.Duration
+	done       chan struct{}
+}
+
+func NewBatcher(name string, sink Sink, opts BatchOpts, metrics *config.Metrics) *Batcher {
+	return &Batcher{
+		name:       name,
+		sink:       sink,
+		opts:       opts,
+		queue:      make(chan Result, opts.QueueSize),
+		metrics:    metrics,
+		minBackoff: time.Second,
+		done:       make(chan struct{}),
+	}
+}
+
+// Add queues a result without waiting
+func (b *Batcher) Add(r Result) {
+	select {
+	case b.queue <- r:
+	default:
+		b.metrics.SinkResultsDropped.WithLabelValues(b.name, "queue_full").Inc()
+	}
+}
+
+// Run writes a batch whenever it's full or the flush interval passes, until
+// ctx is done. What's left is then written before the sink is closed
+func (b *Batcher) Run(ctx context.Context) {
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(b.opts.FlushInterval)
+		defer ticker.Stop()
+		batch := make([]Result, 0, b.opts.Size)
+		for {
+			if ctx.Err() != nil {
+				b.drain(batch)
+				if err := b.sink.Close(); err != nil {
+					slog.Warn("Failed to close sink", "sink", b.name, "error", err)
+				}
+				return
+			}
+			select {
+			case r := <-b.queue:
+				batch = append(batch, r)
+				if len(batch) < b.opts.Size {
+					continue
+				}
+			case <-ticker.C:
+				if len(batch) == 0 {
+					continue
+				}
+			case <-ctx.Done():
+				continue
+			}
+			// Left for drain when ctx is done part way
+			if b.flush(ctx, batch) {
+				batch = batch[:0]
+			}
+		}
+	}()
+}
+
+// Done is closed once Run has stopped and the sink is closed
+func (b *Batcher) Done() <-chan struct{} {
+	return b.done
+}
+
+// drain writes batch and the results still queued, with a short deadline
+func (b *Batcher) drain(batch []Result) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	for {
+		select {
+		case r := <-b.queue:
+			batch = append(batch, r)
+			if len(batch) < b.opts.Size {
+				continue
+			}
+		default:
+			if len(batch) == 0 {
+				return
+			}
+		}
+		if !b.flush(ctx, batch) {
+			dropped := len(batch) + len(b.queue)
+			slog.Warn("Dropping results left when stopping", "sink", b.name, "results", dropped)
+			b.metrics.SinkResultsDropped.WithLabelValues(b.name, "write_failed").Add(float64(dropped))
+			return
+		}
+		batch = batch[:0]
+	}
+}
+
+// flush writes batch, retrying with a backoff while results keep queueing.
+// It returns false if ctx was done before the batch was written or dropped
+func (b *Batcher) flush(ctx context.Context, batch []Result) bool {
+	backoff := b.minBackoff
+	for attempt := 0; ; attempt++ {
+		writeCtx, cancel := context.WithTimeout(ctx, b.opts.Timeout)
+		err := b.sink.Write(writeCtx, batch)
+		cancel()
+		if err == nil {
+			b.metrics.SinkResultsWritten.WithLabelValues(b.name).Add(float64(len(batch)))
+			return true
+		}
+
+		b.metrics.SinkWriteErrors.WithLabelValues(b.name).Inc()
+		if attempt == b.opts.Retries {
+			slog.Warn("Dropping results the sink failed to write", "sink", b.name, "error", err, "results", len(batch))
+			b.metrics.SinkResultsDropped.WithLabelValues(b.name, "write_failed").Add(float64(len(batch)))
+			return true
+		}
+		slog.Debug("Sink write failed, retrying", "sink", b.name, "error", err, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
+		}
+		backoff = min(backoff*2, b.opts.FlushInterval)
+	}
+}
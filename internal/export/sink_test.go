@@ -0,0 +1,152 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"network_monitor/internal/config"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeSink records the size of every batch, failing the first failures
+// writes
+type fakeSink struct {
+	mu       sync.Mutex
+	failures int
+	batches  []int
+	block    chan struct{} // Writes wait on it when set
+}
+
+func (s *fakeSink) Write(ctx context.Context, results []Result) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.batches = append(s.batches, len(results))
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func (s *fakeSink) written() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batches...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatcherFlushesFullBatchesAndOnInterval(t *testing.T) {
+	sink := &fakeSink{}
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	b := NewBatcher("fake", sink, BatchOpts{Size: 3, FlushInterval: 50 * time.Millisecond, QueueSize: 10, Timeout: time.Second}, metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.Run(ctx)
+
+	for range 4 {
+		b.Add(Result{IP: "1.1.1.1"})
+	}
+	// A full batch straight away, then the rest once the interval passes
+	waitFor(t, func() bool { return len(sink.written()) == 2 })
+	if got := sink.written(); got[0] != 3 || got[1] != 1 {
+		t.Errorf("Expected batches of 3 and 1, got %v", got)
+	}
+	if v := testutil.ToFloat64(metrics.SinkResultsWritten.WithLabelValues("fake")); v != 4 {
+		t.Errorf("Expected 4 results written, got %v", v)
+	}
+}
+
+func TestBatcherRetriesThenDrops(t *testing.T) {
+	sink := &fakeSink{failures: 4}
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	b := NewBatcher("fake", sink, BatchOpts{Size: 2, FlushInterval: time.Hour, QueueSize: 10, Retries: 2, Timeout: time.Second}, metrics)
+	b.minBackoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.Run(ctx)
+
+	// The first batch fails 3 times and is dropped, the second fails once
+	for range 4 {
+		b.Add(Result{IP: "1.1.1.1"})
+	}
+	waitFor(t, func() bool { return len(sink.written()) == 1 })
+	if v := testutil.ToFloat64(metrics.SinkResultsDropped.WithLabelValues("fake", "write_failed")); v != 2 {
+		t.Errorf("Expected 2 results dropped, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.SinkWriteErrors.WithLabelValues("fake")); v != 4 {
+		t.Errorf("Expected 4 write errors, got %v", v)
+	}
+}
+
+func TestBatcherDropsWhenQueueIsFull(t *testing.T) {
+	sink := &fakeSink{block: make(chan struct{})}
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	b := NewBatcher("fake", sink, BatchOpts{Size: 1, FlushInterval: time.Hour, QueueSize: 2, Timeout: time.Second}, metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.Run(ctx)
+
+	// One result is being written, two wait in the queue
+	b.Add(Result{IP: "1.1.1.1"})
+	waitFor(t, func() bool { return len(b.queue) == 0 })
+	done := make(chan struct{})
+	go func() {
+		for range 5 {
+			b.Add(Result{IP: "1.1.1.1"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Add blocked while the sink was slow")
+	}
+	close(sink.block)
+
+	if v := testutil.ToFloat64(metrics.SinkResultsDropped.WithLabelValues("fake", "queue_full")); v != 3 {
+		t.Errorf("Expected 3 results dropped, got %v", v)
+	}
+	waitFor(t, func() bool { return len(sink.written()) == 3 })
+}
+
+func TestBatcherFlushesWhenStopped(t *testing.T) {
+	sink := &fakeSink{}
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	b := NewBatcher("fake", sink, BatchOpts{Size: 10, FlushInterval: time.Hour, QueueSize: 10, Timeout: time.Second}, metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Run(ctx)
+
+	for range 3 {
+		b.Add(Result{IP: "1.1.1.1"})
+	}
+	cancel()
+	select {
+	case <-b.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Batcher didn't stop")
+	}
+	if got := sink.written(); len(got) != 1 || got[0] != 3 {
+		t.Errorf("Expected the 3 queued results to be written when stopping, got %v", got)
+	}
+}
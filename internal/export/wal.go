@@ -0,0 +1,156 @@
+package export
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const walExt = ".snappy"
+
+// walRecord is one request waiting in the WAL
+type walRecord struct {
+	seq  int64
+	size int64
+}
+
+// wal keeps requests on disk until they're sent, each in its own file named
+// by its sequence number so they're sent in order after a restart
+type wal struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	records []walRecord // Oldest first
+	bytes   int64
+	lastSeq int64
+	ready   chan struct{} // Signalled when a record is appended
+
+	OnChange func(records int, bytes int64)
+	OnDrop   func() // Called for every record dropped to stay under maxBytes
+}
+
+// openWAL loads the records left in dir, creating it if needed
+func openWAL(dir string, maxBytes int64) (*wal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wal{dir: dir, maxBytes: maxBytes, ready: make(chan struct{}, 1)}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, walExt) {
+			// Left behind by a write that didn't finish
+			if strings.HasSuffix(name, ".tmp") {
+				os.Remove(filepath.Join(dir, name))
+			}
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, walExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		w.records = append(w.records, walRecord{seq: seq, size: info.Size()})
+		w.bytes += info.Size()
+	}
+	slices.SortFunc(w.records, func(a, b walRecord) int { return cmp.Compare(a.seq, b.seq) })
+	if len(w.records) > 0 {
+		w.lastSeq = w.records[len(w.records)-1].seq
+		slog.Info("Loaded remote_write WAL", "requests", len(w.records), "bytes", w.bytes)
+	}
+	return w, nil
+}
+
+func (w *wal) path(seq int64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, walExt))
+}
+
+// append writes data as the newest record, dropping the oldest records if
+// the WAL would grow beyond maxBytes
+func (w *wal) append(seq int64, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Keep the order even if the clock goes backwards
+	seq = max(seq, w.lastSeq+1)
+	tmp := w.path(seq) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.path(seq)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	w.lastSeq = seq
+	w.records = append(w.records, walRecord{seq: seq, size: int64(len(data))})
+	w.bytes += int64(len(data))
+
+	for w.bytes > w.maxBytes && len(w.records) > 1 {
+		w.removeLocked(w.records[0])
+		if w.OnDrop != nil {
+			w.OnDrop()
+		}
+	}
+	w.changed()
+
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// oldest returns the record to send next
+func (w *wal) oldest() (walRecord, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.records) == 0 {
+		return walRecord{}, false
+	}
+	return w.records[0], true
+}
+
+func (w *wal) read(r walRecord) ([]byte, error) {
+	return os.ReadFile(w.path(r.seq))
+}
+
+// remove deletes a record once it's been sent, it may already have been
+// dropped to make room
+func (w *wal) remove(r walRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.removeLocked(r)
+	w.changed()
+}
+
+func (w *wal) removeLocked(r walRecord) {
+	i := slices.IndexFunc(w.records, func(other walRecord) bool { return other.seq == r.seq })
+	if i == -1 {
+		return
+	}
+	if err := os.Remove(w.path(r.seq)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove WAL record", "error", err, "seq", r.seq)
+	}
+	w.records = slices.Delete(w.records, i, i+1)
+	w.bytes -= r.size
+}
+
+func (w *wal) changed() {
+	if w.OnChange != nil {
+		w.OnChange(len(w.records), w.bytes)
+	}
+}
@@ -0,0 +1,79 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWALKeepsRecordsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range []string{"first", "second", "third"} {
+		if err := w.append(int64(10-i), []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec, _ := w.oldest()
+	w.remove(rec)
+	// Left by a crash while writing
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000099.snappy.tmp"), []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = openWAL(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		rec, ok := w.oldest()
+		if !ok {
+			break
+		}
+		data, err := w.read(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+		w.remove(rec)
+	}
+	// Sequence numbers that go backwards still keep the order
+	if len(got) != 2 || got[0] != "second" || got[1] != "third" {
+		t.Errorf("Expected second and third in order, got %q", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty WAL directory, got %d files", len(entries))
+	}
+}
+
+func TestWALDropsOldestWhenFull(t *testing.T) {
+	w, err := openWAL(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drops := 0
+	w.OnDrop = func() { drops++ }
+
+	for i, data := range []string{"aaaa", "bbbb", "cccc"} {
+		if err := w.append(int64(i), []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec, _ := w.oldest()
+	data, err := w.read(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drops != 1 || string(data) != "bbbb" || w.bytes != 8 {
+		t.Errorf("Expected the first record to be dropped, got %d drops, oldest %q, %d bytes", drops, data, w.bytes)
+	}
+}
@@ -0,0 +1,481 @@
+package monitoring
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"network_monitor/internal/config"
+	"network_monitor/internal/discovery"
+	"network_monitor/internal/network"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	roleAPI   = "api"
+	sourceAPI = "api"
+)
+
+// apiExpiryCheck is how often targets are checked for having expired
+const apiExpiryCheck = time.Second
+
+// apiTarget is a target added through the API
+type apiTarget struct {
+	IP        string            `json:"ip"`
+	Options   map[string]string `json:"options,omitempty"` // As in -ping-ips, such as size or iface
+	Labels    map[string]string `json:"labels,omitempty"`
+	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+	CreatedBy string            `json:"created_by"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedBy string            `json:"updated_by"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type apiTargetRequest struct {
+	IP        string            `json:"ip"`
+	Options   map[string]string `json:"options"`
+	Labels    map[string]string `json:"labels"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+	TTL       string            `json:"ttl"` // Instead of expires_at, such as 2h
+}
+
+// TargetAPI adds, updates and deletes targets at /api/v1/targets. Requests
+// need a bearer token, the name the token was given is recorded in the audit
+// log along with every change
+type TargetAPI struct {
+	m         *Manager
+	mu        sync.Mutex
+	targets   map[string]apiTarget // By IP
+	tokens    map[string]string    // Names by token
+	stateFile string
+	audit     *slog.Logger
+}
+
+// NewTargetAPI loads the tokens and the targets saved by an earlier run, and
+// starts pinging them
+func (m *Manager) NewTargetAPI() (*TargetAPI, error) {
+	tokens, err := loadTokens(m.opts.APITokensFile)
+	if err != nil {
+		return nil, err
+	}
+
+	audit := slog.Default()
+	if m.opts.APIAuditFile != "" {
+		f, err := os.OpenFile(m.opts.APIAuditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		audit = slog.New(slog.NewJSONHandler(f, nil))
+	}
+
+	a := TargetAPI{
+		m:         m,
+		targets:   make(map[string]apiTarget),
+		tokens:    tokens,
+		stateFile: m.opts.APIStateFile,
+		audit:     audit.With("audit", true),
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	// Targets can have expired while the monitor wasn't running
+	if a.expire(time.Now()) {
+		a.expired()
+	} else {
+		a.apply()
+	}
+	go a.runExpiry()
+
+	return &a, nil
+}
+
+// loadTokens reads a file of name:token lines, blank lines and lines starting
+// with # are skipped
+func loadTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, token, ok := strings.Cut(line, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("Expected name:token in %s", path)
+		}
+		tokens[token] = name
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("No tokens in %s", path)
+	}
+	return tokens, nil
+}
+
+func (a *TargetAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/targets", a.list)
+	mux.HandleFunc("POST /api/v1/targets", a.create)
+	mux.HandleFunc("GET /api/v1/targets/{ip}", a.get)
+	mux.HandleFunc("PUT /api/v1/targets/{ip}", a.put)
+	mux.HandleFunc("DELETE /api/v1/targets/{ip}", a.delete)
+	return a.Authenticate(mux)
+}
+
+type userKey struct{}
+
+// user returns the name of the token the request was authenticated with
+func user(r *http.Request) string {
+	name, _ := r.Context().Value(userKey{}).(string)
+	return name
+}
+
+// Authenticate only passes on requests with a bearer token from the tokens
+// file, so other endpoints can be protected like the API
+func (a *TargetAPI) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for t, name := range a.tokens {
+				if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, name)))
+					return
+				}
+			}
+		}
+		slog.Warn("Unauthorised API request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "A valid bearer token is required", http.StatusUnauthorized)
+	})
+}
+
+func (a *TargetAPI) list(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	targets := a.sorted()
+	a.mu.Unlock()
+	writeJSON(w, http.StatusOK, targets)
+}
+
+func (a *TargetAPI) sorted() []apiTarget {
+	targets := make([]apiTarget, 0, len(a.targets))
+	for _, ip := range slices.Sorted(maps.Keys(a.targets)) {
+		targets = append(targets, a.targets[ip])
+	}
+	return targets
+}
+
+func (a *TargetAPI) get(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	t, ok := a.targets[r.PathValue("ip")]
+	a.mu.Unlock()
+	if !ok {
+		http.Error(w, "No API target with that IP", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (a *TargetAPI) create(w http.ResponseWriter, r *http.Request) {
+	a.save(w, r, "", false)
+}
+
+func (a *TargetAPI) put(w http.ResponseWriter, r *http.Request) {
+	a.save(w, r, r.PathValue("ip"), true)
+}
+
+// save creates a target, or with replace set updates it if it exists
+func (a *TargetAPI) save(w http.ResponseWriter, r *http.Request, ip string, replace bool) {
+	var req apiTargetRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ip != "" && req.IP != "" && req.IP != ip {
+		http.Error(w, "The IP in the body doesn't match the path", http.StatusBadRequest)
+		return
+	}
+	if ip != "" {
+		req.IP = ip
+	}
+
+	user := user(r)
+	now := time.Now()
+	t, err := newAPITarget(req, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	old, exists := a.targets[t.IP]
+	if exists && !replace {
+		http.Error(w, "An API target with that IP already exists", http.StatusConflict)
+		return
+	}
+
+	// The options of a target that's already pinged would be ignored
+	targets, err := a.autoTargets(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if slices.ContainsFunc(targets, func(t autoTarget) bool { return a.m.shadowed(sourceAPI, t) }) {
+		http.Error(w, "The IP is already pinged as a configured or discovered target", http.StatusConflict)
+		return
+	}
+
+	t.CreatedBy, t.CreatedAt = user, now
+	if exists {
+		t.CreatedBy, t.CreatedAt = old.CreatedBy, old.CreatedAt
+	}
+	t.UpdatedBy, t.UpdatedAt = user, now
+	a.targets[t.IP] = t
+	if err := a.changed(); err != nil {
+		if exists {
+			a.targets[t.IP] = old
+		} else {
+			delete(a.targets, t.IP)
+		}
+		saveFailed(w, a.stateFile, err)
+		return
+	}
+
+	action, status := "create", http.StatusCreated
+	if exists {
+		action, status = "update", http.StatusOK
+	}
+	a.record(user, action, t, r)
+	writeJSON(w, status, t)
+}
+
+func (a *TargetAPI) delete(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, ok := a.targets[r.PathValue("ip")]
+	if !ok {
+		http.Error(w, "No API target with that IP", http.StatusNotFound)
+		return
+	}
+	delete(a.targets, t.IP)
+	if err := a.changed(); err != nil {
+		a.targets[t.IP] = t
+		saveFailed(w, a.stateFile, err)
+		return
+	}
+	a.record(user(r), "delete", t, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveFailed reports a change that was undone as the targets couldn't be
+// saved, it would have been lost on restart
+func saveFailed(w http.ResponseWriter, file string, err error) {
+	slog.Error("Error saving API targets", "error", err, "file", file)
+	http.Error(w, "Unable to save the targets, the change was not made", http.StatusInternalServerError)
+}
+
+// newAPITarget checks the request describes a target that can be pinged
+func newAPITarget(req apiTargetRequest, now time.Time) (apiTarget, error) {
+	ip := net.ParseIP(req.IP).To4()
+	if ip == nil {
+		return apiTarget{}, errors.New("ip must be an IPv4 address")
+	}
+	if _, err := config.NewTarget(ip.String(), req.Options); err != nil {
+		return apiTarget{}, err
+	}
+	labels, err := discovery.ValidateLabels(req.Labels)
+	if err != nil {
+		return apiTarget{}, err
+	}
+
+	t := apiTarget{IP: ip.String(), Options: req.Options, Labels: labels, ExpiresAt: req.ExpiresAt}
+	if req.TTL != "" {
+		if req.ExpiresAt != nil {
+			return apiTarget{}, errors.New("Only one of ttl and expires_at can be set")
+		}
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			return apiTarget{}, errors.New("ttl must be a positive duration, such as 90m")
+		}
+		expires := now.Add(ttl)
+		t.ExpiresAt = &expires
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(now) {
+		return apiTarget{}, errors.New("expires_at must be in the future")
+	}
+	return t, nil
+}
+
+func (a *TargetAPI) record(user string, action string, t apiTarget, r *http.Request) {
+	attrs := []any{"user", user, "action", action, "ip", t.IP, "options", t.Options, "labels", t.Labels}
+	if t.ExpiresAt != nil {
+		attrs = append(attrs, "expires_at", t.ExpiresAt)
+	}
+	if r != nil {
+		attrs = append(attrs, "remote", r.RemoteAddr)
+	}
+	a.audit.Info("API target changed", attrs...)
+}
+
+func (a *TargetAPI) runExpiry() {
+	ticker := time.NewTicker(apiExpiryCheck)
+	for now := range ticker.C {
+		a.mu.Lock()
+		if a.expire(now) {
+			a.expired()
+		}
+		a.mu.Unlock()
+	}
+}
+
+// expire deletes the targets that have expired by now, it returns true if
+// there were any
+func (a *TargetAPI) expire(now time.Time) bool {
+	expired := false
+	for ip, t := range a.targets {
+		if t.ExpiresAt != nil && !t.ExpiresAt.After(now) {
+			delete(a.targets, ip)
+			a.record("expiry", "expire", t, nil)
+			expired = true
+		}
+	}
+	return expired
+}
+
+// changed saves the targets and applies them to the manager, they're only
+// applied once they're saved
+func (a *TargetAPI) changed() error {
+	if err := a.persist(); err != nil {
+		return err
+	}
+	a.apply()
+	return nil
+}
+
+// expired applies the targets after some expired, even if they can't be
+// saved, as the expired ones are dropped again when the file is loaded
+func (a *TargetAPI) expired() {
+	if err := a.changed(); err != nil {
+		slog.Error("Error saving API targets", "error", err, "file", a.stateFile)
+		a.apply()
+	}
+}
+
+func (a *TargetAPI) apply() {
+	targets := make([]autoTarget, 0, len(a.targets))
+	for _, t := range a.targets {
+		ts, err := a.autoTargets(t)
+		if err != nil {
+			slog.Error("Invalid API target", "error", err, "ip", t.IP)
+			continue
+		}
+		targets = append(targets, ts...)
+	}
+	a.m.setSource(sourceAPI, targets)
+}
+
+// autoTargets returns t as a target for each bind it's pinged over
+func (a *TargetAPI) autoTargets(t apiTarget) ([]autoTarget, error) {
+	target, err := config.NewTarget(t.IP, t.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Labels in the body are added over ones in the options
+	labels := maps.Clone(target.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	maps.Copy(labels, t.Labels)
+
+	binds := a.m.providerBinds()
+	if target.Bind.Interface != "" || target.Bind.Source != nil {
+		binds = []network.Bind{target.Bind}
+	}
+	targets := make([]autoTarget, 0, len(binds))
+	for _, bind := range binds {
+		targets = append(targets, autoTarget{ip: t.IP, role: roleAPI, bind: bind, group: target.Group,
+			labels: labels, probe: target.Probe, trace: target.Trace})
+	}
+	return targets, nil
+}
+
+// persist writes the targets to the state file, replacing it in one go so
+// it's never left half written
+func (a *TargetAPI) persist() error {
+	if a.stateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(a.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(a.stateFile), filepath.Base(a.stateFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.stateFile)
+}
+
+func (a *TargetAPI) load() error {
+	if a.stateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var targets []apiTarget
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return fmt.Errorf("Unable to parse %s: %w", a.stateFile, err)
+	}
+	for _, t := range targets {
+		a.targets[t.IP] = t
+	}
+	slog.Info("Loaded API targets", "count", len(targets), "file", a.stateFile)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing API response", "error", err.Error())
+	}
+}
@@ -0,0 +1,293 @@
+package monitoring
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"network_monitor/internal/config"
+	"network_monitor/internal/network"
+	"network_monitor/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLoadTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# clients\nops: s3cret\n\nbot:t0ken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := loadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens["s3cret"] != "ops" || tokens["t0ken"] != "bot" {
+		t.Errorf("Unexpected tokens %v", tokens)
+	}
+
+	for _, contents := range []string{"", "ops\n", "ops:\n", ":s3cret\n"} {
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadTokens(path); err == nil {
+			t.Errorf("Expected an error loading %q", contents)
+		}
+	}
+}
+
+func TestNewAPITarget(t *testing.T) {
+	now := time.Now()
+	target, err := newAPITarget(apiTargetRequest{
+		IP:      "10.0.0.1",
+		Options: map[string]string{"size": "1400"},
+		Labels:  map[string]string{"incident": "INC-1"},
+		TTL:     "2h",
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.ExpiresAt == nil || !target.ExpiresAt.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("Expected to expire in 2h, got %v", target.ExpiresAt)
+	}
+
+	past := now.Add(-time.Minute)
+	for name, req := range map[string]apiTargetRequest{
+		"hostname":       {IP: "example.com"},
+		"ipv6":           {IP: "fd00::1"},
+		"invalid option": {IP: "10.0.0.1", Options: map[string]string{"size": "1"}},
+		"unknown option": {IP: "10.0.0.1", Options: map[string]string{"colour": "blue"}},
+		"invalid label":  {IP: "10.0.0.1", Labels: map[string]string{"not-valid": "x"}},
+		"invalid ttl":    {IP: "10.0.0.1", TTL: "soon"},
+		"expired":        {IP: "10.0.0.1", ExpiresAt: &past},
+		"ttl and expiry": {IP: "10.0.0.1", TTL: "1h", ExpiresAt: &now},
+	} {
+		if _, err := newAPITarget(req, now); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	a := TargetAPI{tokens: map[string]string{"s3cret": "ops"}}
+	var name string
+	handler := a.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name = user(r)
+	}))
+
+	for auth, status := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"s3cret":        http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer s3cret": http.StatusOK,
+	} {
+		name = ""
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/targets", nil)
+		req.Header.Set("Authorization", auth)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		if res.Code != status {
+			t.Errorf("%q: expected status %d, got %d", auth, status, res.Code)
+		}
+		if status == http.StatusOK && name != "ops" {
+			t.Errorf("%q: expected user ops, got %q", auth, name)
+		}
+	}
+}
+
+func TestAPIExpire(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	a := TargetAPI{
+		targets: map[string]apiTarget{
+			"10.0.0.1": {IP: "10.0.0.1", ExpiresAt: &now},
+			"10.0.0.2": {IP: "10.0.0.2", ExpiresAt: &later},
+			"10.0.0.3": {IP: "10.0.0.3"},
+		},
+		audit: slog.Default(),
+	}
+
+	if !a.expire(now) {
+		t.Error("Expected a target to expire")
+	}
+	if _, ok := a.targets["10.0.0.1"]; ok || len(a.targets) != 2 {
+		t.Errorf("Expected only 10.0.0.1 to expire, left with %v", a.targets)
+	}
+	if a.expire(now) {
+		t.Error("Expected nothing left to expire")
+	}
+}
+
+// newTestAPI returns an API over a manager pinging 192.0.2.1 as a configured
+// target, the ping loop is never run
+func newTestAPI(t *testing.T) (*TargetAPI, *pinger) {
+	m := &Manager{
+		metrics:      config.NewMetrics(prometheus.NewRegistry(), nil),
+		traceTracker: utils.NewTracker[[]network.Hop](),
+		replyTTLs:    utils.NewTracker[int](),
+		sources:      make(map[string][]autoTarget),
+		autoTargets:  make(map[string]autoTarget),
+	}
+	p, err := m.newPinger(network.Bind{})
+	if err != nil {
+		t.Skip("Unable to open an ICMP socket:", err)
+	}
+	p.addTarget(&net.IPAddr{IP: net.ParseIP("192.0.2.1")}, network.ProbeOpts{}, targetInfo{})
+
+	return &TargetAPI{
+		m:         m,
+		targets:   make(map[string]apiTarget),
+		tokens:    map[string]string{"s3cret": "ops"},
+		stateFile: filepath.Join(t.TempDir(), "api_targets.json"),
+		audit:     slog.New(slog.DiscardHandler),
+	}, p
+}
+
+func apiRequest(t *testing.T, a *TargetAPI, method string, path string, body string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	res := httptest.NewRecorder()
+	a.Handler().ServeHTTP(res, req)
+	return res.Code
+}
+
+func TestAPIChangesTargets(t *testing.T) {
+	a, p := newTestAPI(t)
+
+	if code := apiRequest(t, a, http.MethodPost, "/api/v1/targets", `{"ip": "10.0.0.1", "labels": {"incident": "INC-1"}}`); code != http.StatusCreated {
+		t.Fatalf("Expected the target to be created, got %d", code)
+	}
+	if info, ok := p.targets.Lookup("10.0.0.1"); !ok || info.role != roleAPI || info.labels["incident"] != "INC-1" {
+		t.Errorf("Expected 10.0.0.1 to be pinged with its labels, got %v", info)
+	}
+	if code := apiRequest(t, a, http.MethodPost, "/api/v1/targets", `{"ip": "10.0.0.1"}`); code != http.StatusConflict {
+		t.Errorf("Expected creating the target again to conflict, got %d", code)
+	}
+
+	if code := apiRequest(t, a, http.MethodPut, "/api/v1/targets/10.0.0.1", `{"options": {"size": "1400"}}`); code != http.StatusOK {
+		t.Fatalf("Expected the target to be updated, got %d", code)
+	}
+	if target := a.m.autoTargets["10.0.0.1"]; target.probe.Size != 1400 {
+		t.Errorf("Expected the new size to be pinged, got %v", target.probe)
+	}
+	if _, ok := p.targets.Lookup("10.0.0.1"); !ok {
+		t.Error("Expected 10.0.0.1 to still be pinged after the update")
+	}
+
+	if code := apiRequest(t, a, http.MethodDelete, "/api/v1/targets/10.0.0.1", ""); code != http.StatusNoContent {
+		t.Fatalf("Expected the target to be deleted, got %d", code)
+	}
+	if _, ok := p.targets.Lookup("10.0.0.1"); ok || len(a.m.autoTargets) != 0 {
+		t.Error("Expected 10.0.0.1 to no longer be pinged")
+	}
+	if code := apiRequest(t, a, http.MethodDelete, "/api/v1/targets/10.0.0.1", ""); code != http.StatusNotFound {
+		t.Errorf("Expected deleting the target again to fail, got %d", code)
+	}
+}
+
+func TestAPIRejectsShadowedTargets(t *testing.T) {
+	a, p := newTestAPI(t)
+	a.m.setSource("/etc/targets.json", []autoTarget{{ip: "10.0.0.2", role: "file_sd"}})
+
+	for _, ip := range []string{"192.0.2.1", "10.0.0.2"} {
+		if code := apiRequest(t, a, http.MethodPost, "/api/v1/targets", `{"ip": "`+ip+`", "options": {"size": "1400"}}`); code != http.StatusConflict {
+			t.Errorf("%s: expected a conflict, got %d", ip, code)
+		}
+		if _, ok := a.targets[ip]; ok {
+			t.Errorf("%s: expected no API target to be saved", ip)
+		}
+	}
+	if info, _ := p.targets.Lookup("192.0.2.1"); info.role != "" {
+		t.Errorf("Expected the configured target to be left alone, got role %q", info.role)
+	}
+}
+
+func TestAPIStateRoundTrip(t *testing.T) {
+	a, _ := newTestAPI(t)
+	if code := apiRequest(t, a, http.MethodPost, "/api/v1/targets", `{"ip": "10.0.0.1", "options": {"size": "1400"}, "labels": {"incident": "INC-1"}, "ttl": "2h"}`); code != http.StatusCreated {
+		t.Fatalf("Expected the target to be created, got %d", code)
+	}
+	saved := a.targets["10.0.0.1"]
+
+	reloaded := TargetAPI{targets: make(map[string]apiTarget), stateFile: a.stateFile}
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := reloaded.targets["10.0.0.1"]
+	if !ok || len(reloaded.targets) != 1 {
+		t.Fatalf("Expected only 10.0.0.1 to be reloaded, got %v", reloaded.targets)
+	}
+	if got.Options["size"] != "1400" || got.Labels["incident"] != "INC-1" || got.CreatedBy != "ops" {
+		t.Errorf("Expected %v, got %v", saved, got)
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(*saved.ExpiresAt) || !got.CreatedAt.Equal(saved.CreatedAt) {
+		t.Errorf("Expected the times to survive the round trip, got %v", got)
+	}
+}
+
+func TestAPIUndoesChangesThatArentSaved(t *testing.T) {
+	a, p := newTestAPI(t)
+	if code := apiRequest(t, a, http.MethodPost, "/api/v1/targets", `{"ip": "10.0.0.1"}`); code != http.StatusCreated {
+		t.Fatalf("Expected the target to be created, got %d", code)
+	}
+
+	// The state file can't be written in a directory that doesn't exist
+	a.stateFile = filepath.Join(t.TempDir(), "missing", "api_targets.json")
+	if code := apiRequest(t, a, http.MethodPost, "/api/v1/targets", `{"ip": "10.0.0.2"}`); code != http.StatusInternalServerError {
+		t.Errorf("Expected creating a target to fail, got %d", code)
+	}
+	if _, ok := a.targets["10.0.0.2"]; ok {
+		t.Error("Expected the target that wasn't saved to be dropped")
+	}
+	if _, ok := p.targets.Lookup("10.0.0.2"); ok {
+		t.Error("Expected the target that wasn't saved not to be pinged")
+	}
+
+	if code := apiRequest(t, a, http.MethodPut, "/api/v1/targets/10.0.0.1", `{"options": {"size": "1400"}}`); code != http.StatusInternalServerError {
+		t.Errorf("Expected updating a target to fail, got %d", code)
+	}
+	if size := a.targets["10.0.0.1"].Options["size"]; size != "" {
+		t.Errorf("Expected the update to be undone, got size %q", size)
+	}
+
+	if code := apiRequest(t, a, http.MethodDelete, "/api/v1/targets/10.0.0.1", ""); code != http.StatusInternalServerError {
+		t.Errorf("Expected deleting a target to fail, got %d", code)
+	}
+	if _, ok := p.targets.Lookup("10.0.0.1"); !ok {
+		t.Error("Expected the target to still be pinged after the delete failed")
+	}
+}
+
+func TestAPIDeleteForgetsReplyTTLAndTrace(t *testing.T) {
+	a, p := newTestAPI(t)
+	if code := apiRequest(t, a, http.MethodPost, "/api/v1/targets", `{"ip": "10.0.0.1"}`); code != http.StatusCreated {
+		t.Fatalf("Expected the target to be created, got %d", code)
+	}
+	a.m.recordReplyTTL(p, "10.0.0.1", 60, a.m.metrics)
+	a.m.traceTracker.Set("10.0.0.1", []network.Hop{{TTL: 1}})
+
+	if code := apiRequest(t, a, http.MethodDelete, "/api/v1/targets/10.0.0.1", ""); code != http.StatusNoContent {
+		t.Fatalf("Expected the target to be deleted, got %d", code)
+	}
+	if _, ok := a.m.replyTTLs.Lookup("10.0.0.1"); ok {
+		t.Error("Expected the reply TTL to be forgotten")
+	}
+	if _, ok := a.m.traceTracker.Lookup("10.0.0.1"); ok {
+		t.Error("Expected the trace to be forgotten")
+	}
+
+	// Added again, the first reply isn't compared with the old TTL
+	if code := apiRequest(t, a, http.MethodPost, "/api/v1/targets", `{"ip": "10.0.0.1"}`); code != http.StatusCreated {
+		t.Fatalf("Expected the target to be created again, got %d", code)
+	}
+	a.m.recordReplyTTL(p, "10.0.0.1", 50, a.m.metrics)
+	if n := testutil.CollectAndCount(a.m.metrics.ReplyTTLChanges); n != 0 {
+		t.Errorf("Expected no TTL change to be counted, got %d series", n)
+	}
+}
@@ -0,0 +1,176 @@
+package monitoring
+
+import (
+	"log/slog"
+	"maps"
+	"net"
+	"network_monitor/internal/config"
+	"network_monitor/internal/network"
+	"slices"
+)
+
+// autoTarget is an IP the monitor found for itself rather than being given
+type autoTarget struct {
+	ip     string
+	role   string
+	bind   network.Bind
+	group  string
+	labels map[string]string
+	probe  network.ProbeOpts
+	trace  config.TraceMode // Overrides the trace policy when set
+}
+
+func (t autoTarget) key() string {
+	return seriesKey(t.ip, t.bind.Label())
+}
+
+// same reports whether t can keep being pinged as it is in place of old,
+// the group and labels can change without restarting it
+func (t autoTarget) same(old autoTarget) bool {
+	return t.role == old.role && t.probe.Equal(old.probe) && t.trace == old.trace
+}
+
+// setSource replaces the targets found by source, such as gateway discovery
+// or a file_sd file, and starts or stops pinging the IPs that changed. An IP
+// found by several sources is pinged until the last of them drops it, with
+// the role and labels of the source that sorts first
+func (m *Manager) setSource(source string, targets []autoTarget) {
+	m.autoMu.Lock()
+	defer m.autoMu.Unlock()
+
+	if len(targets) == 0 {
+		delete(m.sources, source)
+	} else {
+		m.sources[source] = targets
+	}
+
+	wanted := make(map[string]autoTarget)
+	for _, s := range slices.Sorted(maps.Keys(m.sources)) {
+		for _, t := range m.sources[s] {
+			if _, ok := wanted[t.key()]; !ok {
+				wanted[t.key()] = t
+			}
+		}
+	}
+
+	for key, old := range m.autoTargets {
+		if t, ok := wanted[key]; ok && t.same(old) {
+			continue
+		}
+		slog.Info("Removing discovered target", "ip", old.ip, "role", old.role, "interface", old.bind.Label(), "source", source)
+		m.removeAutoTarget(old.ip, old.bind)
+		delete(m.autoTargets, key)
+	}
+
+	for key, t := range wanted {
+		if old, ok := m.autoTargets[key]; ok {
+			if t.group != old.group || !maps.Equal(old.labels, t.labels) {
+				m.updateAutoTarget(t)
+				m.autoTargets[key] = t
+			}
+			continue
+		}
+
+		added, err := m.addAutoTarget(t)
+		if err != nil {
+			slog.Error("Error starting ping loop", "error", err, "interface", t.bind.Label())
+			continue
+		}
+		if !added {
+			slog.Debug("Discovered target is already pinged", "ip", t.ip, "role", t.role, "interface", t.bind.Label())
+			continue
+		}
+		slog.Info("Adding discovered target", "ip", t.ip, "role", t.role, "interface", t.bind.Label(), "source", source)
+		m.autoTargets[key] = t
+	}
+}
+
+// shadowed reports whether t is already pinged as a configured target or for
+// a source that sorts before source, so setting it for source has no effect
+func (m *Manager) shadowed(source string, t autoTarget) bool {
+	m.autoMu.Lock()
+	defer m.autoMu.Unlock()
+
+	for s, targets := range m.sources {
+		if s < source && slices.ContainsFunc(targets, func(o autoTarget) bool { return o.key() == t.key() }) {
+			return true
+		}
+	}
+	if _, ok := m.autoTargets[t.key()]; ok {
+		return false
+	}
+
+	m.pingersMu.Lock()
+	defer m.pingersMu.Unlock()
+	if p := m.findPinger(t.bind); p != nil {
+		_, ok := p.targets.Lookup(t.ip)
+		return ok
+	}
+	return false
+}
+
+// addAutoTarget pings a discovered IP, starting a pinger for its bind if
+// there isn't one. It returns false if the IP is already a target
+func (m *Manager) addAutoTarget(t autoTarget) (bool, error) {
+	m.pingersMu.Lock()
+	defer m.pingersMu.Unlock()
+
+	ip := &net.IPAddr{IP: net.ParseIP(t.ip)}
+	info := targetInfo{role: t.role, trace: m.traceMode(ip.IP, t.trace, t.group), group: t.group, labels: m.groupLabels(t.group, t.labels)}
+
+	p := m.findPinger(t.bind)
+	if p != nil {
+		if _, ok := p.targets.Lookup(t.ip); ok {
+			return false, nil
+		}
+		p.addTarget(ip, t.probe, info)
+		return true, nil
+	}
+
+	p, err := m.newPinger(t.bind)
+	if err != nil {
+		return false, err
+	}
+	p.addTarget(ip, t.probe, info)
+	return true, p.loop.Run()
+}
+
+func (m *Manager) updateAutoTarget(t autoTarget) {
+	m.pingersMu.Lock()
+	defer m.pingersMu.Unlock()
+
+	if p := m.findPinger(t.bind); p != nil {
+		if info, ok := p.targets.Lookup(t.ip); ok {
+			// The series are labelled with the group and labels, the old ones
+			// would stay behind with their last values
+			p.deleteSeries(t.ip, m.metrics)
+			info.group = t.group
+			info.labels = m.groupLabels(t.group, t.labels)
+			info.trace = m.traceMode(net.ParseIP(t.ip), t.trace, t.group)
+			p.targets.Set(t.ip, info)
+		}
+	}
+}
+
+// removeAutoTarget stops pinging a discovered IP over bind
+func (m *Manager) removeAutoTarget(ip string, bind network.Bind) {
+	m.pingersMu.Lock()
+	defer m.pingersMu.Unlock()
+
+	if p := m.findPinger(bind); p != nil {
+		m.removeTarget(p, ip)
+	}
+}
+
+// providerBinds returns the binds targets from discovery providers are
+// pinged over, which like configured targets is each of -ping-interfaces
+func (m *Manager) providerBinds() []network.Bind {
+	if len(m.opts.PingInterfaces) == 0 {
+		return []network.Bind{{}}
+	}
+	binds := make([]network.Bind, 0, len(m.opts.PingInterfaces))
+	for _, iface := range m.opts.PingInterfaces {
+		binds = append(binds, network.Bind{Interface: iface})
+	}
+	return binds
+}
@@ -0,0 +1,106 @@
+package monitoring
+
+import (
+	"log/slog"
+	"net"
+	"network_monitor/internal/network"
+	"slices"
+	"time"
+)
+
+// Roles of discovered targets, configured targets have no role
+const (
+	roleGateway = "gateway"
+	roleISPEdge = "isp-edge"
+)
+
+const sourceGateways = "gateways"
+
+// ispEdgeMaxHops is how far the trace looking for the ISP edge goes, the
+// first public hop is rarely further than a few hops from the gateway
+const ispEdgeMaxHops = 8
+
+// runDiscovery looks for the default gateways and the ISP edge every
+// interval, adding them as targets and removing the ones that went away
+func (m *Manager) runDiscovery() {
+	interval := time.Duration(m.opts.DiscoverInterval) * time.Second
+	for {
+		targets, err := m.discover()
+		if err != nil {
+			slog.Error("Error discovering gateways", "error", err)
+		} else {
+			m.setSource(sourceGateways, targets)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (m *Manager) discover() ([]autoTarget, error) {
+	gateways, err := network.DefaultGateways()
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]autoTarget, 0)
+	traced := make([]string, 0)
+	for _, gw := range gateways {
+		// The ping loop only sends ICMPv4
+		if gw.IP.To4() == nil {
+			slog.Debug("Skipping IPv6 gateway", "ip", gw.IP, "interface", gw.Interface)
+			continue
+		}
+
+		bind := m.gatewayBind(gw)
+		targets = append(targets, autoTarget{ip: gw.IP.String(), role: roleGateway, bind: bind})
+
+		// Gateways on the same bind lead to the same ISP edge
+		if slices.Contains(traced, bind.Label()) {
+			continue
+		}
+		traced = append(traced, bind.Label())
+
+		edge, err := m.ispEdge(bind)
+		if err != nil {
+			slog.Error("Error finding ISP edge", "error", err, "interface", bind.Label())
+			continue
+		}
+		if edge != "" && edge != gw.IP.String() {
+			targets = append(targets, autoTarget{ip: edge, role: roleISPEdge, bind: bind})
+		}
+	}
+
+	return targets, nil
+}
+
+// gatewayBind returns the bind a gateway and the ISP edge behind it are
+// pinged over. A gateway is only reachable over its own link, so that's its
+// interface even when it's not one of -ping-interfaces. Without them
+// everything follows the routing table, which reaches it over its link anyway
+func (m *Manager) gatewayBind(gw network.Gateway) network.Bind {
+	if len(m.opts.PingInterfaces) == 0 {
+		return network.Bind{}
+	}
+	return network.Bind{Interface: gw.Interface}
+}
+
+// ispEdge returns the first public hop on the path to the ISP edge probe IP,
+// or an empty string when there isn't one
+func (m *Manager) ispEdge(bind network.Bind) (string, error) {
+	ra, err := net.ResolveIPAddr("ip4:icmp", m.opts.ISPEdgeProbeIP)
+	if err != nil {
+		return "", err
+	}
+
+	opts := m.tracePool.opts
+	opts.DNS = nil
+	opts.ASN = nil
+	opts.MaxHops = ispEdgeMaxHops
+	opts.Probes = 1
+	opts.Bind = bind
+
+	hop, err := network.ISPEdge(ra, opts)
+	if err != nil || hop == nil {
+		return "", err
+	}
+	return hop.IP.String(), nil
+}
@@ -0,0 +1,23 @@
+package monitoring
+
+import (
+	"net"
+	"network_monitor/internal/config"
+	"network_monitor/internal/network"
+	"testing"
+)
+
+func TestGatewayBind(t *testing.T) {
+	gw := network.Gateway{IP: net.IPv4(192, 168, 8, 1), Interface: "wwan0"}
+
+	m := &Manager{opts: config.Opts{}}
+	if bind := m.gatewayBind(gw); bind.Interface != "" || bind.Source != nil {
+		t.Errorf("Expected the gateway to follow the routing table, got %+v", bind)
+	}
+
+	// Not one of the interfaces, but the only one the gateway can be reached on
+	m.opts.PingInterfaces = []string{"eth0"}
+	if bind := m.gatewayBind(gw); bind.Interface != "wwan0" {
+		t.Errorf("Expected the gateway to be bound to wwan0, got %+v", bind)
+	}
+}
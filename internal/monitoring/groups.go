@@ -0,0 +1,65 @@
+package monitoring
+
+import (
+	"slices"
+)
+
+// groupCount is the number of targets in a group and how many are up
+type groupCount struct {
+	targets int
+	up      int
+}
+
+// setUp records which targets replied in the interval that just ended
+func (p *pinger) setUp(timeouts []timeout) {
+	for ip := range p.targets.GetAll() {
+		timedOut := slices.ContainsFunc(timeouts, func(t timeout) bool { return t.ip == ip })
+		p.up.Set(ip, !timedOut)
+	}
+}
+
+// countGroups counts the targets of every group across all pingers, targets
+// that haven't finished an interval yet aren't up
+func (m *Manager) countGroups() map[string]groupCount {
+	m.pingersMu.Lock()
+	defer m.pingersMu.Unlock()
+
+	counts := make(map[string]groupCount)
+	for _, p := range m.pingers {
+		for ip, info := range p.targets.GetAll() {
+			if info.group == "" {
+				continue
+			}
+			c := counts[info.group]
+			c.targets++
+			if p.up.Get(ip) {
+				c.up++
+			}
+			counts[info.group] = c
+		}
+	}
+	return counts
+}
+
+// updateGroups sets the group aggregate metrics, deleting the series of
+// groups that no longer have any targets
+func (m *Manager) updateGroups() {
+	counts := m.countGroups()
+
+	m.groupsMu.Lock()
+	defer m.groupsMu.Unlock()
+
+	for group := range m.groups {
+		if _, ok := counts[group]; !ok {
+			m.metrics.GroupTargets.DeleteLabelValues(group)
+			m.metrics.GroupTargetsUp.DeleteLabelValues(group)
+			m.metrics.GroupUpRatio.DeleteLabelValues(group)
+		}
+	}
+	for group, c := range counts {
+		m.metrics.GroupTargets.WithLabelValues(group).Set(float64(c.targets))
+		m.metrics.GroupTargetsUp.WithLabelValues(group).Set(float64(c.up))
+		m.metrics.GroupUpRatio.WithLabelValues(group).Set(float64(c.up) / float64(c.targets))
+	}
+	m.groups = counts
+}
@@ -0,0 +1,109 @@
+package monitoring
+
+import (
+	"net"
+	"network_monitor/internal/config"
+	"network_monitor/internal/utils"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestPinger(iface string, groups map[string]string) *pinger {
+	p := &pinger{
+		iface:   iface,
+		targets: utils.NewTracker[targetInfo](),
+		up:      utils.NewTracker[bool](),
+	}
+	for ip, group := range groups {
+		p.targets.Set(ip, targetInfo{group: group})
+	}
+	return p
+}
+
+func TestUpdateGroups(t *testing.T) {
+	metrics := config.NewMetrics(prometheus.NewRegistry(), nil)
+	eth0 := newTestPinger("eth0", map[string]string{"1.1.1.1": "dns", "8.8.8.8": "dns", "10.0.0.1": ""})
+	wwan0 := newTestPinger("wwan0", map[string]string{"1.1.1.1": "dns", "192.0.2.1": "office"})
+	m := Manager{metrics: metrics, pingers: []*pinger{eth0, wwan0}}
+
+	eth0.setUp([]timeout{{ip: "8.8.8.8", count: 1}})
+	wwan0.setUp(nil)
+	m.updateGroups()
+
+	if v := testutil.ToFloat64(metrics.GroupTargets.WithLabelValues("dns")); v != 3 {
+		t.Errorf("Expected 3 targets in dns, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.GroupTargetsUp.WithLabelValues("dns")); v != 2 {
+		t.Errorf("Expected 2 targets up in dns, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.GroupUpRatio.WithLabelValues("dns")); v != 2.0/3 {
+		t.Errorf("Expected 2/3 of dns up, got %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.GroupUpRatio.WithLabelValues("office")); v != 1 {
+		t.Errorf("Expected all of office up, got %v", v)
+	}
+	if n := testutil.CollectAndCount(metrics.GroupTargets); n != 2 {
+		t.Errorf("Expected series for 2 groups, got %d", n)
+	}
+
+	wwan0.targets.Delete("192.0.2.1")
+	wwan0.up.Delete("192.0.2.1")
+	m.updateGroups()
+	if n := testutil.CollectAndCount(metrics.GroupTargets); n != 1 {
+		t.Errorf("Expected the office series to be deleted, got %d series", n)
+	}
+}
+
+func TestUpdateAutoTargetDeletesSeries(t *testing.T) {
+	metrics := config.NewMetrics(prometheus.NewRegistry(), []string{"site"})
+	p := newTestPinger("eth0", map[string]string{"1.1.1.1": "dns", "8.8.8.8": "dns"})
+	p.targetLabels = []string{"site"}
+	m := Manager{metrics: metrics, pingers: []*pinger{p}}
+	for _, ip := range []string{"1.1.1.1", "8.8.8.8"} {
+		labels, _ := p.labelValues(ip)
+		metrics.TotalPingsCounter.WithLabelValues(labels...).Inc()
+	}
+
+	m.updateAutoTarget(autoTarget{ip: "1.1.1.1", group: "resolvers", labels: map[string]string{"site": "lon"}})
+
+	info, _ := p.targets.Lookup("1.1.1.1")
+	if info.group != "resolvers" || info.labels["site"] != "lon" {
+		t.Errorf("Expected the group and labels to be updated, got %+v", info)
+	}
+	if n := testutil.CollectAndCount(metrics.TotalPingsCounter); n != 1 {
+		t.Errorf("Expected only the series of 8.8.8.8 to be left, got %d series", n)
+	}
+	labels, _ := p.labelValues("1.1.1.1")
+	if labels[3] != "resolvers" || labels[4] != "lon" {
+		t.Errorf("Expected new series to have the new labels, got %v", labels)
+	}
+}
+
+func TestTraceMode(t *testing.T) {
+	policy, err := config.ParseTracePolicy("rfc1918=never, default=both")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := map[string]config.Group{"office": {Trace: config.TracePeriodic}, "dns": {}}
+	m := Manager{opts: config.Opts{TracePolicy: policy, Groups: groups}}
+
+	tests := []struct {
+		ip    string
+		trace config.TraceMode
+		group string
+		want  config.TraceMode
+	}{
+		{"192.168.1.1", "", "", config.TraceNever},
+		{"192.168.1.1", "", "office", config.TracePeriodic},
+		{"192.168.1.1", config.TraceThreshold, "office", config.TraceThreshold},
+		{"1.1.1.1", "", "dns", config.TraceBoth},
+		{"1.1.1.1", "", "unknown", config.TraceBoth},
+	}
+	for _, test := range tests {
+		if got := m.traceMode(net.ParseIP(test.ip), test.trace, test.group); got != test.want {
+			t.Errorf("Expected %s in group %q with trace %q to be %s, got %s", test.ip, test.group, test.trace, test.want, got)
+		}
+	}
+}
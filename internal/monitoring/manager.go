@@ -2,126 +2,218 @@ package monitoring
 
 import (
 	"log/slog"
-	"net"
 	"network_monitor/internal/config"
+	"network_monitor/internal/discovery"
+	"network_monitor/internal/export"
 	"network_monitor/internal/network"
 	"network_monitor/internal/utils"
-	"os"
-	"strings"
+	"sync"
 	"time"
 )
 
 type Manager struct {
-	pingLoop       *network.PingLoop
-	opts           config.Opts
-	timeoutTracker *timeoutTracker
-	traceTracker   *utils.Tracker[[]network.Hop]
-	traceCountdown int
+	pingers      []*pinger
+	pingersMu    sync.Mutex // Held while discovered targets are added or removed
+	opts         config.Opts
+	metrics      *config.Metrics
+	traceTracker *utils.Tracker[[]network.Hop]
+	tracePool    *tracePool
+	mtrs         *utils.Tracker[mtrTarget]
+	replyTTLs    *utils.Tracker[int]
+	autoMu       sync.Mutex
+	sources      map[string][]autoTarget // Targets found by each source
+	autoTargets  map[string]autoTarget   // Targets being pinged for a source by series key
+	groupsMu     sync.Mutex
+	groups       map[string]groupCount // Groups with aggregate series
+	sinks        []*export.Batcher
 }
 
 func NewManager(opts config.Opts, metrics *config.Metrics) (*Manager, error) {
-	pl, err := network.NewPingLoop(opts.PingInterval)
-	if err != nil {
-		return nil, err
+	var err error
+	rdns := network.NewReverseDNS(network.ReverseDNSOpts{
+		PositiveTTL:   time.Duration(opts.RDNSPositiveTTL) * time.Second,
+		NegativeTTL:   time.Duration(opts.RDNSNegativeTTL) * time.Second,
+		Timeout:       time.Duration(opts.RDNSTimeout) * time.Second,
+		MaxConcurrent: opts.RDNSConcurrency,
+	})
+	rdns.OnLookup = func(hit bool) {
+		if hit {
+			metrics.RDNSCacheHits.Inc()
+		} else {
+			metrics.RDNSCacheMisses.Inc()
+		}
 	}
+
+	traceOpts := network.TraceOpts{
+		Method:        network.TraceMethod(opts.TraceMethod),
+		Port:          opts.TracePort,
+		Paris:         opts.TraceParis,
+		MaxHops:       opts.TraceMaxHops,
+		Probes:        opts.TraceProbes,
+		Timeout:       time.Duration(opts.TraceTimeout) * time.Second,
+		MaxSilentHops: opts.TraceMaxSilentHops,
+		DNS:           rdns,
+	}
+	if opts.ASNDatabase != "" {
+		traceOpts.ASN, err = network.OpenASNDatabase(opts.ASNDatabase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	m := Manager{
-		pingLoop:       pl,
-		opts:           opts,
-		timeoutTracker: newTimeoutTracker(opts.PingIps),
-		traceTracker:   utils.NewTracker[[]network.Hop](),
-		traceCountdown: opts.TraceFrequency,
+		opts:         opts,
+		metrics:      metrics,
+		traceTracker: utils.NewTracker[[]network.Hop](),
+		tracePool:    newTracePool(opts.TraceWorkers, traceOpts),
+		mtrs:         utils.NewTracker[mtrTarget](),
+		replyTTLs:    utils.NewTracker[int](),
+		sources:      make(map[string][]autoTarget),
+		autoTargets:  make(map[string]autoTarget),
 	}
 
-	m.addIps(opts.PingIps)
-	m.configure(metrics)
+	if err := m.addTargets(opts.Targets); err != nil {
+		return nil, err
+	}
+	m.addMTRs(opts.MTRIps, network.MTROpts{
+		Interval: time.Duration(opts.MTRInterval) * time.Second,
+		Window:   opts.MTRWindow,
+		Trace:    traceOpts,
+	})
 
 	return &m, nil
 }
 
 func (m *Manager) Run() {
-	m.pingLoop.Run()
+	for _, p := range m.pingers {
+		if err := p.loop.Run(); err != nil {
+			slog.Error("Error starting ping loop", "error", err, "interface", p.iface)
+		}
+	}
+	m.runMTRs(m.metrics)
+	if m.opts.PMTUInterval > 0 {
+		go m.runPMTU(pmtuTargets(m.opts.Targets), m.metrics)
+	}
+	if m.opts.DiscoverInterval > 0 {
+		go m.runDiscovery()
+	}
+	for _, s := range m.opts.Sweeps {
+		go m.runSweep(s, m.metrics)
+	}
+	if len(m.opts.FileSDPaths) > 0 {
+		refresh := time.Duration(m.opts.FileSDRefresh) * time.Second
+		go m.runProvider("file_sd", roleFileSD, discovery.NewFileSD(m.opts.FileSDPaths, refresh))
+	}
+	if len(m.opts.KubernetesMeshes) > 0 {
+		if err := m.runKubernetes(); err != nil {
+			slog.Error("Error starting Kubernetes discovery", "error", err)
+		}
+	}
 }
 
-func (m *Manager) addIps(ips []string) {
-	for _, ip := range ips {
-		ra, err := net.ResolveIPAddr("ip4:icmp", ip)
-		if err != nil {
-			slog.Error("Error resolving IP", "error", err.Error())
-			os.Exit(1)
+func (m *Manager) configure(p *pinger, metrics *config.Metrics) {
+	p.loop.OnIntervalStart = func() {
+		p.traceCountdown -= 1
+		slog.Debug("Trace countdown", "num", p.traceCountdown, "interface", p.iface)
+
+		for ip, info := range p.targets.GetAll() {
+			labels, _ := p.labelValues(ip)
+			metrics.TotalPingsCounter.WithLabelValues(labels...).Inc()
+
+			if p.traceCountdown == 0 && info.trace.Periodic() {
+				key := seriesKey(ip, p.iface)
+				m.tracePool.submit(ip, p.bind, p.iface, func(hops []network.Hop) {
+					slog.Debug("Trace run", "ip", ip, "interface", p.iface, "hops", hops, "as_path", network.ASPath(hops))
+					m.traceTracker.Set(key, hops)
+				})
+			}
+		}
+
+		if p.traceCountdown <= 0 {
+			p.traceCountdown = m.opts.TraceFrequency
 		}
-		m.pingLoop.AddIpAddr(ra)
 	}
-}
 
-func (m *Manager) configure(metrics *config.Metrics) {
-	m.pingLoop.OnIntervalStart = func() {
-		m.traceCountdown -= 1
-		slog.Debug("Trace countdown", "num", m.traceCountdown)
+	p.loop.OnResponse = func(res *network.PingLoopResponse) {
+		ip := res.Peer.String()
+		labels, ok := p.labelValues(ip)
+		if !ok {
+			slog.Debug("Received reply from removed target", "ip", ip, "interface", p.iface)
+			return
+		}
 
-		for _, ip := range m.opts.PingIps {
-			metrics.TotalPingsCounter.WithLabelValues(ip).Inc()
+		m.recordReplyTTL(p, ip, res.TTL, metrics)
+		metrics.RepliesCounter.WithLabelValues(append(labels, string(res.Class))...).Inc()
+		m.sendResult(p, replyResult(ip, res))
 
-			if m.traceCountdown == 0 {
-				if hops, ok := m.runTrace(ip); ok {
-					slog.Debug("Trace run", "ip", ip, "hops", hops)
-					m.traceTracker.Set(ip, hops)
-				}
-			}
+		// The first copy of the reply has already been recorded
+		if res.Class == network.ReplyDuplicate {
+			return
 		}
+		// Replies from earlier intervals were counted as timeouts when their
+		// interval ended, they mustn't hide a timeout in this one
+		onTime := res.Class == network.ReplyOnTime
 
-		if m.traceCountdown <= 0 {
-			m.traceCountdown = m.opts.TraceFrequency
+		if res.Corrupted {
+			metrics.CorruptedCounter.WithLabelValues(labels...).Inc()
+		}
+		if res.ClockAnomaly {
+			metrics.ClockAnomalies.WithLabelValues(labels...).Inc()
 		}
-	}
 
-	m.pingLoop.OnResponse = func(res *network.PingLoopResponse) {
 		// can receive 0s durations after a timeout, we should ignore them
 		if res.Duration > 0 {
-			metrics.DurationHist.WithLabelValues(res.Peer.String()).Observe(res.Duration.Seconds())
-			m.timeoutTracker.replyReceived(res.Peer.String())
+			metrics.DurationHist.WithLabelValues(labels...).Observe(res.Duration.Seconds())
+			if res.Kernel {
+				metrics.TimestampDiscrepancy.Observe((res.UserspaceDuration - res.Duration).Seconds())
+			}
+			if onTime {
+				p.timeoutTracker.replyReceived(ip)
+			}
 		}
 	}
 
-	m.pingLoop.OnIntervalEnd = func(ospid, seq int) {
-		if shouldCountTimeouts() {
-			timeouts := m.timeoutTracker.countTimeouts()
-			slog.Debug("Interval ended", "timeouts", timeouts)
+	p.loop.OnIntervalEnd = func(id, interval int) {
+		counting := shouldCountTimeouts()
+		timeouts := p.timeoutTracker.countTimeouts(counting)
+		// Sinks get every result, the downtime only keeps timeouts out of the
+		// metrics and from starting traces
+		for _, t := range timeouts {
+			m.sendResult(p, timeoutResult(t.ip))
+		}
+		if !counting {
+			slog.Debug("Timeout counting disabled")
+			return
+		}
 
-			for _, t := range timeouts {
-				metrics.TotalTimoutCounter.WithLabelValues(t.ip).Inc()
+		slog.Debug("Interval ended", "timeouts", timeouts, "interface", p.iface)
+		p.setUp(timeouts)
+		m.updateGroups()
 
-				if t.count >= m.opts.TraceTimeoutThreshold {
-					if hops, ok := m.runTrace(t.ip); ok {
-						slog.Warn("Ping threshold crossed", "ip", t.ip, "good", m.traceTracker.Get(t.ip), "bad", hops, "ospid", ospid, "seq", seq)
-						m.timeoutTracker.resetCount(t.ip)
-					}
+		for _, t := range timeouts {
+			labels, ok := p.labelValues(t.ip)
+			if !ok {
+				continue
+			}
+			metrics.TotalTimoutCounter.WithLabelValues(labels...).Inc()
+
+			info, _ := p.targets.Lookup(t.ip)
+			if t.count >= m.opts.TraceTimeoutThreshold && info.trace.Threshold() {
+				ip := t.ip
+				key := seriesKey(ip, p.iface)
+				submitted := m.tracePool.submit(ip, p.bind, p.iface, func(hops []network.Hop) {
+					good := m.traceTracker.Get(key)
+					slog.Warn("Ping threshold crossed", "ip", ip, "interface", p.iface, "good", good, "bad", hops,
+						"good_as_path", network.ASPath(good), "bad_as_path", network.ASPath(hops), "id", id, "interval", interval)
+				})
+				if submitted {
+					p.timeoutTracker.resetCount(ip)
 				}
 			}
-		} else {
-			slog.Debug("Timeout counting disabled")
 		}
 	}
 }
 
-func (m *Manager) runTrace(ip string) ([]network.Hop, bool) {
-	if strings.HasPrefix(ip, "192.168") {
-		return nil, false
-	}
-	ra, err := net.ResolveIPAddr("ip4:icmp", ip)
-	if err != nil {
-		slog.Error("Error resolving IP for traceroute", "error", err.Error(), "ip", ip)
-		return nil, false
-	}
-
-	hops, err := network.Traceroute(ra)
-	if err != nil {
-		slog.Error("Error from traceroute", "error", err.Error(), "ip", ip)
-		return nil, false
-	}
-
-	return hops, true
-}
-
 func shouldCountTimeouts() bool {
 	now := time.Now()
 	year, month, day := now.Date()
@@ -0,0 +1,122 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"network_monitor/internal/config"
+	"network_monitor/internal/network"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// mtrTarget is an MTR to an IP over one bind
+type mtrTarget struct {
+	ip    string
+	iface string
+	mtr   *network.MTR
+}
+
+// addMTRs runs an MTR to each IP over every bind that targets are pinged
+// over, so each link's path is watched like its pings
+func (m *Manager) addMTRs(ips []string, opts network.MTROpts) {
+	for _, ip := range ips {
+		ra, err := net.ResolveIPAddr("ip4:icmp", ip)
+		if err != nil {
+			slog.Error("Error resolving IP for MTR", "error", err.Error())
+			os.Exit(1)
+		}
+		for _, bind := range m.providerBinds() {
+			iface := bind.Label()
+			bindOpts := opts
+			bindOpts.Trace.Bind = bind
+			m.mtrs.Set(seriesKey(ip, iface), mtrTarget{ip, iface, network.NewMTR(ra, bindOpts)})
+		}
+	}
+}
+
+func (m *Manager) runMTRs(metrics *config.Metrics) {
+	for _, t := range m.mtrs.GetAll() {
+		go func() {
+			// Label sets from the previous round so hops that have gone
+			// away or changed address don't leave stale series behind
+			prev := make([]prometheus.Labels, 0)
+
+			t.mtr.Run(func(stats []network.HopStats) {
+				current := make([]prometheus.Labels, 0, len(stats))
+				for _, s := range stats {
+					addr := ""
+					if s.IP != nil {
+						addr = s.IP.String()
+					}
+					labels := prometheus.Labels{"ip": t.ip, "interface": t.iface, "hop": strconv.Itoa(s.TTL), "addr": addr}
+					current = append(current, labels)
+
+					metrics.MTRLoss.With(labels).Set(s.Loss())
+					if s.Received == 0 {
+						continue
+					}
+					metrics.MTRLast.With(labels).Set(s.Last.Seconds())
+					metrics.MTRAvg.With(labels).Set(s.Avg.Seconds())
+					metrics.MTRBest.With(labels).Set(s.Best.Seconds())
+					metrics.MTRWorst.With(labels).Set(s.Worst.Seconds())
+					metrics.MTRStdDev.With(labels).Set(s.StdDev.Seconds())
+				}
+
+				for _, labels := range prev {
+					if !containsLabels(current, labels) {
+						deleteMTRSeries(metrics, labels)
+					}
+				}
+				prev = current
+			})
+		}()
+	}
+}
+
+func containsLabels(all []prometheus.Labels, labels prometheus.Labels) bool {
+	for _, l := range all {
+		if l["hop"] == labels["hop"] && l["addr"] == labels["addr"] {
+			return true
+		}
+	}
+	return false
+}
+
+func deleteMTRSeries(metrics *config.Metrics, labels prometheus.Labels) {
+	metrics.MTRLoss.Delete(labels)
+	metrics.MTRLast.Delete(labels)
+	metrics.MTRAvg.Delete(labels)
+	metrics.MTRBest.Delete(labels)
+	metrics.MTRWorst.Delete(labels)
+	metrics.MTRStdDev.Delete(labels)
+}
+
+// HandleMTRReport writes the MTR report for every target, or for the ones
+// to the ip query parameter. MTRs over a specific interface have the
+// interface added to their source, such as host%wwan0
+func (m *Manager) HandleMTRReport(w http.ResponseWriter, r *http.Request) {
+	src, _ := os.Hostname()
+	ip := r.URL.Query().Get("ip")
+
+	reports := make([]network.MTRReport, 0)
+	for target, t := range m.mtrs.GetAll() {
+		if ip == "" || ip == target || strings.HasPrefix(target, ip+"%") {
+			reports = append(reports, t.mtr.Report(seriesKey(src, t.iface)))
+		}
+	}
+
+	if ip != "" && len(reports) == 0 {
+		http.Error(w, "No MTR running for ip", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		slog.Error("Error writing MTR report", "error", err.Error())
+	}
+}
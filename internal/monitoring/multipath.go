@@ -0,0 +1,92 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"network_monitor/internal/network"
+	"strconv"
+)
+
+type multipathResult struct {
+	hops []network.MultipathHop
+	err  error
+}
+
+// HandleMultipath runs a multipath discovery to the ip query parameter and
+// writes the next hops seen at each TTL. It's sent over the interface query
+// parameter when given, which has to be one that targets are pinged over.
+// Discoveries are run by the trace pool, so they share its workers and queue
+// with the traces, and only one runs to an IP at a time
+func (m *Manager) HandleMultipath(w http.ResponseWriter, r *http.Request) {
+	ip := r.URL.Query().Get("ip")
+	ra, err := net.ResolveIPAddr("ip4:icmp", ip)
+	if ip == "" || err != nil {
+		http.Error(w, "Unable to resolve ip", http.StatusBadRequest)
+		return
+	}
+
+	flows := 16
+	if f := r.URL.Query().Get("flows"); f != "" {
+		flows, err = strconv.Atoi(f)
+		if err != nil || flows < 1 || flows > 64 {
+			http.Error(w, "flows must be between 1 and 64", http.StatusBadRequest)
+			return
+		}
+	}
+
+	iface := r.URL.Query().Get("interface")
+	bind, ok := m.pingerBind(iface)
+	if !ok {
+		http.Error(w, "Nothing is pinged over interface", http.StatusBadRequest)
+		return
+	}
+	opts := m.tracePool.opts
+	opts.Bind = bind
+
+	// Buffered so the worker isn't left waiting when the client has gone
+	done := make(chan multipathResult, 1)
+	submitted := m.tracePool.submitJob(traceJob{kind: "multipath", ip: ra.String(), iface: iface, run: func() {
+		hops, err := network.Multipath(ra, opts, flows)
+		done <- multipathResult{hops, err}
+	}})
+	if !submitted {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "The trace queue is full or a multipath discovery to ip is already running", http.StatusServiceUnavailable)
+		return
+	}
+
+	var res multipathResult
+	select {
+	case res = <-done:
+	case <-r.Context().Done():
+		return
+	}
+	if res.err != nil {
+		slog.Error("Error from multipath discovery", "error", res.err.Error(), "ip", ra)
+		http.Error(w, res.err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res.hops); err != nil {
+		slog.Error("Error writing multipath discovery", "error", err.Error())
+	}
+}
+
+// pingerBind returns the bind of the pinger labelled iface, targets that
+// follow the routing table have an empty label
+func (m *Manager) pingerBind(iface string) (network.Bind, bool) {
+	if iface == "" {
+		return network.Bind{}, true
+	}
+	m.pingersMu.Lock()
+	defer m.pingersMu.Unlock()
+	for _, p := range m.pingers {
+		if p.iface == iface {
+			return p.bind, true
+		}
+	}
+	return network.Bind{}, false
+}
@@ -0,0 +1,168 @@
+package monitoring
+
+import (
+	"log/slog"
+	"maps"
+	"net"
+	"network_monitor/internal/config"
+	"network_monitor/internal/network"
+	"network_monitor/internal/utils"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pinger runs the ping loop for the targets bound to one interface or source
+// address, each keeps its own timeouts and trace countdown
+type pinger struct {
+	loop           *network.PingLoop
+	bind           network.Bind
+	iface          string   // Value of the interface label
+	targetLabels   []string // Target labels added to the ping metrics
+	targets        *utils.Tracker[targetInfo]
+	up             *utils.Tracker[bool] // Whether each target replied in its latest interval
+	timeoutTracker *timeoutTracker
+	traceCountdown int
+}
+
+type targetInfo struct {
+	role   string // Empty for configured IPs
+	trace  config.TraceMode
+	group  string
+	labels map[string]string // Including the labels of the group
+}
+
+// labelValues returns the values of the ping labels for ip followed by
+// extra, or false if ip is no longer a target
+func (p *pinger) labelValues(ip string, extra ...string) ([]string, bool) {
+	info, ok := p.targets.Lookup(ip)
+	values := []string{ip, p.iface, info.role, info.group}
+	for _, name := range p.targetLabels {
+		values = append(values, info.labels[name])
+	}
+	return append(values, extra...), ok
+}
+
+// seriesKey identifies a target in trackers, as the same IP can be pinged
+// over several interfaces
+func seriesKey(ip string, iface string) string {
+	if iface == "" {
+		return ip
+	}
+	return ip + "%" + iface
+}
+
+func sameBind(a network.Bind, b network.Bind) bool {
+	return a.Interface == b.Interface && a.Source.Equal(b.Source)
+}
+
+// addTargets adds each target to the pinger for its bind, starting a new one
+// for binds that haven't been seen before
+func (m *Manager) addTargets(targets []config.Target) error {
+	for _, t := range targets {
+		ra, err := net.ResolveIPAddr("ip4:icmp", t.IP)
+		if err != nil {
+			slog.Error("Error resolving IP", "error", err.Error())
+			os.Exit(1)
+		}
+
+		p := m.findPinger(t.Bind)
+		if p == nil {
+			p, err = m.newPinger(t.Bind)
+			if err != nil {
+				return err
+			}
+		}
+		trace := m.traceMode(ra.IP, t.Trace, t.Group)
+		p.addTarget(ra, t.Probe, targetInfo{trace: trace, group: t.Group, labels: m.groupLabels(t.Group, t.Labels)})
+	}
+	return nil
+}
+
+// groupLabels returns the labels of group with labels added over them
+func (m *Manager) groupLabels(group string, labels map[string]string) map[string]string {
+	merged := maps.Clone(m.opts.Groups[group].Labels)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	maps.Copy(merged, labels)
+	return merged
+}
+
+// traceMode returns the trace mode a target was given, or failing that the
+// mode of its group, or failing that the mode the trace policy gives ip
+func (m *Manager) traceMode(ip net.IP, trace config.TraceMode, group string) config.TraceMode {
+	if trace != "" {
+		return trace
+	}
+	if mode := m.opts.Groups[group].Trace; mode != "" {
+		return mode
+	}
+	return m.opts.TracePolicy.Mode(ip)
+}
+
+func (p *pinger) addTarget(ip *net.IPAddr, probe network.ProbeOpts, info targetInfo) {
+	p.targets.Set(ip.String(), info)
+	p.timeoutTracker.add(ip.String())
+	p.loop.AddTarget(ip, probe)
+}
+
+// removeTarget stops pinging ip from p and deletes its series, along with
+// its last reply TTL and trace so they aren't compared with if it's added
+// again
+func (m *Manager) removeTarget(p *pinger, ip string) {
+	p.loop.RemoveTarget(ip)
+	p.timeoutTracker.remove(ip)
+	p.targets.Delete(ip)
+	p.up.Delete(ip)
+	p.deleteSeries(ip, m.metrics)
+
+	key := seriesKey(ip, p.iface)
+	m.replyTTLs.Delete(key)
+	m.traceTracker.Delete(key)
+}
+
+// deleteSeries deletes every ping series of ip, whatever its other labels
+func (p *pinger) deleteSeries(ip string, metrics *config.Metrics) {
+	labels := prometheus.Labels{"ip": ip, "interface": p.iface}
+	metrics.TotalPingsCounter.DeletePartialMatch(labels)
+	metrics.TotalTimoutCounter.DeletePartialMatch(labels)
+	metrics.DurationHist.DeletePartialMatch(labels)
+	metrics.CorruptedCounter.DeletePartialMatch(labels)
+	metrics.RepliesCounter.DeletePartialMatch(labels)
+	metrics.ClockAnomalies.DeletePartialMatch(labels)
+	metrics.ReplyTTL.DeletePartialMatch(labels)
+	metrics.ReplyHops.DeletePartialMatch(labels)
+	metrics.ReplyTTLChanges.DeletePartialMatch(labels)
+}
+
+func (m *Manager) findPinger(bind network.Bind) *pinger {
+	for _, p := range m.pingers {
+		if sameBind(p.bind, bind) {
+			return p
+		}
+	}
+	return nil
+}
+
+// newPinger sets up a ping loop for bind, it's started by Run or, once the
+// manager is running, when its first target is added
+func (m *Manager) newPinger(bind network.Bind) (*pinger, error) {
+	pl, err := network.NewPingLoopOn(m.opts.PingInterval, bind)
+	if err != nil {
+		return nil, err
+	}
+	p := &pinger{
+		loop:           pl,
+		bind:           bind,
+		iface:          bind.Label(),
+		targetLabels:   m.opts.TargetLabels,
+		targets:        utils.NewTracker[targetInfo](),
+		up:             utils.NewTracker[bool](),
+		timeoutTracker: newTimeoutTracker(nil),
+		traceCountdown: m.opts.TraceFrequency,
+	}
+	m.configure(p, m.metrics)
+	m.pingers = append(m.pingers, p)
+	return p, nil
+}
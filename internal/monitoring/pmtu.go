@@ -0,0 +1,88 @@
+package monitoring
+
+import (
+	"log/slog"
+	"net"
+	"network_monitor/internal/config"
+	"network_monitor/internal/network"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pmtuTarget is an IP that path MTU discovery runs to over a bind
+type pmtuTarget struct {
+	ip    string
+	bind  network.Bind
+	iface string
+}
+
+// pmtuTargets returns each target once for every bind it's pinged over
+func pmtuTargets(targets []config.Target) []pmtuTarget {
+	pts := make([]pmtuTarget, 0, len(targets))
+	for _, t := range targets {
+		pt := pmtuTarget{ip: t.IP, bind: t.Bind, iface: t.Bind.Label()}
+		seen := slices.ContainsFunc(pts, func(other pmtuTarget) bool {
+			return other.ip == pt.ip && other.iface == pt.iface
+		})
+		if !seen {
+			pts = append(pts, pt)
+		}
+	}
+	return pts
+}
+
+// runPMTU discovers the path MTU to each target in turn every interval, over
+// the same interface it's pinged over
+func (m *Manager) runPMTU(targets []pmtuTarget, metrics *config.Metrics) {
+	interval := time.Duration(m.opts.PMTUInterval) * time.Second
+
+	for {
+		for _, t := range targets {
+			ip := t.ip
+			ra, err := net.ResolveIPAddr("ip4:icmp", ip)
+			if err != nil {
+				slog.Error("Error resolving IP for path MTU discovery", "error", err.Error(), "ip", ip)
+				continue
+			}
+
+			opts := network.PMTUOpts{MaxMTU: m.opts.PMTUMax, Bind: t.bind}
+			result, err := network.DiscoverPMTU(ra, opts)
+			if err != nil {
+				slog.Error("Error from path MTU discovery", "error", err.Error(), "ip", ip, "interface", t.iface)
+				// The last MTU found may no longer be right, it's better missing
+				deletePMTU(ip, t.iface, metrics)
+				continue
+			}
+			slog.Debug("Path MTU discovered", "ip", ip, "interface", t.iface, "mtu", result.MTU, "hop", result.Hop, "reported_mtu", result.ReportedMTU)
+
+			metrics.PMTU.WithLabelValues(ip, t.iface).Set(float64(result.MTU))
+
+			metrics.PMTUFragNeeded.DeletePartialMatch(prometheus.Labels{"ip": ip, "interface": t.iface})
+			if result.Hop != nil {
+				metrics.PMTUFragNeeded.WithLabelValues(ip, t.iface, result.Hop.String(), strconv.Itoa(result.ReportedMTU)).Set(1)
+			}
+
+			below := result.MTU < m.opts.PMTUAlertBelow
+			if below {
+				slog.Warn("Path MTU below threshold", "ip", ip, "interface", t.iface, "mtu", result.MTU, "threshold", m.opts.PMTUAlertBelow,
+					"hop", result.Hop, "reported_mtu", result.ReportedMTU)
+				metrics.PMTUBelowThreshold.WithLabelValues(ip, t.iface).Set(1)
+			} else {
+				metrics.PMTUBelowThreshold.WithLabelValues(ip, t.iface).Set(0)
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// deletePMTU deletes the path MTU series of ip over iface
+func deletePMTU(ip string, iface string, metrics *config.Metrics) {
+	labels := prometheus.Labels{"ip": ip, "interface": iface}
+	metrics.PMTU.DeletePartialMatch(labels)
+	metrics.PMTUFragNeeded.DeletePartialMatch(labels)
+	metrics.PMTUBelowThreshold.DeletePartialMatch(labels)
+}
@@ -0,0 +1,76 @@
+package monitoring
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"network_monitor/internal/discovery"
+	"time"
+)
+
+// Roles of targets from discovery providers
+const (
+	roleFileSD  = "file_sd"
+	roleK8sNode = "k8s-node"
+	roleK8sPod  = "k8s-pod"
+)
+
+// runProvider pings the targets a discovery provider finds, each update
+// replacing the targets of the source it's from
+func (m *Manager) runProvider(name string, role string, provider discovery.Provider) {
+	updates := make(chan discovery.Update)
+	go provider.Run(context.Background(), updates)
+
+	for update := range updates {
+		slog.Debug("Discovery update", "provider", name, "source", update.Source, "targets", len(update.Targets))
+		m.setSource(name+" "+update.Source, m.resolveTargets(role, update.Targets))
+	}
+}
+
+// runKubernetes starts a provider for each Kubernetes mesh
+func (m *Manager) runKubernetes() error {
+	client, err := discovery.NewInClusterClient()
+	if err != nil {
+		return err
+	}
+	namespace := m.opts.KubernetesNamespace
+	if namespace == "" {
+		namespace = discovery.InClusterNamespace()
+	}
+
+	for _, mesh := range m.opts.KubernetesMeshes {
+		opts := discovery.KubernetesOpts{
+			Mesh:      mesh,
+			Selector:  m.opts.KubernetesNodeSelector,
+			Namespace: namespace,
+			NodeName:  m.opts.KubernetesNodeName,
+			PodName:   m.opts.KubernetesPodName,
+			Interval:  time.Duration(m.opts.KubernetesInterval) * time.Second,
+		}
+		role := roleK8sNode
+		if mesh == discovery.MeshPods {
+			opts.Selector = m.opts.KubernetesPodSelector
+			role = roleK8sPod
+		}
+		go m.runProvider("kubernetes", role, discovery.NewKubernetes(client, opts))
+	}
+	return nil
+}
+
+// resolveTargets looks up the IPs of targets from a provider, a group label
+// puts the target in that group
+func (m *Manager) resolveTargets(role string, targets []discovery.Target) []autoTarget {
+	resolved := make([]autoTarget, 0, len(targets))
+	for _, t := range targets {
+		ra, err := net.ResolveIPAddr("ip4", t.Host)
+		if err != nil {
+			slog.Error("Error resolving discovered target", "error", err.Error(), "host", t.Host)
+			continue
+		}
+		for _, bind := range m.providerBinds() {
+			resolved = append(resolved, autoTarget{ip: ra.IP.String(), role: role, bind: bind,
+				group: t.Labels["group"], labels: t.Labels})
+		}
+	}
+	return resolved
+}
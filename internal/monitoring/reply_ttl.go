@@ -0,0 +1,34 @@
+package monitoring
+
+import (
+	"log/slog"
+	"network_monitor/internal/config"
+	"network_monitor/internal/network"
+)
+
+// recordReplyTTL exports the TTL of a reply and the return path length it
+// implies. A change in the TTL means the path back from the IP changed, which
+// the forward path traceroutes can't see when routing is asymmetric
+func (m *Manager) recordReplyTTL(p *pinger, ip string, ttl int, metrics *config.Metrics) {
+	labels, ok := p.labelValues(ip)
+	if ttl == 0 || !ok {
+		return
+	}
+
+	metrics.ReplyTTL.WithLabelValues(labels...).Set(float64(ttl))
+	hops := network.InferHops(ttl)
+	if hops >= 0 {
+		metrics.ReplyHops.WithLabelValues(labels...).Set(float64(hops))
+	}
+
+	key := seriesKey(ip, p.iface)
+	last := m.replyTTLs.Get(key)
+	m.replyTTLs.Set(key, ttl)
+	if last != 0 && last != ttl {
+		// Flapping paths change the TTL every few replies, the metric counts
+		// them so the log stays quiet unless debugging
+		slog.Debug("Reply TTL changed, the return path may have changed", "ip", ip, "interface", p.iface,
+			"old_ttl", last, "new_ttl", ttl, "old_hops", network.InferHops(last), "new_hops", hops)
+		metrics.ReplyTTLChanges.WithLabelValues(labels...).Inc()
+	}
+}
@@ -0,0 +1,49 @@
+package monitoring
+
+import (
+	"network_monitor/internal/export"
+	"network_monitor/internal/network"
+	"time"
+)
+
+// AddSink sends every reply and timeout to b, it must be called before Run
+func (m *Manager) AddSink(b *export.Batcher) {
+	m.sinks = append(m.sinks, b)
+}
+
+// sendResult passes r to every sink, with the details of its target
+func (m *Manager) sendResult(p *pinger, r export.Result) {
+	if len(m.sinks) == 0 {
+		return
+	}
+	info, ok := p.targets.Lookup(r.IP)
+	if !ok {
+		return
+	}
+	r.Interface = p.iface
+	r.Role = info.role
+	r.Group = info.group
+	r.Labels = info.labels
+	for _, s := range m.sinks {
+		s.Add(r)
+	}
+}
+
+func replyResult(ip string, res *network.PingLoopResponse) export.Result {
+	r := export.Result{
+		Time:      time.Now(),
+		IP:        ip,
+		Class:     string(res.Class),
+		RTT:       res.Duration,
+		TTL:       res.TTL,
+		Corrupted: res.Corrupted,
+	}
+	if res.Body != nil {
+		r.Seq = res.Body.Seq
+	}
+	return r
+}
+
+func timeoutResult(ip string) export.Result {
+	return export.Result{Time: time.Now(), IP: ip, Class: export.ResultTimeout}
+}
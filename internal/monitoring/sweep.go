@@ -0,0 +1,106 @@
+package monitoring
+
+import (
+	"log/slog"
+	"network_monitor/internal/config"
+	"network_monitor/internal/network"
+	"slices"
+	"time"
+)
+
+// roleSweep is the role of hosts promoted from a sweep
+const roleSweep = "sweep"
+
+// sweepHosts is every host a sweep has found, with the number of sweeps in
+// a row each has missed since it last replied
+type sweepHosts struct {
+	expireAfter int
+	missed      map[string]int
+}
+
+func newSweepHosts(expireAfter int) *sweepHosts {
+	return &sweepHosts{expireAfter: expireAfter, missed: make(map[string]int)}
+}
+
+// update records the hosts that replied to a sweep. It returns the hosts
+// seen for the first time, and those that have now missed expireAfter
+// sweeps, which are forgotten
+func (s *sweepHosts) update(alive []string) (found []string, expired []string) {
+	for ip := range s.missed {
+		if slices.Contains(alive, ip) {
+			continue
+		}
+		s.missed[ip]++
+		if s.missed[ip] >= s.expireAfter {
+			delete(s.missed, ip)
+			expired = append(expired, ip)
+		}
+	}
+	for _, ip := range alive {
+		if _, ok := s.missed[ip]; !ok {
+			found = append(found, ip)
+		}
+		s.missed[ip] = 0
+	}
+	slices.Sort(expired)
+	return found, expired
+}
+
+// runSweep pings every host in the subnet each interval, tracking which
+// hosts are up and promoting new ones to targets when enabled. Hosts that
+// stop replying for long enough are forgotten, and stop being pinged
+func (m *Manager) runSweep(s config.Sweep, metrics *config.Metrics) {
+	cidr := s.CIDR.String()
+	interval := time.Duration(m.opts.SweepInterval) * time.Second
+	opts := network.SweepOpts{Rate: s.Rate, Bind: s.Bind}
+	hosts := newSweepHosts(m.opts.SweepExpireAfter)
+	promoted := make([]autoTarget, 0)
+
+	size, _ := network.SubnetSize(s.CIDR).Float64()
+	metrics.SweepHosts.WithLabelValues(cidr).Set(size)
+
+	for {
+		start := time.Now()
+		alive, err := network.Sweep(s.CIDR, opts)
+		if err != nil {
+			slog.Error("Error sweeping subnet", "error", err, "cidr", cidr)
+			time.Sleep(interval)
+			continue
+		}
+		slog.Debug("Subnet swept", "cidr", cidr, "alive", len(alive), "duration", time.Since(start))
+
+		ips := make([]string, 0, len(alive))
+		for _, ip := range alive {
+			ips = append(ips, ip.String())
+		}
+		metrics.SweepHostsAlive.WithLabelValues(cidr).Set(float64(len(ips)))
+
+		found, expired := hosts.update(ips)
+		for ip, missed := range hosts.missed {
+			if missed > 0 {
+				metrics.SweepHostUp.WithLabelValues(cidr, ip).Set(0)
+			}
+		}
+		for _, ip := range ips {
+			metrics.SweepHostUp.WithLabelValues(cidr, ip).Set(1)
+		}
+		for _, ip := range found {
+			slog.Info("Host found by sweep", "ip", ip, "cidr", cidr)
+			if s.Promote {
+				promoted = append(promoted, autoTarget{ip: ip, role: roleSweep, bind: s.Bind})
+			}
+		}
+		for _, ip := range expired {
+			slog.Info("Host forgotten by sweep", "ip", ip, "cidr", cidr, "missed_sweeps", m.opts.SweepExpireAfter)
+			metrics.SweepHostUp.DeleteLabelValues(cidr, ip)
+			promoted = slices.DeleteFunc(promoted, func(t autoTarget) bool {
+				return t.ip == ip
+			})
+		}
+		if s.Promote {
+			m.setSource("sweep "+cidr, promoted)
+		}
+
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,39 @@
+package monitoring
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSweepHostsExpire(t *testing.T) {
+	hosts := newSweepHosts(2)
+
+	found, expired := hosts.update([]string{"10.0.0.1", "10.0.0.2"})
+	if !slices.Equal(found, []string{"10.0.0.1", "10.0.0.2"}) || len(expired) != 0 {
+		t.Errorf("Expected both hosts to be found, got %v and %v expired", found, expired)
+	}
+
+	// 10.0.0.2 misses one sweep, then replies again which resets the count
+	found, expired = hosts.update([]string{"10.0.0.1"})
+	if len(found) != 0 || len(expired) != 0 || hosts.missed["10.0.0.2"] != 1 {
+		t.Errorf("Expected a missed sweep to be counted, got %v found, %v expired and %v", found, expired, hosts.missed)
+	}
+	hosts.update([]string{"10.0.0.1", "10.0.0.2"})
+	if hosts.missed["10.0.0.2"] != 0 {
+		t.Errorf("Expected a reply to reset the missed sweeps, got %d", hosts.missed["10.0.0.2"])
+	}
+
+	hosts.update([]string{"10.0.0.1"})
+	found, expired = hosts.update([]string{"10.0.0.1", "10.0.0.3"})
+	if !slices.Equal(found, []string{"10.0.0.3"}) || !slices.Equal(expired, []string{"10.0.0.2"}) {
+		t.Errorf("Expected 10.0.0.3 found and 10.0.0.2 expired, got %v and %v", found, expired)
+	}
+	if _, ok := hosts.missed["10.0.0.2"]; ok {
+		t.Error("Expected the expired host to be forgotten")
+	}
+
+	// A forgotten host that comes back is found again
+	if found, _ = hosts.update([]string{"10.0.0.2"}); !slices.Equal(found, []string{"10.0.0.2"}) {
+		t.Errorf("Expected 10.0.0.2 to be found again, got %v", found)
+	}
+}
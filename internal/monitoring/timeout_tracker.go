@@ -16,15 +16,27 @@ func newTimeoutTracker(ips []string) *timeoutTracker {
 	}
 
 	for _, ip := range ips {
-		tt.replies.Set(ip, false)
-		tt.timeoutCount.Set(ip, 0)
+		tt.add(ip)
 	}
 
 	return &tt
 }
 
+func (tt *timeoutTracker) add(ip string) {
+	tt.replies.Set(ip, false)
+	tt.timeoutCount.Set(ip, 0)
+}
+
+func (tt *timeoutTracker) remove(ip string) {
+	tt.replies.Delete(ip)
+	tt.timeoutCount.Delete(ip)
+}
+
 func (tt *timeoutTracker) replyReceived(ip string) {
-	tt.replies.Set(ip, true)
+	// Late replies can arrive after an IP is removed
+	if _, ok := tt.replies.Lookup(ip); ok {
+		tt.replies.Set(ip, true)
+	}
 }
 
 type timeout struct {
@@ -32,17 +44,23 @@ type timeout struct {
 	count int
 }
 
-func (tt *timeoutTracker) countTimeouts() []timeout {
+// countTimeouts returns the IPs that haven't replied since it was last
+// called, with how many intervals in a row they haven't. With count false
+// the number in a row is left as it was
+func (tt *timeoutTracker) countTimeouts(count bool) []timeout {
 	timeouts := make([]timeout, 0)
 	replies := tt.replies.GetAll()
 	for ip, replied := range replies {
 		if !replied {
-			count := tt.timeoutCount.Get(ip) + 1
-			tt.timeoutCount.Set(ip, count)
+			n := tt.timeoutCount.Get(ip)
+			if count {
+				n++
+				tt.timeoutCount.Set(ip, n)
+			}
 
-			t := timeout{ip, count}
+			t := timeout{ip, n}
 			timeouts = append(timeouts, t)
-		} else {
+		} else if count {
 			tt.timeoutCount.Set(ip, 0)
 		}
 	}
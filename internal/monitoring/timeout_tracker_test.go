@@ -0,0 +1,27 @@
+package monitoring
+
+import (
+	"testing"
+)
+
+func TestCountTimeoutsWithoutCounting(t *testing.T) {
+	tt := newTimeoutTracker([]string{"1.1.1.1", "8.8.8.8"})
+	tt.countTimeouts(true)
+	tt.replyReceived("8.8.8.8")
+
+	// During the downtime timeouts are still reported, but not added up
+	timeouts := tt.countTimeouts(false)
+	if len(timeouts) != 1 || timeouts[0] != (timeout{"1.1.1.1", 1}) {
+		t.Errorf("Expected 1.1.1.1 to time out with a count of 1, got %v", timeouts)
+	}
+
+	timeouts = tt.countTimeouts(true)
+	if len(timeouts) != 2 {
+		t.Fatalf("Expected both IPs to time out, got %v", timeouts)
+	}
+	for _, to := range timeouts {
+		if want := map[string]int{"1.1.1.1": 2, "8.8.8.8": 2}[to.ip]; to.count != want {
+			t.Errorf("Expected %s to have timed out %d times in a row, got %d", to.ip, want, to.count)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"network_monitor/internal/network"
+	"network_monitor/internal/utils"
+	"strings"
+)
+
+type traceJob struct {
+	kind  string // Jobs of each kind for an IP and bind run one at a time
+	ip    string
+	iface string // Label of the bind the job runs over
+	run   func()
+}
+
+func (j traceJob) key() string {
+	return j.kind + " " + seriesKey(j.ip, j.iface)
+}
+
+// tracePool runs traceroutes on a fixed number of background workers so
+// that a slow trace never holds up the ping loop
+type tracePool struct {
+	jobs     chan traceJob
+	opts     network.TraceOpts
+	inFlight *utils.Tracker[bool]
+}
+
+func newTracePool(workers int, opts network.TraceOpts) *tracePool {
+	tp := tracePool{
+		jobs:     make(chan traceJob, workers*4),
+		opts:     opts,
+		inFlight: utils.NewTracker[bool](),
+	}
+
+	for range workers {
+		go tp.work()
+	}
+
+	return &tp
+}
+
+// submit queues a trace from bind, labelled iface, without blocking. It
+// returns false if a trace for the IP from bind is already queued or
+// running, or if the queue is full
+func (tp *tracePool) submit(ip string, bind network.Bind, iface string, onDone func(hops []network.Hop)) bool {
+	return tp.submitJob(traceJob{kind: "trace", ip: ip, iface: iface, run: func() {
+		if hops, ok := tp.runTrace(ip, bind); ok {
+			onDone(hops)
+		}
+	}})
+}
+
+func (tp *tracePool) submitJob(job traceJob) bool {
+	// Checked and set in one go, as handlers submit alongside the ping loops
+	key := job.key()
+	if !tp.inFlight.SetIfAbsent(key, true) {
+		slog.Debug("Trace already in flight", "kind", job.kind, "ip", job.ip, "interface", job.iface)
+		return false
+	}
+
+	select {
+	case tp.jobs <- job:
+		return true
+	default:
+		tp.inFlight.Delete(key)
+		slog.Warn("Trace queue full, skipping trace", "kind", job.kind, "ip", job.ip, "interface", job.iface)
+		return false
+	}
+}
+
+func (tp *tracePool) work() {
+	for job := range tp.jobs {
+		job.run()
+		tp.inFlight.Delete(job.key())
+	}
+}
+
+func (tp *tracePool) runTrace(ip string, bind network.Bind) ([]network.Hop, bool) {
+	ra, err := net.ResolveIPAddr("ip4:icmp", ip)
+	if err != nil {
+		slog.Error("Error resolving IP for traceroute", "error", err.Error(), "ip", ip)
+		return nil, false
+	}
+
+	opts := tp.opts
+	opts.Bind = bind
+	hops, err := network.Traceroute(ra, opts)
+	if err != nil {
+		slog.Error("Error from traceroute", "error", err.Error(), "ip", ip)
+		return nil, false
+	}
+
+	return hops, true
+}
+
+type traceResponse struct {
+	Hops   []network.Hop    `json:"hops"`
+	ASPath []network.ASInfo `json:"as_path"`
+}
+
+// HandleTrace writes the latest periodic trace for every target, or for the
+// one given by the ip query parameter. Traces over a specific interface are
+// keyed by the IP and interface, such as 8.8.8.8%wwan0
+func (m *Manager) HandleTrace(w http.ResponseWriter, r *http.Request) {
+	ip := r.URL.Query().Get("ip")
+	traces := make(map[string]traceResponse)
+	for target, hops := range m.traceTracker.GetAll() {
+		if ip == "" || ip == target || strings.HasPrefix(target, ip+"%") {
+			traces[target] = traceResponse{hops, network.ASPath(hops)}
+		}
+	}
+
+	if ip != "" && len(traces) == 0 {
+		http.Error(w, "No trace has run for ip", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(traces); err != nil {
+		slog.Error("Error writing traces", "error", err.Error())
+	}
+}
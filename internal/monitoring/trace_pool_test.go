@@ -0,0 +1,95 @@
+package monitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"network_monitor/internal/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newStoppedTracePool returns a pool without workers, so jobs stay queued
+func newStoppedTracePool(queue int) *tracePool {
+	return &tracePool{jobs: make(chan traceJob, queue), inFlight: utils.NewTracker[bool]()}
+}
+
+func TestTracePoolSubmit(t *testing.T) {
+	tp := newStoppedTracePool(2)
+	job := func(kind string, ip string) traceJob {
+		return traceJob{kind: kind, ip: ip, run: func() {}}
+	}
+
+	if !tp.submitJob(job("trace", "1.1.1.1")) {
+		t.Fatal("Expected the first job to be queued")
+	}
+	if tp.submitJob(job("trace", "1.1.1.1")) {
+		t.Error("Expected a second trace to the same IP to be refused")
+	}
+	if !tp.submitJob(job("multipath", "1.1.1.1")) {
+		t.Error("Expected a multipath discovery to run alongside a trace")
+	}
+	if tp.submitJob(job("trace", "8.8.8.8")) {
+		t.Error("Expected a job to be refused when the queue is full")
+	}
+	if tp.inFlight.Get(job("trace", "8.8.8.8").key()) {
+		t.Error("Expected a refused job not to be left in flight")
+	}
+
+	// Finishing a job lets the next one to the same IP in
+	(<-tp.jobs).run()
+	tp.inFlight.Delete(job("trace", "1.1.1.1").key())
+	if !tp.submitJob(job("trace", "1.1.1.1")) {
+		t.Error("Expected a trace to be queued once the last one finished")
+	}
+}
+
+func TestTracePoolSubmitConcurrently(t *testing.T) {
+	tp := newStoppedTracePool(10)
+	var wg sync.WaitGroup
+	var queued atomic.Int32
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tp.submitJob(traceJob{kind: "multipath", ip: "1.1.1.1", run: func() {}}) {
+				queued.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := queued.Load(); n != 1 || len(tp.jobs) != 1 {
+		t.Errorf("Expected one of the concurrent jobs to be queued, got %d", n)
+	}
+}
+
+func TestHandleMultipathQueueFull(t *testing.T) {
+	m := Manager{tracePool: newStoppedTracePool(0)}
+
+	res := httptest.NewRecorder()
+	m.HandleMultipath(res, httptest.NewRequest(http.MethodGet, "/multipath?ip=192.0.2.1", nil))
+	if res.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 with the queue full, got %d", res.Code)
+	}
+
+	for _, query := range []string{"", "?ip=192.0.2.1&flows=0", "?ip=192.0.2.1&flows=65"} {
+		res := httptest.NewRecorder()
+		m.HandleMultipath(res, httptest.NewRequest(http.MethodGet, "/multipath"+query, nil))
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", query, res.Code)
+		}
+	}
+}
+
+func TestHandleMultipathUnknownInterface(t *testing.T) {
+	m := Manager{tracePool: newStoppedTracePool(1), pingers: []*pinger{newTestPinger("eth0", nil)}}
+
+	res := httptest.NewRecorder()
+	m.HandleMultipath(res, httptest.NewRequest(http.MethodGet, "/multipath?ip=192.0.2.1&interface=wwan0", nil))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an interface nothing is pinged over, got %d", res.Code)
+	}
+	if len(m.tracePool.jobs) != 0 {
+		t.Error("Expected nothing to be queued")
+	}
+}
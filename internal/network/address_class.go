@@ -0,0 +1,55 @@
+package network
+
+import "net"
+
+// Address ranges that aren't reachable from the internet
+var (
+	RFC1918   = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16")
+	CGNAT     = mustParseCIDRs("100.64.0.0/10") // RFC 6598 shared address space
+	LinkLocal = mustParseCIDRs("169.254.0.0/16", "fe80::/10")
+	ULA       = mustParseCIDRs("fc00::/7") // RFC 4193 unique local addresses
+	Loopback  = mustParseCIDRs("127.0.0.0/8", "::1/128")
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func IsRFC1918(ip net.IP) bool {
+	return containsIP(RFC1918, ip)
+}
+
+func IsCGNAT(ip net.IP) bool {
+	return containsIP(CGNAT, ip)
+}
+
+func IsLinkLocal(ip net.IP) bool {
+	return containsIP(LinkLocal, ip)
+}
+
+func IsULA(ip net.IP) bool {
+	return containsIP(ULA, ip)
+}
+
+// IsPublic reports whether ip is a unicast address that can be routed on the
+// internet
+func IsPublic(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !IsRFC1918(ip) && !IsCGNAT(ip) && !IsULA(ip)
+}
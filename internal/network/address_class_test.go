@@ -0,0 +1,52 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAddressClass(t *testing.T) {
+	tests := []struct {
+		ip        string
+		rfc1918   bool
+		cgnat     bool
+		linkLocal bool
+		ula       bool
+		public    bool
+	}{
+		{"8.8.8.8", false, false, false, false, true},
+		{"21.4.1.89", false, false, false, false, true},
+		{"10.1.2.3", true, false, false, false, false},
+		{"172.16.0.1", true, false, false, false, false},
+		{"172.31.255.254", true, false, false, false, false},
+		{"172.32.0.1", false, false, false, false, true},
+		{"192.168.1.1", true, false, false, false, false},
+		{"100.64.0.1", false, true, false, false, false},
+		{"100.127.255.254", false, true, false, false, false},
+		{"100.128.0.1", false, false, false, false, true},
+		{"169.254.1.1", false, false, true, false, false},
+		{"127.0.0.1", false, false, false, false, false},
+		{"fe80::1", false, false, true, false, false},
+		{"fd00::1", false, false, false, true, false},
+		{"2001:db8::1", false, false, false, false, true},
+	}
+
+	for _, test := range tests {
+		ip := net.ParseIP(test.ip)
+		if got := IsRFC1918(ip); got != test.rfc1918 {
+			t.Errorf("IsRFC1918(%s) = %t, expected %t", test.ip, got, test.rfc1918)
+		}
+		if got := IsCGNAT(ip); got != test.cgnat {
+			t.Errorf("IsCGNAT(%s) = %t, expected %t", test.ip, got, test.cgnat)
+		}
+		if got := IsLinkLocal(ip); got != test.linkLocal {
+			t.Errorf("IsLinkLocal(%s) = %t, expected %t", test.ip, got, test.linkLocal)
+		}
+		if got := IsULA(ip); got != test.ula {
+			t.Errorf("IsULA(%s) = %t, expected %t", test.ip, got, test.ula)
+		}
+		if got := IsPublic(ip); got != test.public {
+			t.Errorf("IsPublic(%s) = %t, expected %t", test.ip, got, test.public)
+		}
+	}
+}
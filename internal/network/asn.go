@@ -0,0 +1,215 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ASInfo is the autonomous system an address belongs to
+type ASInfo struct {
+	Number  int    `json:"asn"`
+	Name    string `json:"name,omitempty"`
+	Country string `json:"country,omitempty"`
+}
+
+func (a ASInfo) String() string {
+	return fmt.Sprintf("AS%d", a.Number)
+}
+
+type ASNLookup interface {
+	Lookup(ip net.IP) (ASInfo, bool)
+}
+
+// OpenASNDatabase loads a MaxMind style MMDB file if the path ends in .mmdb,
+// otherwise an iptoasn.com TSV file, which can be gzipped
+func OpenASNDatabase(path string) (ASNLookup, error) {
+	if strings.HasSuffix(path, ".mmdb") {
+		db, err := maxminddb.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &mmdbASN{db}, nil
+	}
+
+	return openTSVASN(path)
+}
+
+type mmdbASN struct {
+	db *maxminddb.Reader
+}
+
+// Lookup understands both the GeoLite2-ASN fields and the ipinfo.io ones
+func (m *mmdbASN) Lookup(ip net.IP) (ASInfo, bool) {
+	var record map[string]any
+	if err := m.db.Lookup(ip, &record); err != nil || record == nil {
+		return ASInfo{}, false
+	}
+
+	var info ASInfo
+	switch asn := record["autonomous_system_number"].(type) {
+	case uint64:
+		info.Number = int(asn)
+	case uint32:
+		info.Number = int(asn)
+	}
+	if asn, ok := record["asn"].(string); ok {
+		info.Number, _ = strconv.Atoi(strings.TrimPrefix(asn, "AS"))
+	}
+	if name, ok := record["autonomous_system_organization"].(string); ok {
+		info.Name = name
+	}
+	if name, ok := record["as_name"].(string); ok {
+		info.Name = name
+	}
+	switch country := record["country"].(type) {
+	case string:
+		info.Country = country
+	case map[string]any:
+		info.Country, _ = country["iso_code"].(string)
+	}
+
+	return info, info.Number != 0
+}
+
+type asRange struct {
+	start net.IP
+	end   net.IP
+	info  ASInfo
+}
+
+// tsvASN holds the ranges from an iptoasn.com file, sorted by start address
+type tsvASN struct {
+	ranges []asRange
+}
+
+func openTSVASN(path string) (*tsvASN, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return parseTSVASN(r)
+}
+
+// parseTSVASN reads lines of range_start, range_end, AS_number,
+// country_code and AS_description separated by tabs
+func parseTSVASN(r io.Reader) (*tsvASN, error) {
+	db := tsvASN{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("Expected 5 fields on line %d of ASN TSV, got %d", line, len(fields))
+		}
+
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("Invalid IP range on line %d of ASN TSV", line)
+		}
+		asn, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid AS number on line %d of ASN TSV: %w", line, err)
+		}
+		// Ranges that aren't routed are listed with AS 0
+		if asn == 0 {
+			continue
+		}
+
+		db.ranges = append(db.ranges, asRange{
+			start: start.To16(),
+			end:   end.To16(),
+			info:  ASInfo{Number: asn, Country: fields[3], Name: fields[4]},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(db.ranges, func(a, b asRange) int {
+		return bytes.Compare(a.start, b.start)
+	})
+
+	return &db, nil
+}
+
+func (t *tsvASN) Lookup(ip net.IP) (ASInfo, bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return ASInfo{}, false
+	}
+
+	// Find the last range starting at or before the IP
+	i, found := slices.BinarySearchFunc(t.ranges, ip, func(r asRange, ip net.IP) int {
+		return bytes.Compare(r.start, ip)
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || bytes.Compare(ip, t.ranges[i].end) > 0 {
+		return ASInfo{}, false
+	}
+
+	return t.ranges[i].info, true
+}
+
+// addrIP returns the IP of a hop address, or nil for a silent hop
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func annotateAS(hops []Hop, db ASNLookup) {
+	if db == nil {
+		return
+	}
+	for i := range hops {
+		ip := addrIP(hops[i].IP)
+		if ip == nil {
+			continue
+		}
+		if info, ok := db.Lookup(ip); ok {
+			hops[i].AS = &info
+		}
+	}
+}
+
+// ASPath summarises a path as the autonomous systems it crosses, in order.
+// Hops with no known AS, such as private addresses, are skipped
+func ASPath(hops []Hop) []ASInfo {
+	path := make([]ASInfo, 0)
+	for _, hop := range hops {
+		if hop.AS == nil {
+			continue
+		}
+		if len(path) == 0 || path[len(path)-1].Number != hop.AS.Number {
+			path = append(path, *hop.AS)
+		}
+	}
+	return path
+}
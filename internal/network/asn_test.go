@@ -0,0 +1,54 @@
+package network
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const testTSV = "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+	"8.8.8.0\t8.8.8.255\t15169\tUS\tGOOGLE\n" +
+	"10.0.0.0\t10.255.255.255\t0\tNone\tNot routed\n" +
+	"81.2.69.0\t81.2.69.255\t20712\tGB\tANDREWS-ARNOLD\n"
+
+func TestTSVLookup(t *testing.T) {
+	db, err := parseTSVASN(strings.NewReader(testTSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, ok := db.Lookup(net.ParseIP("8.8.8.8"))
+	if !ok || info.Number != 15169 || info.Country != "US" || info.Name != "GOOGLE" {
+		t.Errorf("Unexpected lookup for 8.8.8.8: %+v %t", info, ok)
+	}
+
+	for _, ip := range []string{"8.8.9.1", "10.1.1.1", "0.0.0.1", "200.0.0.1"} {
+		if info, ok := db.Lookup(net.ParseIP(ip)); ok {
+			t.Errorf("Expected no AS for %s, got %+v", ip, info)
+		}
+	}
+}
+
+func TestTSVInvalidLine(t *testing.T) {
+	if _, err := parseTSVASN(strings.NewReader("1.0.0.0\t1.0.0.255\tAS13335\tUS\tCLOUDFLARENET\n")); err == nil {
+		t.Error("Expected an error for a non numeric AS number")
+	}
+}
+
+func TestASPath(t *testing.T) {
+	isp := &ASInfo{Number: 20712}
+	transit := &ASInfo{Number: 3356}
+	google := &ASInfo{Number: 15169}
+	hops := []Hop{{}, {AS: isp}, {AS: isp}, {}, {AS: transit}, {AS: google}, {AS: google}}
+
+	path := ASPath(hops)
+	want := []int{20712, 3356, 15169}
+	if len(path) != len(want) {
+		t.Fatalf("Expected path %v, got %v", want, path)
+	}
+	for i := range want {
+		if path[i].Number != want[i] {
+			t.Errorf("Expected path %v, got %v", want, path)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"network_monitor/internal/utils"
+)
+
+// Bind pins probes to a local interface and/or source address instead of
+// leaving the routing table to pick, so links such as a wired uplink and an
+// LTE backup can be probed side by side
+type Bind struct {
+	Interface string // Send from and only receive on this interface, Linux only
+	Source    net.IP // Source address of probes
+}
+
+// sourceLabels holds the label of each source address. It's worked out the
+// first time it's needed and then kept, so targets and series stay keyed the
+// same if the address moves to another interface
+var sourceLabels = utils.NewTracker[string]()
+
+// Label names the interface probes leave from, it's empty when the routing
+// table decides
+func (b Bind) Label() string {
+	if b.Interface != "" || b.Source == nil {
+		return b.Interface
+	}
+
+	key := b.Source.String()
+	if label, ok := sourceLabels.Lookup(key); ok {
+		return label
+	}
+	sourceLabels.SetIfAbsent(key, interfaceWithAddr(b.Source))
+	return sourceLabels.Get(key)
+}
+
+// interfaceWithAddr names the interface that has ip, or failing that
+// returns ip as a string
+func interfaceWithAddr(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err == nil {
+		for _, iface := range ifaces {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+					return iface.Name
+				}
+			}
+		}
+	}
+	return ip.String()
+}
+
+func (b Bind) sourceAddr() net.IP {
+	if b.Source != nil {
+		return b.Source
+	}
+	return net.IPv4zero
+}
+
+// listenIP opens a raw socket for the protocol in network, such as
+// "ip4:icmp", that sends and receives according to the bind
+func (b Bind) listenIP(network string) (*net.IPConn, error) {
+	conn, err := net.ListenIP(network, &net.IPAddr{IP: b.sourceAddr()})
+	if err != nil {
+		return nil, err
+	}
+	if b.Interface != "" {
+		if err := bindToDevice(conn, b.Interface); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Unable to bind to interface %s: %w", b.Interface, err)
+		}
+	}
+	return conn, nil
+}
+
+func (b Bind) listenUDP() (*net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: b.sourceAddr()})
+	if err != nil {
+		return nil, err
+	}
+	if b.Interface != "" {
+		if err := bindToDevice(conn, b.Interface); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Unable to bind to interface %s: %w", b.Interface, err)
+		}
+	}
+	return conn, nil
+}
+
+// sourceIP finds the local address probes to dst are sent from
+func (b Bind) sourceIP(dst net.IP) (net.IP, error) {
+	if b.Source != nil {
+		return b.Source, nil
+	}
+	if b.Interface != "" {
+		iface, err := net.InterfaceByName(b.Interface)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				return ipNet.IP, nil
+			}
+		}
+		return nil, fmt.Errorf("Interface %s has no IPv4 address", b.Interface)
+	}
+
+	// The routing table decides, connecting a UDP socket shows its choice
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: dst, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
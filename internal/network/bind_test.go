@@ -0,0 +1,27 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBindLabel(t *testing.T) {
+	if got := (Bind{Interface: "wwan0", Source: net.ParseIP("192.0.2.1")}).Label(); got != "wwan0" {
+		t.Errorf("Expected the interface as the label, got %q", got)
+	}
+	if got := (Bind{}).Label(); got != "" {
+		t.Errorf("Expected no label when the routing table decides, got %q", got)
+	}
+
+	// No interface has the address, so it's its own label
+	bind := Bind{Source: net.ParseIP("192.0.2.55")}
+	if got := bind.Label(); got != "192.0.2.55" {
+		t.Errorf("Expected the address as the label, got %q", got)
+	}
+
+	// Once worked out the label is kept, even if the address moves
+	sourceLabels.Set("192.0.2.56", "eth1")
+	if got := (Bind{Source: net.ParseIP("192.0.2.56")}).Label(); got != "eth1" {
+		t.Errorf("Expected the label to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,99 @@
+package network
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/net/icmp"
+)
+
+// Raw sockets see every echo reply to the host, so the echo identifier is
+// all that tells this process's replies from those of other pingers, and
+// each pinger in this process from the others
+var echoIDs = newIDAllocator(os.Getpid())
+
+// idAllocator hands out identifiers that are unique within the process,
+// starting from the PID as ping does so other instances likely differ too
+type idAllocator struct {
+	mu   sync.Mutex
+	next uint16
+	used map[uint16]bool
+}
+
+func newIDAllocator(pid int) *idAllocator {
+	return &idAllocator{
+		next: uint16(pid),
+		used: make(map[uint16]bool),
+	}
+}
+
+func (a *idAllocator) allocate() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// With every identifier in use one has to be shared
+	for range 0x10000 {
+		id := a.next
+		a.next++
+		if !a.used[id] {
+			a.used[id] = true
+			return int(id)
+		}
+	}
+	return int(a.next)
+}
+
+// reserve marks an identifier chosen elsewhere as used, it returns false if
+// it already is
+func (a *idAllocator) reserve(id int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.used[uint16(id)] {
+		return false
+	}
+	a.used[uint16(id)] = true
+	return true
+}
+
+func (a *idAllocator) release(id int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.used, uint16(id))
+}
+
+// echoID is an identifier held by one pinger until it's released
+type echoID struct {
+	id int
+	// Holds the identifier in the kernel, nil if not. Replies are also
+	// queued on it but it's never read, the kernel drops them once its
+	// buffer is full
+	conn net.PacketConn
+}
+
+// newEchoID picks an identifier no other pinger is using. Where the kernel
+// allows unprivileged ICMP sockets it picks one that's unique across the
+// host, as it does for their ports, otherwise the identifier is only unique
+// within the process
+func newEchoID() *echoID {
+	conn, err := icmp.ListenPacket("udp4", "0.0.0.0")
+	if err == nil {
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && echoIDs.reserve(addr.Port) {
+			return &echoID{id: addr.Port, conn: conn}
+		}
+		conn.Close()
+	} else {
+		slog.Debug("Unable to reserve echo identifier with the kernel", "error", err)
+	}
+
+	return &echoID{id: echoIDs.allocate()}
+}
+
+func (e *echoID) release() {
+	if e.conn != nil {
+		e.conn.Close()
+	}
+	echoIDs.release(e.id)
+}
@@ -0,0 +1,45 @@
+package network
+
+import "testing"
+
+func TestIDAllocatorUnique(t *testing.T) {
+	a := newIDAllocator(0xfffe)
+
+	// Allocation starts at the PID and wraps around
+	ids := []int{a.allocate(), a.allocate(), a.allocate()}
+	if ids[0] != 0xfffe || ids[1] != 0xffff || ids[2] != 0 {
+		t.Errorf("Expected identifiers to wrap from the PID, got %v", ids)
+	}
+
+	if a.reserve(0xffff) {
+		t.Error("Expected reserving an allocated identifier to fail")
+	}
+	a.release(0xffff)
+	if !a.reserve(0xffff) {
+		t.Error("Expected reserving a released identifier to succeed")
+	}
+	if id := a.allocate(); id != 1 {
+		t.Errorf("Expected 1 to be allocated next, got %d", id)
+	}
+}
+
+func TestIDAllocatorSkipsUsed(t *testing.T) {
+	a := newIDAllocator(10)
+	a.reserve(10)
+	a.reserve(11)
+
+	if id := a.allocate(); id != 12 {
+		t.Errorf("Expected reserved identifiers to be skipped, got %d", id)
+	}
+}
+
+func TestNewEchoIDUnique(t *testing.T) {
+	first := newEchoID()
+	defer first.release()
+	second := newEchoID()
+	defer second.release()
+
+	if first.id == second.id {
+		t.Errorf("Expected two pingers to get different identifiers, both got %d", first.id)
+	}
+}
@@ -0,0 +1,145 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Route flags from linux/route.h
+const (
+	rtfUp      = 0x1
+	rtfGateway = 0x2
+)
+
+// Gateway is the next hop of a default route
+type Gateway struct {
+	IP        net.IP
+	Interface string
+	metric    int
+}
+
+// DefaultGateways reads the next hops of the IPv4 and IPv6 default routes
+// from /proc/net, lowest metric first. It's only supported on Linux
+func DefaultGateways() ([]Gateway, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gateways, err := parseIPv4Routes(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// IPv6 may be disabled, which doesn't stop IPv4 gateways being used
+	f6, err := os.Open("/proc/net/ipv6_route")
+	if err == nil {
+		defer f6.Close()
+		gateways6, err := parseIPv6Routes(f6)
+		if err != nil {
+			return nil, err
+		}
+		gateways = append(gateways, gateways6...)
+	}
+
+	return gateways, nil
+}
+
+// parseIPv4Routes reads the default route gateways from the format of
+// /proc/net/route, where addresses are hex in host byte order
+func parseIPv4Routes(r io.Reader) ([]Gateway, error) {
+	gateways := make([]Gateway, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+
+		dst, err1 := strconv.ParseUint(fields[1], 16, 32)
+		gw, err2 := strconv.ParseUint(fields[2], 16, 32)
+		flags, err3 := strconv.ParseUint(fields[3], 16, 32)
+		metric, err4 := strconv.Atoi(fields[6])
+		mask, err5 := strconv.ParseUint(fields[7], 16, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+			return nil, fmt.Errorf("Unable to parse route %q", scanner.Text())
+		}
+		if dst != 0 || mask != 0 || flags&(rtfUp|rtfGateway) != rtfUp|rtfGateway {
+			continue
+		}
+
+		ip := make(net.IP, 4)
+		binary.NativeEndian.PutUint32(ip, uint32(gw))
+		gateways = append(gateways, Gateway{IP: ip, Interface: fields[0], metric: metric})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sortGateways(gateways)
+	return gateways, nil
+}
+
+// parseIPv6Routes reads the default route gateways from the format of
+// /proc/net/ipv6_route, where addresses are hex in network byte order
+func parseIPv6Routes(r io.Reader) ([]Gateway, error) {
+	gateways := make([]Gateway, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		dst, err1 := hex.DecodeString(fields[0])
+		gw, err2 := hex.DecodeString(fields[4])
+		metric, err3 := strconv.ParseUint(fields[5], 16, 32)
+		flags, err4 := strconv.ParseUint(fields[8], 16, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || len(gw) != net.IPv6len {
+			return nil, fmt.Errorf("Unable to parse route %q", scanner.Text())
+		}
+		if !net.IP(dst).IsUnspecified() || fields[1] != "00" || flags&(rtfUp|rtfGateway) != rtfUp|rtfGateway {
+			continue
+		}
+
+		gateways = append(gateways, Gateway{IP: net.IP(gw), Interface: fields[9], metric: int(metric)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sortGateways(gateways)
+	return gateways, nil
+}
+
+func sortGateways(gateways []Gateway) {
+	slices.SortStableFunc(gateways, func(a, b Gateway) int {
+		return a.metric - b.metric
+	})
+}
+
+// ISPEdge traces towards ip and returns the first hop with a public
+// address, which is the edge of the ISP's network for most home and office
+// connections. It returns nil if no hop within opts.MaxHops is public
+func ISPEdge(ip *net.IPAddr, opts TraceOpts) (*Hop, error) {
+	hops, err := Traceroute(ip, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hop := range hops {
+		if addr := addrIP(hop.IP); addr != nil && IsPublic(addr) {
+			return &hop, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,52 @@
+package network
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const testIPv4Routes = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+wwan0	00000000	0108A8C0	0003	0	0	700	00000000	0	0	0
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+wg0	0000000A	0100000A	0003	0	0	0	000000FF	0	0	0
+`
+
+const testIPv6Routes = `fd000000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+`
+
+func TestParseIPv4Routes(t *testing.T) {
+	gateways, err := parseIPv4Routes(strings.NewReader(testIPv4Routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sorted by metric, the route to 10/8 via wg0 isn't a default route
+	if len(gateways) != 2 {
+		t.Fatalf("Expected 2 gateways, got %v", gateways)
+	}
+	if !gateways[0].IP.Equal(net.ParseIP("192.168.1.1")) || gateways[0].Interface != "eth0" {
+		t.Errorf("Expected 192.168.1.1 on eth0 first, got %s on %s", gateways[0].IP, gateways[0].Interface)
+	}
+	if !gateways[1].IP.Equal(net.ParseIP("192.168.8.1")) || gateways[1].Interface != "wwan0" {
+		t.Errorf("Expected 192.168.8.1 on wwan0 second, got %s on %s", gateways[1].IP, gateways[1].Interface)
+	}
+}
+
+func TestParseIPv6Routes(t *testing.T) {
+	gateways, err := parseIPv6Routes(strings.NewReader(testIPv6Routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The unreachable default route on lo has no gateway
+	if len(gateways) != 1 {
+		t.Fatalf("Expected 1 gateway, got %v", gateways)
+	}
+	if !gateways[0].IP.Equal(net.ParseIP("fe80::1")) || gateways[0].Interface != "eth0" {
+		t.Errorf("Expected fe80::1 on eth0, got %s on %s", gateways[0].IP, gateways[0].Interface)
+	}
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// MPLSLabel is an entry from the label stack a router quoted (RFC 4950)
+type MPLSLabel struct {
+	Label int  `json:"label"`
+	TC    int  `json:"tc"`
+	S     bool `json:"s"` // Bottom of stack
+	TTL   int  `json:"ttl"`
+}
+
+// InterfaceInfo is an interface a router identified in its reply (RFC 5837)
+type InterfaceInfo struct {
+	Role  string `json:"role"`
+	Index int    `json:"index,omitempty"`
+	Name  string `json:"name,omitempty"`
+	IP    net.IP `json:"ip,omitempty"`
+	MTU   int    `json:"mtu,omitempty"`
+}
+
+// Interface roles from the top two bits of the C-Type in RFC 5837
+var interfaceRoles = []string{"incoming", "sub-ip", "outgoing", "next-hop"}
+
+// icmpExtensions holds what we can learn from an ICMP error beyond who sent it
+type icmpExtensions struct {
+	mpls       []MPLSLabel
+	interfaces []InterfaceInfo
+	quotedTTL  int
+}
+
+func parseICMPExtensions(msg *icmp.Message) icmpExtensions {
+	var data []byte
+	var exts []icmp.Extension
+	switch body := msg.Body.(type) {
+	case *icmp.TimeExceeded:
+		data, exts = body.Data, body.Extensions
+	case *icmp.DstUnreach:
+		data, exts = body.Data, body.Extensions
+	}
+
+	var e icmpExtensions
+	if h, err := ipv4.ParseHeader(data); err == nil {
+		e.quotedTTL = h.TTL
+	}
+
+	for _, ext := range exts {
+		switch ext := ext.(type) {
+		case *icmp.MPLSLabelStack:
+			for _, l := range ext.Labels {
+				e.mpls = append(e.mpls, MPLSLabel(l))
+			}
+		case *icmp.InterfaceInfo:
+			ifi := InterfaceInfo{Role: interfaceRoles[(ext.Type>>6)&0x3]}
+			if ext.Interface != nil {
+				ifi.Index = ext.Interface.Index
+				ifi.Name = ext.Interface.Name
+				ifi.MTU = ext.Interface.MTU
+			}
+			if ext.Addr != nil {
+				ifi.IP = ext.Addr.IP
+			}
+			e.interfaces = append(e.interfaces, ifi)
+		}
+	}
+
+	return e
+}
@@ -0,0 +1,76 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func timeExceededWith(t *testing.T, exts []icmp.Extension) *icmp.Message {
+	quoted, err := (&ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: ipv4.HeaderLen + 8,
+		TTL:      3,
+		Protocol: 1,
+		Dst:      net.IPv4(8, 8, 8, 8),
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(quoted, make([]byte, 8)...)
+
+	b, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeTimeExceeded,
+		Body: &icmp.TimeExceeded{Data: data, Extensions: exts},
+	}).Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := icmp.ParseMessage(1, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestParseMPLSExtension(t *testing.T) {
+	msg := timeExceededWith(t, []icmp.Extension{
+		&icmp.MPLSLabelStack{
+			Class:  1,
+			Type:   1,
+			Labels: []icmp.MPLSLabel{{Label: 16001, TC: 0, S: true, TTL: 1}},
+		},
+	})
+
+	e := parseICMPExtensions(msg)
+	if len(e.mpls) != 1 || e.mpls[0].Label != 16001 || !e.mpls[0].S {
+		t.Errorf("Expected a single bottom of stack label 16001, got %+v", e.mpls)
+	}
+	if e.quotedTTL != 3 {
+		t.Errorf("Expected quoted TTL of 3, got %d", e.quotedTTL)
+	}
+}
+
+func TestParseInterfaceExtension(t *testing.T) {
+	msg := timeExceededWith(t, []icmp.Extension{
+		&icmp.InterfaceInfo{
+			Class:     2,
+			Type:      0x0f, // Incoming interface with index, name, address and MTU
+			Interface: &net.Interface{Index: 15, Name: "ge-0/0/1", MTU: 1500},
+			Addr:      &net.IPAddr{IP: net.IPv4(192, 0, 2, 1).To4()},
+		},
+	})
+
+	e := parseICMPExtensions(msg)
+	if len(e.interfaces) != 1 {
+		t.Fatalf("Expected 1 interface, got %d", len(e.interfaces))
+	}
+	ifi := e.interfaces[0]
+	if ifi.Role != "incoming" || ifi.Index != 15 || ifi.Name != "ge-0/0/1" || ifi.MTU != 1500 || !ifi.IP.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("Unexpected interface info: %+v", ifi)
+	}
+}
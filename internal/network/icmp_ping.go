@@ -1,10 +1,14 @@
 package network
 
 import (
+	"encoding/binary"
 	"errors"
 	"log/slog"
 	"net"
 	"network_monitor/internal/utils"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/icmp"
@@ -12,61 +16,172 @@ import (
 )
 
 type iCMPPing struct {
-	conn *icmp.PacketConn
+	// Held from setting the options of a request until it's sent, as they're
+	// set on the socket all pings share
+	sendMu      sync.Mutex
+	conn        *net.IPConn
+	pc          *ipv4.PacketConn
+	df          bool
+	tos         int
+	txTimestamp bool          // The kernel reports when packets are sent
+	sends       atomic.Uint32 // Packets sent, which the kernel tags send timestamps with
+	pending     pendingSends
 }
 
 type ICMPPingOpts struct {
-	id  int
-	IP  *net.IPAddr
-	TTL int
-	Seq int
+	id   int
+	IP   *net.IPAddr
+	TTL  int
+	Seq  int
+	Data []byte // Sent instead of the current time when set
+	DF   bool   // Set the don't fragment bit, otherwise the kernel decides
+	TOS  int
+	// Called with the time the kernel sent the request, when it reports it.
+	// It's read off the socket with the replies, before the reply to this
+	// request is returned
+	OnSent func(time.Time)
 }
 
 type ICMPPingResponse struct {
-	Message *icmp.Message
-	Peer    net.Addr
+	Message    *icmp.Message
+	Peer       net.Addr
+	Received   time.Time
+	NextHopMTU int  // Set on fragmentation needed messages (RFC 1191)
+	TTL        int  // IP TTL of the reply, 0 when the platform doesn't report it
+	Kernel     bool // Received is the time the kernel received the message
 }
 
 func NewICMPPing() (*iCMPPing, error) {
-	c, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	return NewICMPPingOn(Bind{})
+}
+
+// NewICMPPingOn is NewICMPPing with pings sent from, and replies only
+// received on, the bound interface or address
+func NewICMPPingOn(bind Bind) (*iCMPPing, error) {
+	c, err := bind.listenIP("ip4:icmp")
 	if err != nil {
 		return nil, err
 	}
 
+	pc := ipv4.NewPacketConn(c)
+	// The TTL of replies is only needed for metrics so pinging still works
+	// on platforms without it
+	if err := pc.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+		slog.Debug("Unable to receive reply TTLs", "error", err)
+	}
+
+	// Without kernel timestamps the time is taken after the message has been
+	// read, which adds any scheduling delay to the RTT
+	txTimestamp, err := enableTimestamps(c)
+	if err != nil {
+		slog.Debug("Unable to enable kernel timestamps", "error", err)
+	}
+
 	return &iCMPPing{
-		conn: c,
+		conn:        c,
+		pc:          pc,
+		txTimestamp: txTimestamp,
+		pending:     pendingSends{callbacks: make(map[uint32]func(time.Time))},
 	}, nil
 }
 
-func (p *iCMPPing) Ping(opts ICMPPingOpts) error {
+// Ping sends an echo request and returns the time just before it was sent,
+// the time the kernel sent it is passed to opts.OnSent
+func (p *iCMPPing) Ping(opts ICMPPingOpts) (time.Time, error) {
 	if err := checkOpts(&opts); err != nil {
-		return err
+		return time.Time{}, err
 	}
 
-	pc := p.conn.IPv4PacketConn()
-	if err := pc.SetTTL(opts.TTL); err != nil {
-		return err
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	if err := p.pc.SetTTL(opts.TTL); err != nil {
+		return time.Time{}, err
+	}
+	// DF and TOS are only changed when needed as most pings use the defaults
+	if opts.DF != p.df {
+		// With DF set the path MTU the kernel has cached is ignored, so
+		// larger packets can still be tried
+		if err := setDontFragment(p.conn, opts.DF); err != nil {
+			return time.Time{}, err
+		}
+		p.df = opts.DF
+	}
+	if opts.TOS != p.tos {
+		if err := p.pc.SetTOS(opts.TOS); err != nil {
+			return time.Time{}, err
+		}
+		p.tos = opts.TOS
 	}
 
-	now := time.Now()
+	sent := time.Now()
+	data := opts.Data
+	if data == nil {
+		data = utils.TimeToBinary(sent)
+	}
 	m := icmp.Message{
 		Type: ipv4.ICMPTypeEcho,
 		Body: &icmp.Echo{
 			ID:   opts.id,
 			Seq:  opts.Seq,
-			Data: utils.TimeToBinary(now),
+			Data: data,
 		},
 	}
 
 	mb, err := m.Marshal(nil)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 
+	// The kernel tags send timestamps with the number of packets sent before,
+	// the callback is added first as the timestamp can be read straight away
+	id := p.sends.Load()
+	if p.txTimestamp && opts.OnSent != nil {
+		p.pending.add(id, opts.OnSent)
+	}
 	if _, err := p.conn.WriteTo(mb, opts.IP); err != nil {
-		return err
+		p.pending.remove(id)
+		return time.Time{}, err
+	}
+	p.sends.Add(1)
+	return sent, nil
+}
+
+// pendingSends holds the callbacks waiting for send timestamps, by the
+// OPT_ID counter the kernel tags them with
+type pendingSends struct {
+	mu        sync.Mutex
+	callbacks map[uint32]func(time.Time)
+}
+
+func (s *pendingSends) add(id uint32, f func(time.Time)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.callbacks[id] = f
+}
+
+func (s *pendingSends) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.callbacks, id)
+}
+
+// done passes sent to the callback for id. Timestamps arrive in the order
+// packets were sent, so callbacks for earlier packets are dropped as theirs
+// won't come
+func (s *pendingSends) done(id uint32, sent time.Time) {
+	s.mu.Lock()
+	f := s.callbacks[id]
+	for other := range s.callbacks {
+		// Compared as a difference so the counter can wrap
+		if int32(other-id) <= 0 {
+			delete(s.callbacks, other)
+		}
+	}
+	s.mu.Unlock()
+
+	if f != nil {
+		f(sent)
 	}
-	return nil
 }
 
 func (p *iCMPPing) Read(readDeadlineDuration time.Duration) (chan ICMPPingResponse, error) {
@@ -76,24 +191,36 @@ func (p *iCMPPing) Read(readDeadlineDuration time.Duration) (chan ICMPPingRespon
 		return nil, err
 	}
 
-	buf := make([]byte, 1500)
-
 	go func() {
+		buf := make([]byte, 0xffff)
+		oob := make([]byte, 128)
 		for {
-			n, peer, err := p.conn.ReadFrom(buf)
+			n, peer, ttl, kernelReceived, err := p.readMessage(buf, oob)
 			if err != nil {
 				break
 			}
+			received := time.Now()
 
-			msg, err := icmp.ParseMessage(1, buf[:n])
+			// Parsed messages reference the bytes so they can't share buf
+			b := slices.Clone(buf[:n])
+			msg, err := icmp.ParseMessage(1, b)
 			if err != nil {
 				slog.Warn("Unable to parse icmp message")
 				continue
 			}
 
 			res := ICMPPingResponse{
-				Message: msg,
-				Peer:    peer,
+				Message:  msg,
+				Peer:     peer,
+				Received: received,
+				TTL:      ttl,
+			}
+			if !kernelReceived.IsZero() {
+				res.Received = kernelReceived
+				res.Kernel = true
+			}
+			if msg.Type == ipv4.ICMPTypeDestinationUnreachable && msg.Code == 4 && len(b) >= 8 {
+				res.NextHopMTU = int(binary.BigEndian.Uint16(b[6:8]))
 			}
 
 			rtn <- res
@@ -0,0 +1,270 @@
+package network
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+type MTROpts struct {
+	Interval time.Duration // Time between rounds
+	Window   int           // Number of rounds the statistics cover
+	Trace    TraceOpts
+}
+
+// HopStats summarises the probes sent to one hop over the MTR window. When
+// load balancing sends them to several addresses, each has its own stats
+type HopStats struct {
+	TTL      int
+	IP       net.Addr
+	Sent     int
+	Received int // Replies from IP
+	Lost     int // Rounds in which no address at the hop replied
+	Last     time.Duration
+	Avg      time.Duration
+	Best     time.Duration
+	Worst    time.Duration
+	StdDev   time.Duration
+}
+
+// Loss is the fraction of rounds in which the hop didn't reply, replies
+// from the other addresses at the hop aren't lost
+func (s HopStats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(s.Sent)
+}
+
+const (
+	// lost marks a round in which the hop didn't reply
+	lost time.Duration = -1
+	// elsewhere marks a round in which another address at the hop replied
+	elsewhere time.Duration = -2
+)
+
+type mtrResult struct {
+	ip  net.Addr // nil when lost
+	rtt time.Duration
+}
+
+type mtrHop struct {
+	results []mtrResult // Oldest first, at most Window long
+}
+
+// MTR repeatedly traces the path to an IP with one probe per hop and keeps
+// per-hop statistics over the most recent rounds, the same way mtr does
+type MTR struct {
+	ip     *net.IPAddr
+	opts   MTROpts
+	mu     sync.Mutex
+	hops   []*mtrHop
+	rounds int
+}
+
+func NewMTR(ip *net.IPAddr, opts MTROpts) *MTR {
+	if opts.Interval == 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.Window == 0 {
+		opts.Window = 10
+	}
+	opts.Trace.Probes = 1
+
+	return &MTR{
+		ip:   ip,
+		opts: opts,
+	}
+}
+
+// Run probes the path every interval and calls onRound with the updated
+// statistics, it blocks forever
+func (m *MTR) Run(onRound func([]HopStats)) {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		hops, err := Traceroute(m.ip, m.opts.Trace)
+		if err != nil {
+			slog.Error("Error from MTR round", "error", err.Error(), "ip", m.ip)
+		} else {
+			m.record(hops)
+			onRound(m.Stats())
+		}
+		<-ticker.C
+	}
+}
+
+func (m *MTR) record(hops []Hop) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rounds++
+
+	// The path got shorter, drop the hops beyond the destination
+	if len(hops) > 0 && hops[len(hops)-1].IP != nil && len(hops) < len(m.hops) {
+		m.hops = m.hops[:len(hops)]
+	}
+	for len(m.hops) < len(hops) {
+		m.hops = append(m.hops, &mtrHop{})
+	}
+
+	for i, mh := range m.hops {
+		result := mtrResult{rtt: lost}
+		if i < len(hops) && hops[i].Received > 0 {
+			result = mtrResult{ip: hops[i].IP, rtt: hops[i].RTTs[0]}
+		}
+
+		mh.results = append(mh.results, result)
+		if len(mh.results) > m.opts.Window {
+			mh.results = mh.results[1:]
+		}
+	}
+}
+
+// Stats returns the statistics for each address that replied at each hop on
+// the path, in TTL order. Each round has one probe per hop, so with load
+// balancing the replies come from different addresses, and keeping their
+// stats apart stops the round trip times of separate routers being mixed
+func (m *MTR) Stats() []HopStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make([]HopStats, 0, len(m.hops))
+	for i, mh := range m.hops {
+		for _, ip := range mh.addrs() {
+			stats = append(stats, newHopStats(i+1, ip, mh.resultsFrom(ip)))
+		}
+	}
+	return stats
+}
+
+// addrs returns the addresses that replied in the window in the order they
+// first did, or just nil if none did
+func (mh *mtrHop) addrs() []net.Addr {
+	var addrs []net.Addr
+	for _, r := range mh.results {
+		if r.ip != nil && !slices.ContainsFunc(addrs, func(ip net.Addr) bool { return ip.String() == r.ip.String() }) {
+			addrs = append(addrs, r.ip)
+		}
+	}
+	if len(addrs) == 0 {
+		return []net.Addr{nil}
+	}
+	return addrs
+}
+
+// resultsFrom returns the round trip time of each round as seen from ip
+func (mh *mtrHop) resultsFrom(ip net.Addr) []time.Duration {
+	results := make([]time.Duration, len(mh.results))
+	for i, r := range mh.results {
+		switch {
+		case r.ip == nil:
+			results[i] = lost
+		case r.ip.String() == ip.String():
+			results[i] = r.rtt
+		default:
+			results[i] = elsewhere
+		}
+	}
+	return results
+}
+
+func newHopStats(ttl int, ip net.Addr, results []time.Duration) HopStats {
+	s := HopStats{
+		TTL:  ttl,
+		IP:   ip,
+		Sent: len(results),
+	}
+
+	var sum, sumSq float64
+	for _, rtt := range results {
+		if rtt == lost {
+			s.Lost++
+			continue
+		}
+		if rtt == elsewhere {
+			continue
+		}
+		if s.Received == 0 || rtt < s.Best {
+			s.Best = rtt
+		}
+		if rtt > s.Worst {
+			s.Worst = rtt
+		}
+		s.Last = rtt
+		s.Received++
+		sum += float64(rtt)
+		sumSq += float64(rtt) * float64(rtt)
+	}
+
+	if s.Received > 0 {
+		mean := sum / float64(s.Received)
+		s.Avg = time.Duration(mean)
+		s.StdDev = time.Duration(math.Sqrt(math.Max(0, sumSq/float64(s.Received)-mean*mean)))
+	}
+
+	return s
+}
+
+// MTRReport mirrors the output of `mtr --report --json`
+type MTRReport struct {
+	Report struct {
+		MTR struct {
+			Src   string `json:"src"`
+			Dst   string `json:"dst"`
+			Tests int    `json:"tests"`
+		} `json:"mtr"`
+		Hubs []MTRHub `json:"hubs"`
+	} `json:"report"`
+}
+
+type MTRHub struct {
+	Count int     `json:"count"`
+	Host  string  `json:"host"`
+	Loss  float64 `json:"Loss%"`
+	Snt   int     `json:"Snt"`
+	Last  float64 `json:"Last"`
+	Avg   float64 `json:"Avg"`
+	Best  float64 `json:"Best"`
+	Wrst  float64 `json:"Wrst"`
+	StDev float64 `json:"StDev"`
+}
+
+func (m *MTR) Report(src string) MTRReport {
+	var r MTRReport
+	r.Report.MTR.Src = src
+	r.Report.MTR.Dst = m.ip.String()
+
+	m.mu.Lock()
+	r.Report.MTR.Tests = min(m.rounds, m.opts.Window)
+	m.mu.Unlock()
+
+	r.Report.Hubs = make([]MTRHub, 0)
+	for _, s := range m.Stats() {
+		host := "???"
+		if s.IP != nil {
+			host = s.IP.String()
+		}
+		r.Report.Hubs = append(r.Report.Hubs, MTRHub{
+			Count: s.TTL,
+			Host:  host,
+			Loss:  math.Round(s.Loss()*1000) / 10,
+			Snt:   s.Sent,
+			Last:  toMillis(s.Last),
+			Avg:   toMillis(s.Avg),
+			Best:  toMillis(s.Best),
+			Wrst:  toMillis(s.Worst),
+			StDev: toMillis(s.StdDev),
+		})
+	}
+
+	return r
+}
+
+func toMillis(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
@@ -0,0 +1,96 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHopStats(t *testing.T) {
+	results := []time.Duration{10 * time.Millisecond, lost, 30 * time.Millisecond, 20 * time.Millisecond}
+	s := newHopStats(1, nil, results)
+
+	if s.Sent != 4 || s.Received != 3 {
+		t.Errorf("Expected 4 sent and 3 received, got %d and %d", s.Sent, s.Received)
+	}
+	if s.Loss() != 0.25 {
+		t.Errorf("Expected loss of 0.25, got %v", s.Loss())
+	}
+	if s.Last != 20*time.Millisecond {
+		t.Errorf("Expected last of 20ms, got %v", s.Last)
+	}
+	if s.Best != 10*time.Millisecond || s.Worst != 30*time.Millisecond {
+		t.Errorf("Expected best 10ms and worst 30ms, got %v and %v", s.Best, s.Worst)
+	}
+	if s.Avg != 20*time.Millisecond {
+		t.Errorf("Expected avg of 20ms, got %v", s.Avg)
+	}
+	// Population standard deviation of 10, 20 and 30 is ~8.165ms
+	if s.StdDev < 8160*time.Microsecond || s.StdDev > 8170*time.Microsecond {
+		t.Errorf("Expected stddev of ~8.165ms, got %v", s.StdDev)
+	}
+}
+
+func TestHopStatsAllLost(t *testing.T) {
+	s := newHopStats(1, nil, []time.Duration{lost, lost})
+	if s.Loss() != 1 {
+		t.Errorf("Expected loss of 1, got %v", s.Loss())
+	}
+	if s.Avg != 0 || s.StdDev != 0 {
+		t.Errorf("Expected zero avg and stddev, got %v and %v", s.Avg, s.StdDev)
+	}
+}
+
+func TestMTRWindow(t *testing.T) {
+	mtr := NewMTR(nil, MTROpts{Window: 2})
+	hop := func(rtt time.Duration) []Hop {
+		return []Hop{{TTL: 1, IP: &net.IPAddr{IP: net.IPv4(10, 0, 0, 1)}, Sent: 1, Received: 1, RTTs: []time.Duration{rtt}}}
+	}
+
+	mtr.record(hop(10 * time.Millisecond))
+	mtr.record(hop(20 * time.Millisecond))
+	mtr.record(hop(30 * time.Millisecond))
+
+	stats := mtr.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("Expected 1 hop, got %d", len(stats))
+	}
+	if stats[0].Sent != 2 || stats[0].Best != 20*time.Millisecond {
+		t.Errorf("Expected window to hold the last 2 rounds, got %+v", stats[0])
+	}
+}
+
+func TestMTRLoadBalancedHop(t *testing.T) {
+	mtr := NewMTR(nil, MTROpts{Window: 4})
+	hop := func(ip string, rtt time.Duration) []Hop {
+		if ip == "" {
+			return []Hop{{TTL: 1, Sent: 1}}
+		}
+		return []Hop{{TTL: 1, IP: &net.IPAddr{IP: net.ParseIP(ip)}, Sent: 1, Received: 1, RTTs: []time.Duration{rtt}}}
+	}
+
+	// Each round's probe is balanced to one of two routers
+	mtr.record(hop("10.0.0.1", 10*time.Millisecond))
+	mtr.record(hop("10.0.0.2", 30*time.Millisecond))
+	mtr.record(hop("10.0.0.1", 20*time.Millisecond))
+	mtr.record(hop("", 0))
+
+	stats := mtr.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("Expected an entry for each address at the hop, got %+v", stats)
+	}
+	a, b := stats[0], stats[1]
+	if a.TTL != 1 || a.IP.String() != "10.0.0.1" || b.TTL != 1 || b.IP.String() != "10.0.0.2" {
+		t.Fatalf("Expected both addresses at TTL 1, got %+v", stats)
+	}
+	if a.Received != 2 || a.Avg != 15*time.Millisecond || a.Worst != 20*time.Millisecond {
+		t.Errorf("Expected 2 replies averaging 15ms from 10.0.0.1, got %+v", a)
+	}
+	if b.Received != 1 || b.Avg != 30*time.Millisecond || b.Best != 30*time.Millisecond {
+		t.Errorf("Expected 1 reply of 30ms from 10.0.0.2, got %+v", b)
+	}
+	// Only the round neither replied in is lost
+	if a.Loss() != 0.25 || b.Loss() != 0.25 {
+		t.Errorf("Expected the hop's loss of 0.25 for both, got %v and %v", a.Loss(), b.Loss())
+	}
+}
@@ -0,0 +1,98 @@
+package network
+
+import (
+	"maps"
+	"net"
+	"slices"
+	"time"
+)
+
+// MultipathHop is every next hop seen at one TTL across all of the flows
+type MultipathHop struct {
+	TTL      int       `json:"ttl"`
+	NextHops []NextHop `json:"next_hops"`
+}
+
+type NextHop struct {
+	IP    net.Addr        `json:"ip"`
+	AS    *ASInfo         `json:"as,omitempty"`
+	Flows []int           `json:"flows"` // The flows that were routed through this hop
+	RTTs  []time.Duration `json:"rtts"`
+}
+
+// Multipath discovers equal cost multipath branches by tracing the path with
+// a number of different flows in Paris mode. Each flow follows one branch, so
+// a TTL with several next hops is where a load balancer splits the traffic
+func Multipath(ip *net.IPAddr, opts TraceOpts, flows int) ([]MultipathHop, error) {
+	checkTraceOpts(&opts)
+	opts.Paris = true
+	opts.Probes = 1
+
+	type flowReply struct {
+		ttl  int
+		flow int
+		rtt  time.Duration
+		peer net.Addr
+	}
+	replies := make([]flowReply, 0)
+	// The TTL each flow reached the destination or was rejected at, branches
+	// can differ in length
+	flowDest := make(map[int]int)
+
+	err := probeAll(ip, opts, flows, func(ttl, flow int, rtt time.Duration, res traceReply) bool {
+		replies = append(replies, flowReply{ttl, flow, rtt, res.peer})
+		if (res.final || res.unreachable != "") && (flowDest[flow] == 0 || ttl < flowDest[flow]) {
+			flowDest[flow] = ttl
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hops := make([]MultipathHop, opts.MaxHops)
+	for i := range hops {
+		hops[i].TTL = i + 1
+	}
+	if len(flowDest) > 0 {
+		hops = hops[:slices.Max(slices.Collect(maps.Values(flowDest)))]
+	}
+
+	for _, r := range replies {
+		// Past its end a flow only gets more replies from the same host
+		if r.ttl > len(hops) || (flowDest[r.flow] > 0 && r.ttl > flowDest[r.flow]) {
+			continue
+		}
+
+		hop := &hops[r.ttl-1]
+		i := slices.IndexFunc(hop.NextHops, func(nh NextHop) bool {
+			return nh.IP.String() == r.peer.String()
+		})
+		if i == -1 {
+			nh := NextHop{IP: r.peer}
+			if opts.ASN != nil {
+				if info, ok := opts.ASN.Lookup(addrIP(r.peer)); ok {
+					nh.AS = &info
+				}
+			}
+			hop.NextHops = append(hop.NextHops, nh)
+			i = len(hop.NextHops) - 1
+		}
+		hop.NextHops[i].Flows = append(hop.NextHops[i].Flows, r.flow)
+		hop.NextHops[i].RTTs = append(hop.NextHops[i].RTTs, r.rtt)
+	}
+
+	silent := 0
+	for i, hop := range hops {
+		if len(hop.NextHops) > 0 {
+			silent = 0
+			continue
+		}
+		silent++
+		if silent == opts.MaxSilentHops {
+			return hops[:i+1-silent], nil
+		}
+	}
+
+	return hops, nil
+}
@@ -2,12 +2,11 @@ package network
 
 import (
 	"errors"
-	"fmt"
 	"log/slog"
-	"math/rand"
 	"net"
-	"network_monitor/internal/utils"
 	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/icmp"
@@ -15,24 +14,58 @@ import (
 )
 
 type PingLoopResponse struct {
-	Body     *icmp.Echo
-	Peer     net.Addr
-	Duration time.Duration
+	Body      *icmp.Echo
+	Peer      net.Addr
+	Duration  time.Duration
+	Corrupted bool // The payload wasn't echoed back as it was sent
+	// The wall clock moved differently to the monotonic clock while the
+	// request was in flight, such as when NTP steps it
+	ClockAnomaly bool
+	TTL          int // IP TTL of the reply, 0 when unknown
+	Class        ReplyClass
+	// Duration is measured with kernel timestamps when Kernel is set,
+	// UserspaceDuration is the RTT seen by the ping loop for comparison
+	Kernel            bool
+	UserspaceDuration time.Duration
+}
+
+type pingTarget struct {
+	ip    *net.IPAddr
+	probe ProbeOpts
+	seq   atomic.Uint32 // Sequence of the latest request, each target counts separately
+}
+
+// nextSeq returns the sequence for the next request, wrapping after 65535.
+// Sends from consecutive intervals can overlap when there are many targets
+func (t *pingTarget) nextSeq() uint16 {
+	return uint16(t.seq.Add(1))
 }
 
 type PingLoop struct {
 	interval        time.Duration
-	pingIps         []*net.IPAddr
+	mu              sync.Mutex
+	targets         []*pingTarget
 	OnResponse      func(*PingLoopResponse)
 	OnIntervalStart func()
-	OnIntervalEnd   func(ospid int, seq int)
+	OnIntervalEnd   func(id int, interval int)
 	resChan         chan PingLoopResponse
 	icmpPing        *iCMPPing
-	ospid           int
+	id              *echoID
+	sent            *sentProbes
 }
 
+// sentIntervals is how many intervals a reply can be late by and still be
+// matched to its request
+const sentIntervals = 8
+
 func NewPingLoop(interval int) (*PingLoop, error) {
-	icmpPing, err := NewICMPPing()
+	return NewPingLoopOn(interval, Bind{})
+}
+
+// NewPingLoopOn is NewPingLoop with every target pinged from the bound
+// interface or address
+func NewPingLoopOn(interval int, bind Bind) (*PingLoop, error) {
+	icmpPing, err := NewICMPPingOn(bind)
 	if err != nil {
 		return nil, err
 	}
@@ -40,22 +73,50 @@ func NewPingLoop(interval int) (*PingLoop, error) {
 	p := PingLoop{
 		icmpPing: icmpPing,
 		interval: time.Duration(interval) * time.Second,
-		pingIps:  make([]*net.IPAddr, 0),
+		targets:  make([]*pingTarget, 0),
 		resChan:  make(chan PingLoopResponse),
-		ospid:    rand.Intn(0xffff),
+		id:       newEchoID(),
+		sent:     newSentProbes(sentIntervals),
 	}
 
 	return &p, nil
 }
 
 func (p *PingLoop) AddIpAddr(ip *net.IPAddr) {
-	if !slices.Contains(p.pingIps, ip) {
-		p.pingIps = append(p.pingIps, ip)
+	p.AddTarget(ip, ProbeOpts{})
+}
+
+// AddTarget starts pinging ip from the next interval, it can be called while
+// the loop is running
+func (p *PingLoop) AddTarget(ip *net.IPAddr, probe ProbeOpts) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.targetIndex(ip.String()) == -1 {
+		p.targets = append(p.targets, &pingTarget{ip: ip, probe: probe})
 	}
 }
 
+// RemoveTarget stops pinging ip from the next interval, replies to requests
+// already sent are still matched
+func (p *PingLoop) RemoveTarget(ip string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i := p.targetIndex(ip); i != -1 {
+		p.targets = slices.Delete(p.targets, i, i+1)
+	}
+}
+
+func (p *PingLoop) targetIndex(ip string) int {
+	return slices.IndexFunc(p.targets, func(t *pingTarget) bool {
+		return t.ip.String() == ip
+	})
+}
+
 func (p *PingLoop) Run() error {
-	if len(p.pingIps) == 0 {
+	p.mu.Lock()
+	empty := len(p.targets) == 0
+	p.mu.Unlock()
+	if empty {
 		return errors.New("At least one IP to ping is required")
 	}
 	if p.OnResponse == nil {
@@ -78,11 +139,9 @@ func (p *PingLoop) listenToResChan() {
 }
 
 func (p *PingLoop) startLoop() {
-	// Needs to be uint16 so it overflows before it
-	// becomes larger than icmp headers can handle
-	var seq_counter uint16
 	for {
 		p.OnIntervalStart()
+		interval := p.sent.startInterval()
 
 		// Read should time out and close rtnChan
 		rtnChan, err := p.icmpPing.Read(p.interval)
@@ -90,67 +149,136 @@ func (p *PingLoop) startLoop() {
 			slog.Warn("Error with icmpPing.Read", "error", err)
 		}
 
-		seq := int(seq_counter)
-		go p.makePing(&seq)
+		go p.makePing()
 
 		// Will block until rtnChan is closed by Read
-		p.listenForMessage(seq, seq+len(p.pingIps), rtnChan)
-		p.OnIntervalEnd(p.ospid, seq)
+		p.listenForMessage(rtnChan)
+		p.OnIntervalEnd(p.id.id, interval)
 	}
 }
 
-func (p *PingLoop) makePing(seq *int) {
-	for _, ip := range p.pingIps {
-		*seq += 1
+func (p *PingLoop) makePing() {
+	p.mu.Lock()
+	targets := slices.Clone(p.targets)
+	p.mu.Unlock()
+
+	for _, t := range targets {
 		opts := ICMPPingOpts{
-			id:  p.ospid,
-			IP:  ip,
-			Seq: *seq,
+			id:  p.id.id,
+			IP:  t.ip,
+			Seq: int(t.nextSeq()),
+			DF:  t.probe.DF,
+			TOS: t.probe.TOS,
 		}
+		now := time.Now()
+		opts.Data = t.probe.payload(now)
 		slog.Debug("Pinging", "ip", opts.IP, "seq", opts.Seq)
+		// Added before sending as the reply can arrive before Ping returns
+		ip, seq := t.ip.String(), uint16(opts.Seq)
+		p.sent.add(ip, seq, opts.Data, now)
+		opts.OnSent = func(sent time.Time) {
+			p.sent.setSent(ip, seq, sent)
+		}
 
-		err := p.icmpPing.Ping(opts)
-		if err != nil {
+		if _, err := p.icmpPing.Ping(opts); err != nil {
 			slog.Error("Failed to ping", "error", err, "ip", opts.IP)
-			continue
 		}
 	}
 }
 
-func (p *PingLoop) listenForMessage(min int, max int, rtn chan ICMPPingResponse) {
+func (p *PingLoop) listenForMessage(rtn chan ICMPPingResponse) {
 	for res := range rtn {
-		if res.Message.Type == ipv4.ICMPTypeEchoReply {
-			body := res.Message.Body.(*icmp.Echo)
-			if body.ID == p.ospid && body.Seq > min && body.Seq <= max {
-				duration, err := getDuration(body)
-				if err != nil {
-					slog.Warn("Unable to get duration", "error", err)
-				}
-				slog.Debug("Received response", "ip", res.Peer, "duration", duration, "seq", body.Seq)
-
-				response := PingLoopResponse{
-					Body:     body,
-					Peer:     res.Peer,
-					Duration: duration,
-				}
-
-				p.resChan <- response
-			} else {
-				slog.Debug("Received different ID/Seq ICMP message", "ospid", p.ospid, "id", body.ID, "seq", body.Seq, "min", min, "max", max)
-			}
-		} else {
-			slog.Debug("Received different type ICMP message", "type", res.Message.Type)
+		if response, ok := p.match(res); ok {
+			p.resChan <- response
 		}
 	}
 }
 
-func getDuration(body *icmp.Echo) (time.Duration, error) {
-	if len(body.Data) < 8 {
-		return 0, fmt.Errorf("Echo reply data too short, length: %d", len(body.Data))
+// match pairs a message with the request it's a reply to, it returns false
+// for anything that isn't a reply to this ping loop
+func (p *PingLoop) match(res ICMPPingResponse) (PingLoopResponse, bool) {
+	if res.Message.Type != ipv4.ICMPTypeEchoReply {
+		slog.Debug("Received different type ICMP message", "type", res.Message.Type)
+		return PingLoopResponse{}, false
 	}
 
-	start := utils.BinaryToTime(body.Data[:8])
+	body := res.Message.Body.(*icmp.Echo)
+	if body.ID != p.id.id {
+		slog.Debug("Received different ID ICMP message", "our_id", p.id.id, "id", body.ID, "seq", body.Seq)
+		return PingLoopResponse{}, false
+	}
+	class, probe, ok := p.sent.classify(res.Peer.String(), uint16(body.Seq))
+	if !ok {
+		slog.Debug("Received reply to unknown request", "ip", res.Peer, "seq", body.Seq)
+		return PingLoopResponse{}, false
+	}
+
+	timing := replyTiming(res, probe)
+	if timing.anomaly {
+		slog.Warn("Clock anomaly while request was in flight", "ip", res.Peer, "seq", body.Seq, "duration", timing.duration)
+	}
+	slog.Debug("Received response", "ip", res.Peer, "duration", timing.duration, "userspace_duration", timing.userspace,
+		"seq", body.Seq, "ttl", res.TTL, "class", class)
+
+	response := PingLoopResponse{
+		Body:              body,
+		Peer:              res.Peer,
+		Duration:          timing.duration,
+		TTL:               res.TTL,
+		Class:             class,
+		Kernel:            timing.kernel,
+		UserspaceDuration: timing.userspace,
+		ClockAnomaly:      timing.anomaly,
+	}
+	if !probe.matches(body.Data) {
+		slog.Debug("Received corrupted payload", "ip", res.Peer, "seq", body.Seq, "length", len(body.Data))
+		response.Corrupted = true
+	}
+	return response, true
+}
+
+// clockTolerance is how far the wall clock can drift from the monotonic clock
+// during a request before it counts as an anomaly
+const clockTolerance = 10 * time.Millisecond
+
+type timing struct {
+	duration  time.Duration
+	userspace time.Duration // Measured with the monotonic clock
+	kernel    bool          // duration is from kernel timestamps
+	anomaly   bool
+}
+
+// replyTiming works out the RTT of a reply, from kernel timestamps when
+// they're available and trustworthy. Kernel timestamps use the wall clock, so
+// they're ignored when it jumped while the request was in flight
+func replyTiming(res ICMPPingResponse, probe sentProbe) timing {
 	now := time.Now()
-	duration := now.Sub(start)
-	return duration, nil
+	t := timing{userspace: now.Sub(probe.monotonic)}
+	t.duration = t.userspace
+
+	// Round(0) strips the monotonic reading so Sub uses the wall clock
+	wall := now.Round(0).Sub(probe.monotonic.Round(0))
+	if (wall - t.userspace).Abs() > clockTolerance {
+		t.anomaly = true
+		return t
+	}
+	if !res.Kernel {
+		return t
+	}
+
+	kernel := res.Received.Sub(probe.sent)
+	// The kernel timestamps are taken between the userspace ones, so only a
+	// backwards step or one past the tolerance means the clock moved. The
+	// clocks can disagree by a little without either being wrong, then the
+	// userspace duration is kept
+	if kernel <= 0 || kernel > t.userspace+clockTolerance {
+		t.anomaly = true
+		return t
+	}
+	if kernel > t.userspace {
+		return t
+	}
+	t.duration = kernel
+	t.kernel = true
+	return t
 }
@@ -0,0 +1,190 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestReplyTimingUserspace(t *testing.T) {
+	sent := time.Now().Add(-50 * time.Millisecond)
+	timing := replyTiming(ICMPPingResponse{}, sentProbe{monotonic: sent, sent: sent})
+
+	if timing.kernel || timing.anomaly {
+		t.Errorf("Expected a plain userspace timing, got %+v", timing)
+	}
+	if timing.duration < 50*time.Millisecond || timing.duration != timing.userspace {
+		t.Errorf("Expected the userspace duration to be used, got %+v", timing)
+	}
+}
+
+func TestReplyTimingKernel(t *testing.T) {
+	now := time.Now()
+	probe := sentProbe{monotonic: now.Add(-50 * time.Millisecond), sent: now.Add(-49 * time.Millisecond)}
+	res := ICMPPingResponse{Kernel: true, Received: now.Add(-time.Millisecond)}
+
+	timing := replyTiming(res, probe)
+	if !timing.kernel || timing.anomaly {
+		t.Errorf("Expected a kernel timing, got %+v", timing)
+	}
+	if timing.duration != 48*time.Millisecond {
+		t.Errorf("Expected a duration of 48ms, got %s", timing.duration)
+	}
+}
+
+func TestReplyTimingKernelClockJump(t *testing.T) {
+	now := time.Now()
+	probe := sentProbe{monotonic: now.Add(-50 * time.Millisecond), sent: now.Add(-49 * time.Millisecond)}
+	// The wall clock was stepped back a second after the request was sent
+	res := ICMPPingResponse{Kernel: true, Received: now.Add(-time.Second)}
+
+	timing := replyTiming(res, probe)
+	if timing.kernel || !timing.anomaly {
+		t.Errorf("Expected a clock anomaly, got %+v", timing)
+	}
+	if timing.duration != timing.userspace {
+		t.Errorf("Expected the userspace duration to be used, got %+v", timing)
+	}
+}
+
+func TestReplyTimingKernelSlightlyLonger(t *testing.T) {
+	now := time.Now()
+	probe := sentProbe{monotonic: now.Add(-50 * time.Millisecond), sent: now.Add(-51 * time.Millisecond)}
+	// The kernel clock read a millisecond further apart than userspace did
+	res := ICMPPingResponse{Kernel: true, Received: now}
+
+	timing := replyTiming(res, probe)
+	if timing.kernel || timing.anomaly {
+		t.Errorf("Expected a userspace timing without an anomaly, got %+v", timing)
+	}
+	if timing.duration != timing.userspace {
+		t.Errorf("Expected the userspace duration to be used, got %+v", timing)
+	}
+}
+
+func TestReplyTimingKernelClockForward(t *testing.T) {
+	now := time.Now()
+	probe := sentProbe{monotonic: now.Add(-50 * time.Millisecond), sent: now.Add(-49 * time.Millisecond)}
+	// The wall clock was stepped forward a second after the request was sent
+	res := ICMPPingResponse{Kernel: true, Received: now.Add(time.Second)}
+
+	if timing := replyTiming(res, probe); timing.kernel || !timing.anomaly {
+		t.Errorf("Expected a clock anomaly, got %+v", timing)
+	}
+}
+
+func newTestLoop(id int, ips ...string) *PingLoop {
+	p := &PingLoop{id: &echoID{id: id}, sent: newSentProbes(sentIntervals)}
+	for _, ip := range ips {
+		p.AddTarget(&net.IPAddr{IP: net.ParseIP(ip)}, ProbeOpts{})
+	}
+	return p
+}
+
+// testSend records a request to the target like makePing does and returns
+// the reply it should get
+func testSend(p *PingLoop, target int) ICMPPingResponse {
+	t := p.targets[target]
+	now := time.Now()
+	seq := t.nextSeq()
+	data := t.probe.payload(now)
+	p.sent.add(t.ip.String(), seq, data, now)
+
+	return ICMPPingResponse{
+		Message: &icmp.Message{
+			Type: ipv4.ICMPTypeEchoReply,
+			Body: &icmp.Echo{ID: p.id.id, Seq: int(seq), Data: data},
+		},
+		Peer: t.ip,
+	}
+}
+
+func TestMatchSequenceWrap(t *testing.T) {
+	p := newTestLoop(1, "1.1.1.1", "8.8.8.8")
+	p.targets[0].seq.Store(0xfffe)
+
+	p.sent.startInterval()
+	lost := testSend(p, 0)
+	p.sent.startInterval()
+	wrapped := testSend(p, 0)
+	other := testSend(p, 1)
+
+	if seq := wrapped.Message.Body.(*icmp.Echo).Seq; seq != 0 {
+		t.Fatalf("Expected the sequence to wrap to 0, got %d", seq)
+	}
+	// The other target's sequence is separate so it's also 1
+	if seq := other.Message.Body.(*icmp.Echo).Seq; seq != 1 {
+		t.Fatalf("Expected the other target's sequence to be 1, got %d", seq)
+	}
+
+	if res, ok := p.match(wrapped); !ok || res.Class != ReplyOnTime {
+		t.Errorf("Expected the reply after wrapping to be on time, got %+v", res)
+	}
+	if res, ok := p.match(other); !ok || res.Class != ReplyOnTime {
+		t.Errorf("Expected the reply from the other target to be on time, got %+v", res)
+	}
+
+	p.sent.startInterval()
+	next := testSend(p, 0)
+	if res, ok := p.match(lost); !ok || res.Class != ReplyOutOfOrder {
+		t.Errorf("Expected the reply from before wrapping to be out of order, got %+v", res)
+	}
+	if res, ok := p.match(next); !ok || res.Class != ReplyOnTime {
+		t.Errorf("Expected the next reply to be on time, got %+v", res)
+	}
+}
+
+func TestMatchMultipleInstances(t *testing.T) {
+	ids := []*echoID{newEchoID(), newEchoID()}
+	defer ids[0].release()
+	defer ids[1].release()
+
+	a := newTestLoop(ids[0].id, "1.1.1.1")
+	b := newTestLoop(ids[1].id, "1.1.1.1")
+	a.sent.startInterval()
+	b.sent.startInterval()
+
+	// Both loops send the same sequence to the same IP, and the raw
+	// sockets of both see both replies
+	replyA := testSend(a, 0)
+	replyB := testSend(b, 0)
+
+	if _, ok := b.match(replyA); ok {
+		t.Error("Expected a reply to another instance not to match")
+	}
+	if res, ok := a.match(replyA); !ok || res.Class != ReplyOnTime || res.Corrupted {
+		t.Errorf("Expected the reply to match its own instance, got %+v", res)
+	}
+	if res, ok := b.match(replyB); !ok || res.Class != ReplyOnTime || res.Corrupted {
+		t.Errorf("Expected the reply to match its own instance, got %+v", res)
+	}
+}
+
+func TestNextSeqConcurrently(t *testing.T) {
+	target := &pingTarget{}
+	seqs := make(chan uint16, 100)
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				seqs <- target.nextSeq()
+			}
+		}()
+	}
+	wg.Wait()
+	close(seqs)
+
+	seen := make(map[uint16]bool)
+	for seq := range seqs {
+		if seen[seq] {
+			t.Fatalf("Sequence %d was given out twice", seq)
+		}
+		seen[seq] = true
+	}
+}
@@ -0,0 +1,184 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	minPMTU        = 68 // Smallest MTU an IPv4 link can have
+	icmpEchoHeader = ipv4.HeaderLen + 8
+)
+
+type PMTUOpts struct {
+	MaxMTU   int           // Largest packet size tried
+	Timeout  time.Duration // How long to wait for each probe
+	Attempts int           // Probes of one size that have to go unanswered before it's assumed too big
+	Bind     Bind          // Probe from an interface or address instead of following the routing table
+}
+
+type PMTUResult struct {
+	MTU int `json:"mtu"`
+	// The router that sent fragmentation needed for the smallest packet
+	// that was too big, and the next hop MTU it reported. Hop is nil if
+	// larger packets were dropped silently, as they are in a black hole
+	Hop         net.Addr `json:"hop,omitempty"`
+	ReportedMTU int      `json:"reported_mtu,omitempty"`
+}
+
+type pmtuProbe int
+
+const (
+	pmtuFits pmtuProbe = iota
+	pmtuTooBig
+	pmtuLost
+)
+
+// DiscoverPMTU binary searches for the largest echo request with DF set that
+// gets a reply from ip
+func DiscoverPMTU(ip *net.IPAddr, opts PMTUOpts) (PMTUResult, error) {
+	if opts.MaxMTU == 0 {
+		opts.MaxMTU = 1500
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.Attempts == 0 {
+		opts.Attempts = 3
+	}
+
+	id := newEchoID()
+	defer id.release()
+	p := pmtuProber{ip: ip, opts: opts, id: id.id}
+	return searchPMTU(opts.MaxMTU, p.probe)
+}
+
+// searchPMTU finds the largest size up to maxMTU that probe says fits
+func searchPMTU(maxMTU int, probe func(size int) (pmtuProbe, net.Addr, int, error)) (PMTUResult, error) {
+	result := PMTUResult{}
+
+	// lo is the largest size known to fit and hi the smallest known not to.
+	// Most paths carry the largest size, so that's tried before searching
+	lo, hi := minPMTU-1, maxMTU+1
+	size := maxMTU
+	for hi-lo > 1 {
+		res, hop, nextHopMTU, err := probe(size)
+		if err != nil {
+			return PMTUResult{}, err
+		}
+
+		// Without fragmentation needed, a size that's lost is only too big if
+		// a size known to fit still gets through. Otherwise the path is
+		// dropping everything and the estimate would be lowered for nothing
+		if res == pmtuLost {
+			check, _, _, err := probe(max(lo, minPMTU))
+			if err != nil {
+				return PMTUResult{}, err
+			}
+			if check != pmtuFits {
+				return PMTUResult{}, fmt.Errorf("No reply to a %d byte echo request", max(lo, minPMTU))
+			}
+			if lo < minPMTU {
+				lo = minPMTU
+			}
+		}
+
+		if res == pmtuFits {
+			lo = size
+		} else {
+			hi = size
+			if res == pmtuTooBig {
+				result.Hop = hop
+				result.ReportedMTU = nextHopMTU
+			}
+		}
+
+		size = (lo + hi) / 2
+		// Try the MTU the router reported next, it's usually right
+		if res == pmtuTooBig && nextHopMTU > lo && nextHopMTU < hi {
+			size = nextHopMTU
+		}
+	}
+
+	if lo < minPMTU {
+		return PMTUResult{}, fmt.Errorf("No reply to a %d byte echo request", minPMTU)
+	}
+
+	result.MTU = lo
+	return result, nil
+}
+
+type pmtuProber struct {
+	ip   *net.IPAddr
+	opts PMTUOpts
+	id   int
+	seq  int
+}
+
+// probe sends echo requests of the given total size until one is answered,
+// one is reported as too big, or opts.Attempts go unanswered
+func (p *pmtuProber) probe(size int) (pmtuProbe, net.Addr, int, error) {
+	for range p.opts.Attempts {
+		p.seq++
+		probe, hop, mtu, err := p.send(size, p.seq)
+		if err != nil || probe != pmtuLost {
+			return probe, hop, mtu, err
+		}
+	}
+	return pmtuLost, nil, 0, nil
+}
+
+func (p *pmtuProber) send(size int, seq int) (pmtuProbe, net.Addr, int, error) {
+	icmpPing, err := NewICMPPingOn(p.opts.Bind)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	rtn, err := icmpPing.Read(p.opts.Timeout)
+	if err != nil {
+		icmpPing.Close()
+		return 0, nil, 0, err
+	}
+	defer func() {
+		icmpPing.Close()
+		for range rtn {
+		}
+	}()
+
+	_, err = icmpPing.Ping(ICMPPingOpts{
+		IP:   p.ip,
+		Seq:  seq,
+		id:   p.id,
+		Data: make([]byte, size-icmpEchoHeader),
+		DF:   true,
+	})
+	// The packet is bigger than the MTU of our own interface
+	if errors.Is(err, syscall.EMSGSIZE) {
+		return pmtuTooBig, nil, 0, nil
+	}
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	for res := range rtn {
+		switch res.Message.Type {
+		case ipv4.ICMPTypeEchoReply:
+			body := res.Message.Body.(*icmp.Echo)
+			if body.ID == p.id && body.Seq == seq {
+				return pmtuFits, res.Peer, 0, nil
+			}
+		case ipv4.ICMPTypeDestinationUnreachable:
+			quoted, ok := quotedTransport(res.Message, 1, p.ip.IP)
+			if ok && res.Message.Code == 4 && int(quoted[4])<<8|int(quoted[5]) == p.id && int(quoted[6])<<8|int(quoted[7]) == seq {
+				return pmtuTooBig, res.Peer, res.NextHopMTU, nil
+			}
+		}
+	}
+
+	return pmtuLost, nil, 0, nil
+}
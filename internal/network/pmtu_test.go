@@ -0,0 +1,63 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+// fakePath answers probes like a path that fits mtu, routers report
+// fragmentation needed when reported is set and drop the probe otherwise
+type fakePath struct {
+	mtu      int
+	reported bool
+	down     bool // Nothing gets a reply
+	probes   []int
+}
+
+func (f *fakePath) probe(size int) (pmtuProbe, net.Addr, int, error) {
+	f.probes = append(f.probes, size)
+	switch {
+	case f.down:
+		return pmtuLost, nil, 0, nil
+	case size <= f.mtu:
+		return pmtuFits, nil, 0, nil
+	case f.reported:
+		return pmtuTooBig, &net.IPAddr{IP: net.IPv4(10, 0, 0, 1)}, f.mtu, nil
+	default:
+		return pmtuLost, nil, 0, nil
+	}
+}
+
+func TestSearchPMTU(t *testing.T) {
+	tests := []struct {
+		name string
+		path fakePath
+		mtu  int
+		hop  bool
+	}{
+		{"full size", fakePath{mtu: 1500}, 1500, false},
+		{"fragmentation needed", fakePath{mtu: 1492, reported: true}, 1492, true},
+		{"black hole", fakePath{mtu: 1400}, 1400, false},
+	}
+	for _, test := range tests {
+		result, err := searchPMTU(1500, test.path.probe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.MTU != test.mtu || (result.Hop != nil) != test.hop {
+			t.Errorf("%s: expected an MTU of %d, got %+v", test.name, test.mtu, result)
+		}
+	}
+}
+
+func TestSearchPMTUPathDown(t *testing.T) {
+	path := fakePath{mtu: 1500, down: true}
+	if result, err := searchPMTU(1500, path.probe); err == nil {
+		t.Errorf("Expected an error when nothing gets a reply, got %+v", result)
+	}
+	// Losing the largest size is only taken as too big when a small probe
+	// still gets a reply
+	if len(path.probes) != 2 || path.probes[1] != minPMTU {
+		t.Errorf("Expected the path to be checked with a small probe, got %v", path.probes)
+	}
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+	"bytes"
+	"network_monitor/internal/utils"
+	"time"
+)
+
+// timestampLen is the bytes at the start of each payload holding the send time
+const timestampLen = 8
+
+// ProbeOpts shapes the echo requests sent to a target
+type ProbeOpts struct {
+	Size    int    // Bytes of echo payload including the send time, at least 8
+	Pattern []byte // Repeated to fill the payload after the send time, zeros if empty
+	DF      bool   // Set the don't fragment bit, otherwise the kernel decides
+	TOS     int    // Type of service byte, the DSCP class is the top 6 bits
+}
+
+func (o ProbeOpts) Equal(other ProbeOpts) bool {
+	return o.Size == other.Size && bytes.Equal(o.Pattern, other.Pattern) && o.DF == other.DF && o.TOS == other.TOS
+}
+
+func (o ProbeOpts) payload(now time.Time) []byte {
+	data := make([]byte, max(o.Size, timestampLen))
+	copy(data, utils.TimeToBinary(now))
+	o.fill(data[timestampLen:])
+	return data
+}
+
+func (o ProbeOpts) fill(b []byte) {
+	if len(o.Pattern) == 0 {
+		clear(b)
+		return
+	}
+	for i := range b {
+		b[i] = o.Pattern[i%len(o.Pattern)]
+	}
+}
@@ -0,0 +1,18 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPayloadFill(t *testing.T) {
+	opts := ProbeOpts{Size: 64, Pattern: []byte{0xa5, 0x5a}}
+	data := opts.payload(time.Now())
+
+	if len(data) != 64 {
+		t.Errorf("Expected 64 bytes, got %d", len(data))
+	}
+	if data[8] != 0xa5 || data[9] != 0x5a || data[63] != 0x5a {
+		t.Errorf("Payload isn't filled with the pattern: %x", data)
+	}
+}
@@ -0,0 +1,132 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type ReverseDNSOpts struct {
+	PositiveTTL   time.Duration // How long found names are cached
+	NegativeTTL   time.Duration // How long failed lookups are cached
+	Timeout       time.Duration // Per lookup
+	MaxConcurrent int
+}
+
+type rdnsEntry struct {
+	names   []string
+	expires time.Time
+}
+
+// maxRDNSEntries bounds the cache, expired entries are purged beyond it
+const maxRDNSEntries = 10000
+
+// ReverseDNS caches PTR lookups for hop addresses so that a slow resolver
+// doesn't hold up traces and repeated traces don't hammer it
+type ReverseDNS struct {
+	OnLookup func(hit bool)
+	opts     ReverseDNSOpts
+	lookup   func(ctx context.Context, addr string) ([]string, error)
+	sem      chan struct{}
+	inflight singleflight.Group // Traces at the same time share lookups for the same hop
+	mu       sync.Mutex
+	cache    map[string]rdnsEntry
+}
+
+func NewReverseDNS(opts ReverseDNSOpts) *ReverseDNS {
+	if opts.PositiveTTL == 0 {
+		opts.PositiveTTL = time.Hour
+	}
+	if opts.NegativeTTL == 0 {
+		opts.NegativeTTL = 5 * time.Minute
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	if opts.MaxConcurrent == 0 {
+		opts.MaxConcurrent = 4
+	}
+
+	return &ReverseDNS{
+		opts:   opts,
+		lookup: net.DefaultResolver.LookupAddr,
+		sem:    make(chan struct{}, opts.MaxConcurrent),
+		cache:  make(map[string]rdnsEntry),
+	}
+}
+
+// Lookup returns the names for an IP, from the cache when possible. It
+// returns nil if the IP has no names or the lookup failed or timed out
+func (r *ReverseDNS) Lookup(ip string) []string {
+	r.mu.Lock()
+	entry, ok := r.cache[ip]
+	r.mu.Unlock()
+
+	hit := ok && time.Now().Before(entry.expires)
+	if r.OnLookup != nil {
+		r.OnLookup(hit)
+	}
+	if hit {
+		return entry.names
+	}
+
+	names, _, _ := r.inflight.Do(ip, func() (any, error) {
+		return r.resolve(ip), nil
+	})
+	return names.([]string)
+}
+
+// resolve looks up the names for an IP and caches them
+func (r *ReverseDNS) resolve(ip string) []string {
+	r.sem <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.Timeout)
+	names, err := r.lookup(ctx, ip)
+	cancel()
+	<-r.sem
+
+	ttl := r.opts.PositiveTTL
+	if err != nil || len(names) == 0 {
+		names = nil
+		ttl = r.opts.NegativeTTL
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cache) >= maxRDNSEntries {
+		r.purge()
+	}
+	r.cache[ip] = rdnsEntry{names, time.Now().Add(ttl)}
+
+	return names
+}
+
+func (r *ReverseDNS) purge() {
+	now := time.Now()
+	for ip, entry := range r.cache {
+		if now.After(entry.expires) {
+			delete(r.cache, ip)
+		}
+	}
+}
+
+// annotate looks up the names of every hop at the same time, MaxConcurrent
+// still limits how many lookups reach the resolver
+func (r *ReverseDNS) annotate(hops []Hop) {
+	if r == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := range hops {
+		if hops[i].IP == nil {
+			continue
+		}
+		wg.Go(func() {
+			hops[i].Domains = r.Lookup(hops[i].IP.String())
+		})
+	}
+	wg.Wait()
+}
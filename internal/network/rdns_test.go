@@ -0,0 +1,113 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testReverseDNS(opts ReverseDNSOpts, lookup func(ctx context.Context, addr string) ([]string, error)) (*ReverseDNS, *int, *int) {
+	hits, misses := 0, 0
+	r := NewReverseDNS(opts)
+	r.lookup = lookup
+	r.OnLookup = func(hit bool) {
+		if hit {
+			hits++
+		} else {
+			misses++
+		}
+	}
+	return r, &hits, &misses
+}
+
+func TestReverseDNSCachesNames(t *testing.T) {
+	calls := 0
+	r, hits, misses := testReverseDNS(ReverseDNSOpts{}, func(ctx context.Context, addr string) ([]string, error) {
+		calls++
+		return []string{"dns.google."}, nil
+	})
+
+	for range 3 {
+		if names := r.Lookup("8.8.8.8"); len(names) != 1 || names[0] != "dns.google." {
+			t.Errorf("Unexpected names: %v", names)
+		}
+	}
+
+	if calls != 1 || *hits != 2 || *misses != 1 {
+		t.Errorf("Expected 1 call, 2 hits and 1 miss, got %d, %d and %d", calls, *hits, *misses)
+	}
+}
+
+func TestReverseDNSNegativeTTL(t *testing.T) {
+	calls := 0
+	r, _, _ := testReverseDNS(ReverseDNSOpts{NegativeTTL: time.Millisecond}, func(ctx context.Context, addr string) ([]string, error) {
+		calls++
+		return nil, errors.New("NXDOMAIN")
+	})
+
+	r.Lookup("192.0.2.1")
+	r.Lookup("192.0.2.1")
+	if calls != 1 {
+		t.Errorf("Expected the failure to be cached, got %d calls", calls)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	r.Lookup("192.0.2.1")
+	if calls != 2 {
+		t.Errorf("Expected the failure to expire, got %d calls", calls)
+	}
+}
+
+func TestReverseDNSTimeout(t *testing.T) {
+	r, _, _ := testReverseDNS(ReverseDNSOpts{Timeout: time.Millisecond}, func(ctx context.Context, addr string) ([]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	start := time.Now()
+	if names := r.Lookup("192.0.2.1"); names != nil {
+		t.Errorf("Expected no names, got %v", names)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Lookup didn't time out")
+	}
+}
+
+func TestReverseDNSSharesConcurrentMisses(t *testing.T) {
+	var calls, misses atomic.Int32
+	release := make(chan struct{})
+	r := NewReverseDNS(ReverseDNSOpts{})
+	r.lookup = func(ctx context.Context, addr string) ([]string, error) {
+		calls.Add(1)
+		<-release
+		return []string{"one.one.one.one."}, nil
+	}
+	r.OnLookup = func(hit bool) {
+		if !hit {
+			misses.Add(1)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Go(func() {
+			if names := r.Lookup("1.1.1.1"); len(names) != 1 {
+				t.Errorf("Unexpected names: %v", names)
+			}
+		})
+	}
+	for misses.Load() < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the last lookup time to join the one in flight
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected the misses to share 1 lookup, got %d", calls.Load())
+	}
+}
@@ -0,0 +1,120 @@
+package network
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// ReplyClass describes when an echo reply arrived relative to its request
+type ReplyClass string
+
+const (
+	ReplyOnTime     ReplyClass = "on_time"      // Within the interval the request was sent in
+	ReplyLate       ReplyClass = "late"         // After the interval the request was sent in ended
+	ReplyDuplicate  ReplyClass = "duplicate"    // A copy of a reply that already arrived
+	ReplyOutOfOrder ReplyClass = "out_of_order" // After a reply to a newer request to the same IP
+)
+
+type sentProbe struct {
+	interval  int
+	payload   []byte
+	monotonic time.Time // Taken before sending, it keeps its monotonic clock reading
+	sent      time.Time // Kernel send time when known, otherwise the same as monotonic
+	replied   bool
+}
+
+// matches reports whether an echoed payload is exactly what was sent,
+// middleboxes sometimes truncate or rewrite it
+func (p sentProbe) matches(data []byte) bool {
+	return bytes.Equal(data, p.payload)
+}
+
+// probeKey identifies a request, sequences are only unique per IP
+type probeKey struct {
+	ip  string
+	seq uint16
+}
+
+// sentProbes remembers the requests sent over the last few intervals so
+// replies to them can be classified
+type sentProbes struct {
+	mu       sync.Mutex
+	keep     int
+	interval int
+	probes   map[probeKey]*sentProbe
+	latest   map[string]int // Newest interval each IP replied to
+}
+
+func newSentProbes(keep int) *sentProbes {
+	return &sentProbes{
+		keep:   keep,
+		probes: make(map[probeKey]*sentProbe),
+		latest: make(map[string]int),
+	}
+}
+
+// startInterval moves on to the next interval and forgets requests that were
+// sent too long ago to expect a reply. As long as a target's sequence takes
+// more than keep intervals to wrap, every remembered request has a unique key
+func (s *sentProbes) startInterval() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interval++
+	for key, p := range s.probes {
+		if p.interval <= s.interval-s.keep {
+			delete(s.probes, key)
+		}
+	}
+	return s.interval
+}
+
+func (s *sentProbes) add(ip string, seq uint16, payload []byte, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probes[probeKey{ip, seq}] = &sentProbe{
+		interval:  s.interval,
+		payload:   payload,
+		monotonic: now,
+		sent:      now,
+	}
+}
+
+// setSent replaces the send time of seq with a more accurate one
+func (s *sentProbes) setSent(ip string, seq uint16, sent time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.probes[probeKey{ip, seq}]; ok {
+		p.sent = sent
+	}
+}
+
+// classify records a reply to seq from ip and returns the request it
+// answers, it returns false if no request with seq was sent to ip. Whether a
+// reply is late or out of order goes by the interval its request was sent
+// in, so it isn't thrown by sequences wrapping
+func (s *sentProbes) classify(ip string, seq uint16) (ReplyClass, sentProbe, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.probes[probeKey{ip, seq}]
+	if !ok {
+		return "", sentProbe{}, false
+	}
+
+	if p.replied {
+		return ReplyDuplicate, *p, true
+	}
+	p.replied = true
+
+	if p.interval < s.latest[ip] {
+		return ReplyOutOfOrder, *p, true
+	}
+	s.latest[ip] = p.interval
+
+	if p.interval < s.interval {
+		return ReplyLate, *p, true
+	}
+	return ReplyOnTime, *p, true
+}
@@ -0,0 +1,87 @@
+package network
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestClassifyOnTimeAndDuplicate(t *testing.T) {
+	s := newSentProbes(4)
+	s.startInterval()
+	s.add("1.1.1.1", 1, nil, time.Now())
+
+	if class, _, _ := s.classify("1.1.1.1", 1); class != ReplyOnTime {
+		t.Errorf("Expected on time, got %s", class)
+	}
+	if class, _, _ := s.classify("1.1.1.1", 1); class != ReplyDuplicate {
+		t.Errorf("Expected duplicate, got %s", class)
+	}
+}
+
+func TestClassifyLateAndOutOfOrder(t *testing.T) {
+	s := newSentProbes(4)
+	s.startInterval()
+	s.add("1.1.1.1", 1, nil, time.Now())
+	s.startInterval()
+	s.add("1.1.1.1", 2, nil, time.Now())
+	s.startInterval()
+	s.add("1.1.1.1", 3, nil, time.Now())
+
+	// The reply to 2 arrives an interval late but before the reply to 1
+	if class, _, _ := s.classify("1.1.1.1", 2); class != ReplyLate {
+		t.Errorf("Expected late, got %s", class)
+	}
+	if class, _, _ := s.classify("1.1.1.1", 1); class != ReplyOutOfOrder {
+		t.Errorf("Expected out of order, got %s", class)
+	}
+	if class, _, _ := s.classify("1.1.1.1", 3); class != ReplyOnTime {
+		t.Errorf("Expected on time, got %s", class)
+	}
+}
+
+func TestClassifyUnknown(t *testing.T) {
+	s := newSentProbes(2)
+	s.startInterval()
+	s.add("1.1.1.1", 1, nil, time.Now())
+
+	if _, _, ok := s.classify("8.8.8.8", 1); ok {
+		t.Error("Expected a reply from a different IP to be unknown")
+	}
+	if _, _, ok := s.classify("1.1.1.1", 2); ok {
+		t.Error("Expected a reply to an unsent sequence to be unknown")
+	}
+
+	s.startInterval()
+	s.startInterval()
+	if _, _, ok := s.classify("1.1.1.1", 1); ok {
+		t.Error("Expected a reply to a forgotten sequence to be unknown")
+	}
+}
+
+func TestSentProbeMatches(t *testing.T) {
+	opts := ProbeOpts{Size: 32}
+	data := opts.payload(time.Now())
+	s := newSentProbes(2)
+	s.startInterval()
+	s.add("1.1.1.1", 1, data, time.Now())
+	_, probe, _ := s.classify("1.1.1.1", 1)
+
+	if !probe.matches(slices.Clone(data)) {
+		t.Error("Expected the payload that was sent to match")
+	}
+	if probe.matches(data[:16]) {
+		t.Error("Expected a truncated payload not to match")
+	}
+
+	mangled := slices.Clone(data)
+	mangled[20] = 0xff
+	if probe.matches(mangled) {
+		t.Error("Expected a mangled payload not to match")
+	}
+
+	// The send time is checked as well as the pattern
+	if probe.matches(opts.payload(time.Now().Add(time.Second))) {
+		t.Error("Expected a payload with a different send time not to match")
+	}
+}
@@ -0,0 +1,20 @@
+package network
+
+// initialTTLs are the TTLs operating systems commonly start packets with,
+// 64 for Linux and macOS, 128 for Windows and 255 for network equipment
+var initialTTLs = []int{32, 64, 128, 255}
+
+// InferHops guesses how many routers a reply crossed on the way back from
+// its TTL, assuming the sender used the nearest common initial TTL above it.
+// It returns -1 when the TTL is unknown
+func InferHops(ttl int) int {
+	if ttl <= 0 || ttl > 255 {
+		return -1
+	}
+	for _, initial := range initialTTLs {
+		if ttl <= initial {
+			return initial - ttl
+		}
+	}
+	return -1
+}
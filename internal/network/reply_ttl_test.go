@@ -0,0 +1,25 @@
+package network
+
+import "testing"
+
+func TestInferHops(t *testing.T) {
+	tests := []struct {
+		ttl  int
+		hops int
+	}{
+		{64, 0},
+		{57, 7},
+		{118, 10},
+		{128, 0},
+		{244, 11},
+		{30, 2},
+		{0, -1},
+		{256, -1},
+	}
+
+	for _, tt := range tests {
+		if hops := InferHops(tt.ttl); hops != tt.hops {
+			t.Errorf("InferHops(%d) = %d, expected %d", tt.ttl, hops, tt.hops)
+		}
+	}
+}
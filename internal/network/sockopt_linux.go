@@ -0,0 +1,50 @@
+package network
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func setDontFragment(conn *net.IPConn, df bool) error {
+	// PMTUDISC_PROBE sets DF without limiting sends to the cached path MTU
+	mode := unix.IP_PMTUDISC_DONT
+	if df {
+		mode = unix.IP_PMTUDISC_PROBE
+	}
+	return setsockoptInt(conn, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, mode)
+}
+
+// bindToDevice limits the socket to sending and receiving on iface
+func bindToDevice(conn syscall.Conn, iface string) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+func setsockoptInt(conn syscall.Conn, level, opt, value int) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), level, opt, value)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func setDontFragment(conn *net.IPConn, df bool) error {
+	return errors.New("Setting the DF bit is only supported on linux")
+}
+
+func bindToDevice(conn syscall.Conn, iface string) error {
+	return errors.New("Binding to an interface is only supported on linux")
+}
@@ -0,0 +1,116 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"slices"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+type SweepOpts struct {
+	Rate    int           // Echo requests sent per second
+	Timeout time.Duration // How long to wait for replies after the last request
+	Bind    Bind
+}
+
+// SubnetSize returns the number of host addresses in subnet, without the
+// network and broadcast addresses of IPv4 subnets bigger than a /31
+func SubnetSize(subnet *net.IPNet) *big.Int {
+	ones, bits := subnet.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	if bits == 8*net.IPv4len && bits-ones > 1 {
+		size.Sub(size, big.NewInt(2))
+	}
+	return size
+}
+
+// SubnetHosts returns the host addresses of an IPv4 subnet in order
+func SubnetHosts(subnet *net.IPNet) []net.IP {
+	ip := subnet.IP.To4()
+	if ip == nil {
+		return nil
+	}
+
+	ones, bits := subnet.Mask.Size()
+	first := binary.BigEndian.Uint32(ip.Mask(subnet.Mask))
+	last := first | uint32(1<<(bits-ones)-1)
+	if bits-ones > 1 {
+		first++
+		last--
+	}
+
+	hosts := make([]net.IP, 0, last-first+1)
+	for n := uint64(first); n <= uint64(last); n++ {
+		host := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(host, uint32(n))
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// Sweep sends one echo request to every host in an IPv4 subnet, at most
+// opts.Rate a second, and returns the hosts that replied
+func Sweep(subnet *net.IPNet, opts SweepOpts) ([]net.IP, error) {
+	hosts := SubnetHosts(subnet)
+	if hosts == nil {
+		return nil, errors.New("Only IPv4 subnets can be swept")
+	}
+	if opts.Rate <= 0 {
+		opts.Rate = 20
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 2 * time.Second
+	}
+
+	icmpPing, err := NewICMPPingOn(opts.Bind)
+	if err != nil {
+		return nil, err
+	}
+	defer icmpPing.Close()
+	id := newEchoID()
+	defer id.release()
+
+	gap := time.Second / time.Duration(opts.Rate)
+	rtn, err := icmpPing.Read(time.Duration(len(hosts))*gap + opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(gap)
+		defer ticker.Stop()
+		for i, host := range hosts {
+			_, err := icmpPing.Ping(ICMPPingOpts{IP: &net.IPAddr{IP: host}, Seq: i & 0xffff, id: id.id})
+			if err != nil {
+				slog.Debug("Failed to ping during sweep", "error", err, "ip", host)
+			}
+			<-ticker.C
+		}
+	}()
+
+	alive := make([]net.IP, 0)
+	for res := range rtn {
+		if res.Message.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		body := res.Message.Body.(*icmp.Echo)
+		peer := addrIP(res.Peer)
+		if body.ID != id.id || peer == nil || !subnet.Contains(peer) {
+			continue
+		}
+		if !slices.ContainsFunc(alive, peer.Equal) {
+			alive = append(alive, peer)
+		}
+	}
+
+	slices.SortFunc(alive, func(a, b net.IP) int {
+		return slices.Compare(a.To4(), b.To4())
+	})
+	return alive, nil
+}
@@ -0,0 +1,45 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSubnetHosts(t *testing.T) {
+	tests := []struct {
+		cidr  string
+		first string
+		last  string
+		count int
+	}{
+		{"192.168.1.0/24", "192.168.1.1", "192.168.1.254", 254},
+		{"192.168.1.77/24", "192.168.1.1", "192.168.1.254", 254},
+		{"10.0.0.0/30", "10.0.0.1", "10.0.0.2", 2},
+		{"10.0.0.0/31", "10.0.0.0", "10.0.0.1", 2},
+		{"10.0.0.5/32", "10.0.0.5", "10.0.0.5", 1},
+	}
+
+	for _, test := range tests {
+		_, subnet, _ := net.ParseCIDR(test.cidr)
+		hosts := SubnetHosts(subnet)
+		if len(hosts) != test.count {
+			t.Fatalf("%s: expected %d hosts, got %d", test.cidr, test.count, len(hosts))
+		}
+		if hosts[0].String() != test.first || hosts[len(hosts)-1].String() != test.last {
+			t.Errorf("%s: expected %s to %s, got %s to %s", test.cidr, test.first, test.last, hosts[0], hosts[len(hosts)-1])
+		}
+		if size := SubnetSize(subnet); size.Int64() != int64(test.count) {
+			t.Errorf("%s: SubnetSize() = %s, expected %d", test.cidr, size, test.count)
+		}
+	}
+}
+
+func TestSubnetSizeIPv6(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("fd00::/64")
+	if size := SubnetSize(subnet); size.String() != "18446744073709551616" {
+		t.Errorf("SubnetSize(fd00::/64) = %s", size)
+	}
+	if hosts := SubnetHosts(subnet); hosts != nil {
+		t.Errorf("Expected no hosts for an IPv6 subnet, got %d", len(hosts))
+	}
+}
@@ -0,0 +1,138 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// enableTimestamps asks the kernel to timestamp packets as they're received
+// and, where the kernel supports it, as they're sent
+func enableTimestamps(conn *net.IPConn) (bool, error) {
+	if err := setsockoptInt(conn, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1); err != nil {
+		return false, err
+	}
+
+	// Send timestamps are queued on the socket error queue, OPT_ID tags them
+	// with a counter of the packets sent so they can be matched up
+	flags := unix.SOF_TIMESTAMPING_TX_SOFTWARE | unix.SOF_TIMESTAMPING_SOFTWARE |
+		unix.SOF_TIMESTAMPING_OPT_ID | unix.SOF_TIMESTAMPING_OPT_TSONLY
+	if err := setsockoptInt(conn, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// readMessage reads an ICMP message along with its TTL and the time the
+// kernel received it, which is zero if unknown
+func (p *iCMPPing) readMessage(buf []byte, oob []byte) (int, net.Addr, int, time.Time, error) {
+	n, oobn, _, peer, err := p.conn.ReadMsgIP(buf, oob)
+	if err != nil {
+		return 0, nil, 0, time.Time{}, err
+	}
+	// Send timestamps are queued as the request goes out, so reading them
+	// here passes them on before the reply is matched to its request
+	if p.txTimestamp {
+		p.readSendTimestamps()
+	}
+
+	// Unlike ReadFrom, ReadMsgIP leaves the IPv4 header in place
+	if n > 0 {
+		hdrlen := int(buf[0]&0x0f) << 2
+		if hdrlen > n {
+			return 0, nil, 0, time.Time{}, errors.New("IPv4 header truncated")
+		}
+		n = copy(buf, buf[hdrlen:n])
+	}
+
+	cmsgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, peer, 0, time.Time{}, nil
+	}
+
+	var ttl int
+	var received time.Time
+	for _, cmsg := range cmsgs {
+		switch {
+		case cmsg.Header.Level == unix.IPPROTO_IP && cmsg.Header.Type == unix.IP_TTL && len(cmsg.Data) >= 4:
+			ttl = int(binary.NativeEndian.Uint32(cmsg.Data))
+		case cmsg.Header.Level == unix.SOL_SOCKET && cmsg.Header.Type == unix.SCM_TIMESTAMPNS:
+			received = parseTimespec(cmsg.Data)
+		}
+	}
+	return n, peer, ttl, received, nil
+}
+
+// readSendTimestamps drains the socket error queue without waiting, passing
+// each send timestamp to the callback for the packet it's tagged with
+func (p *iCMPPing) readSendTimestamps() {
+	rc, err := p.conn.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	buf := make([]byte, 1)
+	oob := make([]byte, 512)
+	rc.Control(func(fd uintptr) {
+		for {
+			_, oobn, _, _, err := unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+			if err != nil {
+				return
+			}
+			if sent, id, ok := parseSendTimestamp(oob[:oobn]); ok {
+				p.pending.done(id, sent)
+			}
+		}
+	})
+}
+
+// parseSendTimestamp returns the send time in a message from the error queue
+// and the OPT_ID counter of the packet it's for
+func parseSendTimestamp(oob []byte) (time.Time, uint32, bool) {
+	cmsgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	var sent time.Time
+	var id uint32
+	var hasID bool
+	for _, cmsg := range cmsgs {
+		switch {
+		case cmsg.Header.Level == unix.SOL_SOCKET && cmsg.Header.Type == unix.SCM_TIMESTAMPING:
+			// The software timestamp is the first of three
+			sent = parseTimespec(cmsg.Data)
+		case cmsg.Header.Level == unix.IPPROTO_IP && cmsg.Header.Type == unix.IP_RECVERR && len(cmsg.Data) >= 16:
+			// struct sock_extended_err has ee_origin at offset 4 and ee_data,
+			// the OPT_ID counter, at 12
+			if cmsg.Data[4] == unix.SO_EE_ORIGIN_TIMESTAMPING {
+				id = binary.NativeEndian.Uint32(cmsg.Data[12:16])
+				hasID = true
+			}
+		}
+	}
+	return sent, id, hasID && !sent.IsZero()
+}
+
+// parseTimespec decodes the first struct timespec in b, which has 32 bit
+// fields on 32 bit platforms such as older Raspberry Pis
+func parseTimespec(b []byte) time.Time {
+	var sec, nsec int64
+	switch len(b) {
+	case 16, 48: // One or three 64 bit timespecs
+		sec = int64(binary.NativeEndian.Uint64(b[0:8]))
+		nsec = int64(binary.NativeEndian.Uint64(b[8:16]))
+	case 8, 24: // One or three 32 bit timespecs
+		sec = int64(int32(binary.NativeEndian.Uint32(b[0:4])))
+		nsec = int64(int32(binary.NativeEndian.Uint32(b[4:8])))
+	default:
+		return time.Time{}
+	}
+	if sec == 0 && nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, nsec)
+}
@@ -0,0 +1,99 @@
+package network
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseTimespec(t *testing.T) {
+	want := time.Unix(1700000000, 123456789)
+
+	b64 := make([]byte, 48)
+	binary.NativeEndian.PutUint64(b64[0:8], uint64(want.Unix()))
+	binary.NativeEndian.PutUint64(b64[8:16], uint64(want.Nanosecond()))
+	if got := parseTimespec(b64[:16]); !got.Equal(want) {
+		t.Errorf("Expected %s from a 64 bit timespec, got %s", want, got)
+	}
+	if got := parseTimespec(b64); !got.Equal(want) {
+		t.Errorf("Expected %s from three 64 bit timespecs, got %s", want, got)
+	}
+
+	b32 := make([]byte, 8)
+	binary.NativeEndian.PutUint32(b32[0:4], uint32(want.Unix()))
+	binary.NativeEndian.PutUint32(b32[4:8], uint32(want.Nanosecond()))
+	if got := parseTimespec(b32); !got.Equal(want) {
+		t.Errorf("Expected %s from a 32 bit timespec, got %s", want, got)
+	}
+
+	if got := parseTimespec(make([]byte, 16)); !got.IsZero() {
+		t.Errorf("Expected an empty timespec to be zero, got %s", got)
+	}
+}
+
+// cmsg encodes a control message as the kernel would
+func cmsg(level int32, typ int32, data []byte) []byte {
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = level
+	h.Type = typ
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+	return b
+}
+
+// sendTimestampMessage returns the control messages of an error queue entry
+// with a send timestamp for the packet with the OPT_ID counter id
+func sendTimestampMessage(sent time.Time, id uint32, origin uint8) []byte {
+	ts := make([]byte, 48)
+	binary.NativeEndian.PutUint64(ts[0:8], uint64(sent.Unix()))
+	binary.NativeEndian.PutUint64(ts[8:16], uint64(sent.Nanosecond()))
+
+	// struct sock_extended_err
+	ee := make([]byte, 16)
+	binary.NativeEndian.PutUint32(ee[0:4], uint32(unix.ENOMSG))
+	ee[4] = origin
+	binary.NativeEndian.PutUint32(ee[12:16], id)
+
+	return append(cmsg(unix.SOL_SOCKET, unix.SCM_TIMESTAMPING, ts), cmsg(unix.IPPROTO_IP, unix.IP_RECVERR, ee)...)
+}
+
+func TestParseSendTimestamp(t *testing.T) {
+	want := time.Unix(1700000000, 123456789)
+
+	sent, id, ok := parseSendTimestamp(sendTimestampMessage(want, 42, unix.SO_EE_ORIGIN_TIMESTAMPING))
+	if !ok || id != 42 || !sent.Equal(want) {
+		t.Errorf("Expected %s for packet 42, got %s for packet %d (ok %v)", want, sent, id, ok)
+	}
+
+	// An ICMP error queued for the socket isn't a send timestamp
+	if _, _, ok := parseSendTimestamp(sendTimestampMessage(want, 42, unix.SO_EE_ORIGIN_ICMP)); ok {
+		t.Error("Expected a message from another origin to be ignored")
+	}
+	if _, _, ok := parseSendTimestamp(sendTimestampMessage(time.Unix(0, 0), 42, unix.SO_EE_ORIGIN_TIMESTAMPING)); ok {
+		t.Error("Expected a message without a timestamp to be ignored")
+	}
+}
+
+func TestPendingSends(t *testing.T) {
+	s := pendingSends{callbacks: make(map[uint32]func(time.Time))}
+	got := make(map[uint32]time.Time)
+	for _, id := range []uint32{0xfffffffe, 0xffffffff, 0, 1} {
+		s.add(id, func(sent time.Time) { got[id] = sent })
+	}
+
+	// The timestamp for 0xffffffff never came, the counter wraps after it
+	sent := time.Unix(1700000000, 0)
+	s.done(0xfffffffe, sent)
+	s.done(0, sent.Add(time.Millisecond))
+
+	if len(got) != 2 || !got[0xfffffffe].Equal(sent) || !got[0].Equal(sent.Add(time.Millisecond)) {
+		t.Errorf("Expected timestamps for 0xfffffffe and 0, got %v", got)
+	}
+	if _, ok := s.callbacks[1]; len(s.callbacks) != 1 || !ok {
+		t.Errorf("Expected only the callback for 1 to be left, got %d", len(s.callbacks))
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package network
+
+import (
+	"net"
+	"time"
+)
+
+func enableTimestamps(conn *net.IPConn) (bool, error) {
+	return false, nil
+}
+
+// readMessage reads an ICMP message along with its TTL, kernel timestamps are
+// only supported on linux so the receive time is always zero
+func (p *iCMPPing) readMessage(buf []byte, oob []byte) (int, net.Addr, int, time.Time, error) {
+	n, cm, peer, err := p.pc.ReadFrom(buf)
+	if err != nil {
+		return 0, nil, 0, time.Time{}, err
+	}
+
+	var ttl int
+	if cm != nil {
+		ttl = cm.TTL
+	}
+	return n, peer, ttl, time.Time{}, nil
+}
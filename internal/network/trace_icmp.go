@@ -0,0 +1,104 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// icmpTracer probes with echo requests, the probe key is the echo sequence.
+// In Paris mode the payload is chosen so the checksum stays the same as the
+// sequence changes, with the flow picking which checksum that is
+type icmpTracer struct {
+	ip    *net.IPAddr
+	id    *echoID
+	paris bool
+	ping  *iCMPPing
+	out   chan traceReply
+}
+
+func newICMPTracer(ip *net.IPAddr, opts TraceOpts) (*icmpTracer, error) {
+	ping, err := NewICMPPingOn(opts.Bind)
+	if err != nil {
+		return nil, err
+	}
+
+	rtn, err := ping.Read(opts.Timeout)
+	if err != nil {
+		ping.Close()
+		return nil, err
+	}
+
+	t := icmpTracer{
+		ip:    ip,
+		id:    newEchoID(),
+		paris: opts.Paris,
+		ping:  ping,
+		out:   make(chan traceReply),
+	}
+	go t.listen(rtn)
+
+	return &t, nil
+}
+
+func (t *icmpTracer) send(ttl int, key int, flow int) error {
+	opts := ICMPPingOpts{
+		IP:  t.ip,
+		TTL: ttl,
+		Seq: key,
+		id:  t.id.id,
+	}
+	if t.paris {
+		opts.Data = parisPayload(uint16(key), uint16(flow))
+	}
+	_, err := t.ping.Ping(opts)
+	return err
+}
+
+// parisPayload returns a payload that brings the ones' complement sum of the
+// sequence and payload to flow, so the checksum only depends on the flow
+func parisPayload(seq uint16, flow uint16) []byte {
+	sum := uint32(flow) + uint32(^seq)
+	sum = (sum & 0xffff) + (sum >> 16)
+
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(sum))
+	return data
+}
+
+func (t *icmpTracer) listen(rtn chan ICMPPingResponse) {
+	for res := range rtn {
+		if res.Message.Type == ipv4.ICMPTypeEchoReply {
+			body := res.Message.Body.(*icmp.Echo)
+			if body.ID != t.id.id {
+				continue
+			}
+			t.out <- traceReply{
+				key:      body.Seq,
+				peer:     res.Peer,
+				received: res.Received,
+				final:    true,
+				icmpType: ipv4.ICMPTypeEchoReply.String(),
+			}
+			continue
+		}
+
+		quoted, ok := quotedTransport(res.Message, 1, t.ip.IP)
+		if !ok || quoted[0] != byte(ipv4.ICMPTypeEcho) || int(binary.BigEndian.Uint16(quoted[4:6])) != t.id.id {
+			continue
+		}
+		t.out <- icmpErrorReply(res, int(binary.BigEndian.Uint16(quoted[6:8])))
+	}
+	close(t.out)
+}
+
+func (t *icmpTracer) replies() <-chan traceReply {
+	return t.out
+}
+
+func (t *icmpTracer) close() {
+	t.ping.Close()
+	t.id.release()
+}
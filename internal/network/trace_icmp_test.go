@@ -0,0 +1,37 @@
+package network
+
+import (
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func echoChecksum(t *testing.T, seq int, data []byte) uint16 {
+	m := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: 1234, Seq: seq, Data: data},
+	}
+	b, err := m.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint16(b[2])<<8 | uint16(b[3])
+}
+
+func TestParisPayloadKeepsChecksum(t *testing.T) {
+	want := echoChecksum(t, 1, parisPayload(1, 0))
+	for _, seq := range []int{2, 255, 0x8000, 0xffff} {
+		if got := echoChecksum(t, seq, parisPayload(uint16(seq), 0)); got != want {
+			t.Errorf("Checksum for seq %d was %#x, expected %#x", seq, got, want)
+		}
+	}
+}
+
+func TestParisPayloadFlowsDiffer(t *testing.T) {
+	flow0 := echoChecksum(t, 1, parisPayload(1, 0))
+	flow1 := echoChecksum(t, 1, parisPayload(1, 1))
+	if flow0 == flow1 {
+		t.Errorf("Expected flows to have different checksums, both were %#x", flow0)
+	}
+}
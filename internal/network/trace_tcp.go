@@ -0,0 +1,197 @@
+package network
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// tcpTracer probes with hand built TCP SYN segments over a raw socket, the
+// probe key is added to a random base to give the segment's sequence number.
+// Only the flow changes the source port, so traces are always Paris style
+type tcpTracer struct {
+	ip      *net.IPAddr
+	port    int
+	srcIP   net.IP
+	srcPort int // Source port of flow 0
+	flows   int
+	seqBase uint32
+	conn    *net.IPConn
+	ping    *iCMPPing
+	out     chan traceReply
+}
+
+func newTCPTracer(ip *net.IPAddr, opts TraceOpts, flows int) (*tcpTracer, error) {
+	srcIP, err := opts.Bind.sourceIP(ip.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := opts.Bind.listenIP("ip4:tcp")
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(opts.Timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ping, err := NewICMPPingOn(opts.Bind)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	rtn, err := ping.Read(opts.Timeout)
+	if err != nil {
+		conn.Close()
+		ping.Close()
+		return nil, err
+	}
+
+	t := tcpTracer{
+		ip:      ip,
+		port:    opts.Port,
+		srcIP:   srcIP,
+		srcPort: 32768 + rand.Intn(28232-flows),
+		flows:   flows,
+		seqBase: rand.Uint32(),
+		conn:    conn,
+		ping:    ping,
+		out:     make(chan traceReply),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		t.listenICMP(rtn)
+		wg.Done()
+	}()
+	go func() {
+		t.listenTCP()
+		wg.Done()
+	}()
+	go func() {
+		wg.Wait()
+		close(t.out)
+	}()
+
+	return &t, nil
+}
+
+func (t *tcpTracer) send(ttl int, key int, flow int) error {
+	if err := ipv4.NewPacketConn(t.conn).SetTTL(ttl); err != nil {
+		return err
+	}
+
+	seg := make([]byte, 20)
+	binary.BigEndian.PutUint16(seg[0:2], uint16(t.srcPort+flow))
+	binary.BigEndian.PutUint16(seg[2:4], uint16(t.port))
+	binary.BigEndian.PutUint32(seg[4:8], t.seqBase+uint32(key))
+	seg[12] = 5 << 4 // Data offset in 32 bit words
+	seg[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(seg[14:16], 0xffff) // Window
+	binary.BigEndian.PutUint16(seg[16:18], tcpChecksum(t.srcIP, t.ip.IP, seg))
+
+	_, err := t.conn.WriteToIP(seg, t.ip)
+	return err
+}
+
+func (t *tcpTracer) listenICMP(rtn chan ICMPPingResponse) {
+	for res := range rtn {
+		quoted, ok := quotedTransport(res.Message, 6, t.ip.IP)
+		if !ok || !t.ownPort(int(binary.BigEndian.Uint16(quoted[0:2]))) || int(binary.BigEndian.Uint16(quoted[2:4])) != t.port {
+			continue
+		}
+
+		reply := icmpErrorReply(res, int(binary.BigEndian.Uint32(quoted[4:8])-t.seqBase))
+		reply.port = t.port
+		t.out <- reply
+	}
+}
+
+func (t *tcpTracer) listenTCP() {
+	for {
+		buf := make([]byte, 1500)
+		n, peer, err := t.conn.ReadFromIP(buf)
+		if err != nil {
+			return
+		}
+		received := time.Now()
+
+		seg := buf[:n]
+		if n < 20 || !peer.IP.Equal(t.ip.IP) ||
+			int(binary.BigEndian.Uint16(seg[0:2])) != t.port ||
+			!t.ownPort(int(binary.BigEndian.Uint16(seg[2:4]))) {
+			continue
+		}
+
+		var flags string
+		switch {
+		case seg[13]&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN|tcpFlagACK:
+			flags = "SA"
+		case seg[13]&tcpFlagRST != 0:
+			flags = "RA"
+		default:
+			continue
+		}
+
+		// SYN-ACKs and RSTs acknowledge our sequence number plus one
+		ack := binary.BigEndian.Uint32(seg[8:12])
+		t.out <- traceReply{
+			key:      int(ack - 1 - t.seqBase),
+			peer:     peer,
+			received: received,
+			final:    true,
+			port:     t.port,
+			tcpFlags: flags,
+		}
+	}
+}
+
+func (t *tcpTracer) ownPort(port int) bool {
+	return port >= t.srcPort && port < t.srcPort+t.flows
+}
+
+func (t *tcpTracer) replies() <-chan traceReply {
+	return t.out
+}
+
+func (t *tcpTracer) close() {
+	t.conn.Close()
+	t.ping.Close()
+}
+
+func tcpChecksum(src net.IP, dst net.IP, seg []byte) uint16 {
+	pseudo := make([]byte, 12, 12+len(seg))
+	copy(pseudo[0:4], src.To4())
+	copy(pseudo[4:8], dst.To4())
+	pseudo[9] = 6
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(seg)))
+	return checksum(append(pseudo, seg...))
+}
+
+// checksum is the internet checksum from RFC 1071
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
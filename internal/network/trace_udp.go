@@ -0,0 +1,117 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// udpTracer probes with UDP datagrams to high ports, the probe key is added
+// to the base port to give each probe its own destination port. In Paris mode
+// the ports only change with the flow and the key is the payload length
+type udpTracer struct {
+	ip      *net.IPAddr
+	port    int
+	paris   bool
+	conn    *net.UDPConn
+	srcPort int
+	ping    *iCMPPing
+	out     chan traceReply
+}
+
+func newUDPTracer(ip *net.IPAddr, opts TraceOpts) (*udpTracer, error) {
+	conn, err := opts.Bind.listenUDP()
+	if err != nil {
+		return nil, err
+	}
+
+	ping, err := NewICMPPingOn(opts.Bind)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	rtn, err := ping.Read(opts.Timeout)
+	if err != nil {
+		conn.Close()
+		ping.Close()
+		return nil, err
+	}
+
+	t := udpTracer{
+		ip:      ip,
+		port:    opts.Port,
+		paris:   opts.Paris,
+		conn:    conn,
+		srcPort: conn.LocalAddr().(*net.UDPAddr).Port,
+		ping:    ping,
+		out:     make(chan traceReply),
+	}
+	go t.listen(rtn)
+
+	return &t, nil
+}
+
+// maxParisUDPKey keeps the payload of Paris probes inside a 1500 byte MTU
+const maxParisUDPKey = 1472
+
+func (t *udpTracer) send(ttl int, key int, flow int) error {
+	if err := ipv4.NewPacketConn(t.conn).SetTTL(ttl); err != nil {
+		return err
+	}
+
+	dst := net.UDPAddr{IP: t.ip.IP, Port: t.port + key}
+	payload := make([]byte, 32)
+	if t.paris {
+		if key > maxParisUDPKey {
+			return fmt.Errorf("Too many probes for a Paris UDP trace: %d", key)
+		}
+		dst.Port = t.port + flow
+		payload = make([]byte, key)
+	}
+
+	_, err := t.conn.WriteToUDP(payload, &dst)
+	return err
+}
+
+func (t *udpTracer) listen(rtn chan ICMPPingResponse) {
+	for res := range rtn {
+		quoted, ok := quotedTransport(res.Message, 17, t.ip.IP)
+		if !ok || int(binary.BigEndian.Uint16(quoted[0:2])) != t.srcPort {
+			continue
+		}
+
+		dstPort := int(binary.BigEndian.Uint16(quoted[2:4]))
+		key := dstPort - t.port
+		if t.paris {
+			key = int(binary.BigEndian.Uint16(quoted[4:6])) - 8
+		}
+
+		reply := icmpErrorReply(res, key)
+		reply.port = dstPort
+		// Only the destination itself says the port is unreachable, any
+		// other rejection means the probe was stopped on the way
+		if isPortUnreachable(res.Message) && addrIP(res.Peer).Equal(t.ip.IP) {
+			reply.final = true
+			reply.unreachable = ""
+		}
+		t.out <- reply
+	}
+	close(t.out)
+}
+
+func isPortUnreachable(msg *icmp.Message) bool {
+	return msg.Type == ipv4.ICMPTypeDestinationUnreachable && msg.Code == 3
+}
+
+func (t *udpTracer) replies() <-chan traceReply {
+	return t.out
+}
+
+func (t *udpTracer) close() {
+	t.conn.Close()
+	t.ping.Close()
+}
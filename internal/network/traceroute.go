@@ -1,7 +1,7 @@
 package network
 
 import (
-	"math/rand"
+	"fmt"
 	"net"
 	"time"
 
@@ -10,59 +10,375 @@ import (
 )
 
 type Hop struct {
-	IP      net.Addr `json:"ip"`
-	Domains []string `json:"domains,omitempty"`
+	TTL      int             `json:"ttl"`
+	IP       net.Addr        `json:"ip"`
+	Domains  []string        `json:"domains,omitempty"`
+	AS       *ASInfo         `json:"as,omitempty"`
+	RTTs     []time.Duration `json:"rtts,omitempty"`
+	Sent     int             `json:"sent"`
+	Received int             `json:"received"`
+
+	// Protocol specific details of the first reply from the hop
+	Port     int    `json:"port,omitempty"` // Destination port of the UDP or TCP probe
+	ICMPType string `json:"icmp_type,omitempty"`
+	ICMPCode int    `json:"icmp_code,omitempty"`
+	TCPFlags string `json:"tcp_flags,omitempty"`
+	// Traceroute style annotation when the hop rejected the probe, like !H or !N
+	Unreachable string `json:"unreachable,omitempty"`
+
+	// ICMP extensions and the quoted probe from the first error from the hop
+	MPLS       []MPLSLabel     `json:"mpls,omitempty"`
+	Interfaces []InterfaceInfo `json:"interfaces,omitempty"`
+	QuotedTTL  int             `json:"quoted_ttl,omitempty"` // TTL left on the probe when the hop quoted it
 }
 
-func Traceroute(ip *net.IPAddr) ([]Hop, error) {
-	hops := make([]Hop, 0)
+// Loss returns the fraction of probes sent to this hop that went unanswered
+func (h Hop) Loss() float64 {
+	if h.Sent == 0 {
+		return 0
+	}
+	return float64(h.Sent-h.Received) / float64(h.Sent)
+}
 
-loop:
-	for i := 1; i <= 30; i++ {
-		icmpPing, err := NewICMPPing()
-		if err != nil {
-			return nil, err
+// TunnelDepth estimates how far into an MPLS tunnel the hop is. Routers in a
+// tunnel quote the IP header as it was when it entered the tunnel, so the
+// quoted TTL goes up by one for each router since the entrance instead of
+// staying at 1. Tunnels that don't send labels can still be found this way.
+// Only time exceeded errors are sent when the TTL runs out, other errors
+// quote whatever TTL the probe had left so they say nothing about tunnels
+func (h Hop) TunnelDepth() int {
+	if h.ICMPType != ipv4.ICMPTypeTimeExceeded.String() {
+		return 0
+	}
+	return max(h.QuotedTTL-1, 0)
+}
+
+// InMPLSTunnel reports whether the hop looks to be part of an MPLS tunnel
+func (h Hop) InMPLSTunnel() bool {
+	return len(h.MPLS) > 0 || h.TunnelDepth() > 0
+}
+
+type TraceMethod string
+
+const (
+	TraceICMP TraceMethod = "icmp" // ICMP echo requests
+	TraceUDP  TraceMethod = "udp"  // UDP to high ports, the destination replies port unreachable
+	TraceTCP  TraceMethod = "tcp"  // TCP SYN, the destination replies SYN-ACK or RST
+)
+
+type TraceOpts struct {
+	Method TraceMethod
+	Port   int // Destination port for TCP, base destination port for UDP
+	// Paris keeps the fields load balancers hash on the same for every
+	// probe, so all the probes in a trace follow the same path
+	Paris         bool
+	MaxHops       int
+	Probes        int           // Probes sent per hop
+	Timeout       time.Duration // How long to wait for replies after sending
+	MaxSilentHops int           // Stop after this many consecutive hops with no reply
+	ASN           ASNLookup     // Looks up the AS of each hop when set
+	DNS           *ReverseDNS   // Looks up the names of each hop when set
+	Bind          Bind          // Trace from an interface or address instead of following the routing table
+}
+
+func checkTraceOpts(opts *TraceOpts) {
+	if opts.Method == "" {
+		opts.Method = TraceICMP
+	}
+	if opts.Port == 0 {
+		switch opts.Method {
+		case TraceUDP:
+			opts.Port = 33434
+		case TraceTCP:
+			opts.Port = 80
 		}
-		defer icmpPing.Close()
+	}
+	if opts.MaxHops == 0 {
+		opts.MaxHops = 30
+	}
+	if opts.Probes == 0 {
+		opts.Probes = 3
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 3 * time.Second
+	}
+	if opts.MaxSilentHops == 0 {
+		opts.MaxSilentHops = 5
+	}
+}
 
-		rtn, err := icmpPing.Read(3 * time.Second)
+// traceReply is a reply to a probe, key identifies which probe it answers
+type traceReply struct {
+	key      int
+	peer     net.Addr
+	received time.Time
+	final    bool // The reply came from the destination
+	port     int
+	icmpType string
+	icmpCode int
+	tcpFlags string
+	// Set when a router or the destination rejected the probe instead of
+	// replying as the destination would
+	unreachable string
+	icmpExtensions
+}
+
+// tracer sends probes using one method and reports the replies to them
+// until its timeout, or until it's closed. Probes with the same flow should
+// be hashed onto the same path by load balancers when tracing in Paris mode,
+// flows runs from 0 to the number of flows given to newTracer
+type tracer interface {
+	send(ttl int, key int, flow int) error
+	replies() <-chan traceReply
+	close()
+}
+
+func newTracer(ip *net.IPAddr, opts TraceOpts, flows int) (tracer, error) {
+	switch opts.Method {
+	case TraceICMP:
+		return newICMPTracer(ip, opts)
+	case TraceUDP:
+		return newUDPTracer(ip, opts)
+	case TraceTCP:
+		return newTCPTracer(ip, opts, flows)
+	default:
+		return nil, fmt.Errorf("Unsupported trace method: %s", opts.Method)
+	}
+}
+
+// Traceroute sends the probes for several TTLs at once and sends more as the
+// hops reply, so a trace takes roughly opts.Timeout past the last hop that
+// replied rather than a timeout for every hop
+func Traceroute(ip *net.IPAddr, opts TraceOpts) ([]Hop, error) {
+	checkTraceOpts(&opts)
 
-		id := rand.Intn(0xffff)
+	hops := make([]Hop, opts.MaxHops)
+	for i := range hops {
+		hops[i].TTL = i + 1
+		hops[i].Sent = opts.Probes
+	}
+
+	destTTL, unreachTTL := 0, 0
+	err := probeAll(ip, opts, 1, func(ttl, _ int, rtt time.Duration, res traceReply) bool {
+		hop := &hops[ttl-1]
+		if hop.IP == nil {
+			hop.IP = res.peer
+			hop.Port = res.port
+			hop.ICMPType = res.icmpType
+			hop.ICMPCode = res.icmpCode
+			hop.TCPFlags = res.tcpFlags
+			hop.Unreachable = res.unreachable
+			hop.MPLS = res.mpls
+			hop.Interfaces = res.interfaces
+			hop.QuotedTTL = res.quotedTTL
+		}
+		hop.RTTs = append(hop.RTTs, rtt)
+		hop.Received++
 
-		opts := ICMPPingOpts{
-			IP:  ip,
-			TTL: i,
-			Seq: i,
-			id:  id,
+		if res.final && (destTTL == 0 || ttl < destTTL) {
+			destTTL = ttl
 		}
-		err = icmpPing.Ping(opts)
-		if err != nil {
-			return nil, err
+		if res.unreachable != "" && (unreachTTL == 0 || ttl < unreachTTL) {
+			unreachTTL = ttl
 		}
 
-	read:
-		for res := range rtn {
-			switch res.Message.Type {
-			case ipv4.ICMPTypeEchoReply:
-				addr, _ := net.LookupAddr(res.Peer.String())
+		return destTTL > 0 && traceComplete(hops[:destTTL])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Probes that were rejected went no further, past that hop there are
+	// only more rejections unless other probes got through
+	if destTTL > 0 {
+		hops = hops[:destTTL]
+	} else if unreachTTL > 0 {
+		hops = hops[:unreachTTL]
+	}
+
+	hops = trimSilentHops(hops, opts.MaxSilentHops)
+	annotateAS(hops, opts.ASN)
+	opts.DNS.annotate(hops)
+
+	return hops, nil
+}
 
-				hops = append(hops, Hop{
-					IP:      res.Peer,
-					Domains: addr,
-				})
+// probeAll sends opts.Probes probes for each TTL and flow, then passes each
+// reply to onReply until it returns true or the timeout passes
+func probeAll(ip *net.IPAddr, opts TraceOpts, flows int, onReply func(ttl, flow int, rtt time.Duration, res traceReply) bool) error {
+	total := flows * opts.MaxHops * opts.Probes
+	if total > 0xffff {
+		return fmt.Errorf("Too many probes for one trace: %d", total)
+	}
 
-				body := res.Message.Body.(*icmp.Echo)
-				if body.ID == id && body.Seq == i {
-					break loop
+	// probeTTLs closes the tracer once the replies stop, its own timeout only
+	// has to outlast every TTL being sent one after another
+	tracerOpts := opts
+	tracerOpts.Timeout = time.Duration(opts.MaxHops+1) * opts.Timeout
+	t, err := newTracer(ip, tracerOpts, flows)
+	if err != nil {
+		return err
+	}
+	// Closing the tracer ends its replies early, drain so it can clean up
+	defer func() {
+		t.close()
+		for range t.replies() {
+		}
+	}()
+
+	return probeTTLs(t, opts, flows, onReply)
+}
+
+// probeTTLs sends TTLs up to opts.MaxSilentHops past the furthest hop that has
+// replied, so once that many hops in a row are silent no more are sent. It
+// returns opts.Timeout after the last probes were sent
+func probeTTLs(t tracer, opts TraceOpts, flows int, onReply func(ttl, flow int, rtt time.Duration, res traceReply) bool) error {
+	sentAt := make(map[int]time.Time)
+	nextTTL := 1
+	sendUpTo := func(last int) error {
+		first := nextTTL
+		nextTTL = last + 1
+		for probe := 0; probe < opts.Probes; probe++ {
+			for ttl := first; ttl <= last; ttl++ {
+				for flow := 0; flow < flows; flow++ {
+					key := (flow*opts.MaxHops+ttl-1)*opts.Probes + probe + 1
+
+					sentAt[key] = time.Now()
+					if err := t.send(ttl, key, flow); err != nil {
+						return err
+					}
 				}
-			case ipv4.ICMPTypeTimeExceeded:
-				hops = append(hops, Hop{
-					IP: res.Peer,
-				})
-				break read
 			}
 		}
+		return nil
 	}
 
-	return hops, nil
+	if err := sendUpTo(min(opts.MaxSilentHops, opts.MaxHops)); err != nil {
+		return err
+	}
+	timeout := time.NewTimer(opts.Timeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case res, ok := <-t.replies():
+			if !ok {
+				return nil
+			}
+			sent, ok := sentAt[res.key]
+			if !ok {
+				continue
+			}
+			delete(sentAt, res.key)
+
+			k := (res.key - 1) / opts.Probes
+			ttl, flow := k%opts.MaxHops+1, k/opts.MaxHops
+			if onReply(ttl, flow, res.received.Sub(sent), res) {
+				return nil
+			}
+
+			// Nothing past the destination or a rejection needs probing
+			last := min(ttl+opts.MaxSilentHops, opts.MaxHops)
+			if res.final || res.unreachable != "" || last < nextTTL {
+				continue
+			}
+			if err := sendUpTo(last); err != nil {
+				return err
+			}
+			timeout.Reset(opts.Timeout)
+		case <-timeout.C:
+			return nil
+		}
+	}
+}
+
+// quotedTransport returns the first 8 bytes of the transport header of the
+// datagram we sent to dst, as quoted in an ICMP error message
+func quotedTransport(msg *icmp.Message, proto int, dst net.IP) ([]byte, bool) {
+	var data []byte
+	switch body := msg.Body.(type) {
+	case *icmp.TimeExceeded:
+		data = body.Data
+	case *icmp.DstUnreach:
+		data = body.Data
+	default:
+		return nil, false
+	}
+
+	h, err := ipv4.ParseHeader(data)
+	if err != nil || h.Protocol != proto || !h.Dst.Equal(dst) || len(data) < h.Len+8 {
+		return nil, false
+	}
+	return data[h.Len : h.Len+8], true
+}
+
+// unreachableFlags are the annotations traceroute gives destination
+// unreachable codes, other codes are shown as !<code>
+var unreachableFlags = map[int]string{
+	0:  "!N",
+	1:  "!H",
+	2:  "!P",
+	4:  "!F",
+	5:  "!S",
+	6:  "!N",
+	7:  "!H",
+	9:  "!X",
+	10: "!X",
+	11: "!N",
+	12: "!H",
+	13: "!X",
+	14: "!V",
+	15: "!C",
+}
+
+func unreachableFlag(code int) string {
+	if flag, ok := unreachableFlags[code]; ok {
+		return flag
+	}
+	return fmt.Sprintf("!%d", code)
+}
+
+// icmpErrorReply fills in the parts of a reply that come from an ICMP error
+// message. Destination unreachable messages are marked as rejections, the
+// tracers decide which of them mean the destination was reached
+func icmpErrorReply(res ICMPPingResponse, key int) traceReply {
+	reply := traceReply{
+		key:      key,
+		peer:     res.Peer,
+		received: res.Received,
+		icmpType: res.Message.Type.(ipv4.ICMPType).String(),
+		icmpCode: res.Message.Code,
+
+		icmpExtensions: parseICMPExtensions(res.Message),
+	}
+	if res.Message.Type == ipv4.ICMPTypeDestinationUnreachable {
+		reply.unreachable = unreachableFlag(res.Message.Code)
+	}
+	return reply
+}
+
+func traceComplete(hops []Hop) bool {
+	for _, hop := range hops {
+		if hop.Received < hop.Sent {
+			return false
+		}
+	}
+	return true
+}
+
+// trimSilentHops cuts the path at the first run of maxSilent hops that
+// did not reply to any probe
+func trimSilentHops(hops []Hop, maxSilent int) []Hop {
+	silent := 0
+	for i, hop := range hops {
+		if hop.Received > 0 {
+			silent = 0
+			continue
+		}
+		silent++
+		if silent == maxSilent {
+			return hops[:i+1-silent]
+		}
+	}
+	return hops
 }
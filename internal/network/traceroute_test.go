@@ -0,0 +1,232 @@
+package network
+
+import (
+	"encoding/binary"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestTunnelDepth(t *testing.T) {
+	timeExceeded := ipv4.ICMPTypeTimeExceeded.String()
+	unreachable := ipv4.ICMPTypeDestinationUnreachable.String()
+	tests := []struct {
+		name string
+		hop  Hop
+		want int
+	}{
+		{"outside a tunnel", Hop{ICMPType: timeExceeded, QuotedTTL: 1}, 0},
+		{"third router in a tunnel", Hop{ICMPType: timeExceeded, QuotedTTL: 3}, 2},
+		{"no quote", Hop{ICMPType: timeExceeded}, 0},
+		// The probe still had TTL left when it was rejected
+		{"destination unreachable", Hop{ICMPType: unreachable, QuotedTTL: 5}, 0},
+		{"echo reply", Hop{ICMPType: ipv4.ICMPTypeEchoReply.String()}, 0},
+	}
+	for _, test := range tests {
+		if got := test.hop.TunnelDepth(); got != test.want {
+			t.Errorf("%s: expected depth %d, got %d", test.name, test.want, got)
+		}
+		if test.hop.InMPLSTunnel() != (test.want > 0) {
+			t.Errorf("%s: expected InMPLSTunnel to be %v", test.name, test.want > 0)
+		}
+	}
+}
+
+// udpError returns an ICMP error from peer quoting a UDP probe to dst
+func udpError(t *testing.T, typ ipv4.ICMPType, code int, peer string, dst net.IP, srcPort, dstPort int) ICMPPingResponse {
+	t.Helper()
+	h := ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TotalLen: ipv4.HeaderLen + 8, TTL: 1, Protocol: 17, Src: net.IPv4(10, 0, 0, 2), Dst: dst}
+	data, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(dstPort))
+	data = append(data, udp...)
+
+	var body icmp.MessageBody = &icmp.DstUnreach{Data: data}
+	if typ == ipv4.ICMPTypeTimeExceeded {
+		body = &icmp.TimeExceeded{Data: data}
+	}
+	return ICMPPingResponse{
+		Message: &icmp.Message{Type: typ, Code: code, Body: body},
+		Peer:    &net.IPAddr{IP: net.ParseIP(peer)},
+	}
+}
+
+func TestUDPTracerUnreachable(t *testing.T) {
+	dst := net.IPv4(192, 0, 2, 1)
+	tests := []struct {
+		name        string
+		typ         ipv4.ICMPType
+		code        int
+		peer        string
+		final       bool
+		unreachable string
+	}{
+		{"time exceeded", ipv4.ICMPTypeTimeExceeded, 0, "10.0.0.1", false, ""},
+		{"port unreachable from the destination", ipv4.ICMPTypeDestinationUnreachable, 3, "192.0.2.1", true, ""},
+		{"port unreachable from a router", ipv4.ICMPTypeDestinationUnreachable, 3, "10.0.0.1", false, "!3"},
+		{"host unreachable", ipv4.ICMPTypeDestinationUnreachable, 1, "10.0.0.1", false, "!H"},
+		{"network unreachable", ipv4.ICMPTypeDestinationUnreachable, 0, "10.0.0.1", false, "!N"},
+		{"prohibited by the destination", ipv4.ICMPTypeDestinationUnreachable, 13, "192.0.2.1", false, "!X"},
+	}
+	for _, test := range tests {
+		rtn := make(chan ICMPPingResponse, 1)
+		tracer := udpTracer{ip: &net.IPAddr{IP: dst}, port: 33434, srcPort: 40000, out: make(chan traceReply, 1)}
+		rtn <- udpError(t, test.typ, test.code, test.peer, dst, 40000, 33434+7)
+		close(rtn)
+		tracer.listen(rtn)
+
+		reply, ok := <-tracer.out
+		if !ok {
+			t.Fatalf("%s: expected a reply", test.name)
+		}
+		if reply.key != 7 || reply.final != test.final || reply.unreachable != test.unreachable {
+			t.Errorf("%s: expected key 7, final %v and %q, got key %d, final %v and %q",
+				test.name, test.final, test.unreachable, reply.key, reply.final, reply.unreachable)
+		}
+	}
+}
+
+// fakeTracer answers every probe up to replyTTL straight away, the probe at
+// finalTTL from the destination
+type fakeTracer struct {
+	replyTTL int
+	finalTTL int
+	sent     []int
+	out      chan traceReply
+}
+
+func (f *fakeTracer) send(ttl int, key int, flow int) error {
+	f.sent = append(f.sent, ttl)
+	if ttl <= f.replyTTL {
+		f.out <- traceReply{key: key, received: time.Now(), final: ttl == f.finalTTL}
+	}
+	return nil
+}
+
+func (f *fakeTracer) replies() <-chan traceReply {
+	return f.out
+}
+
+func (f *fakeTracer) close() {}
+
+func TestProbeTTLsStopsAfterSilentHops(t *testing.T) {
+	opts := TraceOpts{MaxHops: 30, Probes: 2, Timeout: 20 * time.Millisecond, MaxSilentHops: 3}
+	tests := []struct {
+		name     string
+		replyTTL int
+		finalTTL int
+		lastSent int
+	}{
+		{"path goes silent", 4, 0, 7},
+		{"nothing replies", 0, 0, 3},
+		{"destination reached", 5, 5, 7},
+		{"every hop replies", 30, 0, 30},
+	}
+	for _, test := range tests {
+		f := &fakeTracer{replyTTL: test.replyTTL, finalTTL: test.finalTTL, out: make(chan traceReply, 1000)}
+		replies := 0
+		err := probeTTLs(f, opts, 1, func(ttl, flow int, rtt time.Duration, res traceReply) bool {
+			replies++
+			return false
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := slices.Max(f.sent); last != test.lastSent {
+			t.Errorf("%s: expected TTLs up to %d to be sent, got %d", test.name, test.lastSent, last)
+		}
+		if len(f.sent) != test.lastSent*opts.Probes {
+			t.Errorf("%s: expected %d probes, got %d", test.name, test.lastSent*opts.Probes, len(f.sent))
+		}
+		if want := min(test.replyTTL, test.lastSent) * opts.Probes; replies != want {
+			t.Errorf("%s: expected %d replies, got %d", test.name, want, replies)
+		}
+	}
+}
+
+func TestTrimSilentHops(t *testing.T) {
+	hops := func(received ...int) []Hop {
+		h := make([]Hop, len(received))
+		for i, r := range received {
+			h[i] = Hop{TTL: i + 1, Sent: 3, Received: r}
+		}
+		return h
+	}
+	tests := []struct {
+		name string
+		hops []Hop
+		want int
+	}{
+		{"no silent hops", hops(3, 3, 3), 3},
+		{"short gap", hops(3, 0, 0, 3, 3), 5},
+		{"silent tail", hops(3, 3, 0, 0, 0, 0), 2},
+		{"gap then silent", hops(1, 0, 2, 0, 0, 0), 3},
+		{"silent tail shorter than the limit", hops(3, 0, 0), 3},
+		{"all silent", hops(0, 0, 0), 0},
+		{"empty", nil, 0},
+	}
+	for _, test := range tests {
+		if got := trimSilentHops(test.hops, 3); len(got) != test.want {
+			t.Errorf("%s: expected %d hops, got %d", test.name, test.want, len(got))
+		}
+	}
+}
+
+func TestTraceComplete(t *testing.T) {
+	tests := []struct {
+		name string
+		hops []Hop
+		want bool
+	}{
+		{"every probe answered", []Hop{{Sent: 3, Received: 3}, {Sent: 3, Received: 3}}, true},
+		{"a probe outstanding", []Hop{{Sent: 3, Received: 3}, {Sent: 3, Received: 2}}, false},
+		{"silent hop", []Hop{{Sent: 3}, {Sent: 3, Received: 3}}, false},
+		{"no hops", nil, true},
+	}
+	for _, test := range tests {
+		if got := traceComplete(test.hops); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestQuotedTransport(t *testing.T) {
+	dst := net.IPv4(192, 0, 2, 1)
+	reply := udpError(t, ipv4.ICMPTypeTimeExceeded, 0, "10.0.0.1", dst, 40000, 33434)
+	short := udpError(t, ipv4.ICMPTypeTimeExceeded, 0, "10.0.0.1", dst, 40000, 33434)
+	body := short.Message.Body.(*icmp.TimeExceeded)
+	body.Data = body.Data[:len(body.Data)-4]
+	echo := &icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 1}}
+
+	tests := []struct {
+		name  string
+		msg   *icmp.Message
+		proto int
+		dst   net.IP
+		ok    bool
+	}{
+		{"matching probe", reply.Message, 17, dst, true},
+		{"other protocol", reply.Message, 6, dst, false},
+		{"other destination", reply.Message, 17, net.IPv4(192, 0, 2, 2), false},
+		{"truncated quote", short.Message, 17, dst, false},
+		{"not an error", echo, 17, dst, false},
+	}
+	for _, test := range tests {
+		quoted, ok := quotedTransport(test.msg, test.proto, test.dst)
+		if ok != test.ok {
+			t.Errorf("%s: expected ok to be %v", test.name, test.ok)
+			continue
+		}
+		if ok && binary.BigEndian.Uint16(quoted[2:4]) != 33434 {
+			t.Errorf("%s: expected the quoted destination port, got %x", test.name, quoted)
+		}
+	}
+}
@@ -35,6 +35,18 @@ func (t *Tracker[T]) Set(key string, val T) {
 	t.vals[key] = val
 }
 
+// SetIfAbsent sets key to val unless it's already set, it returns false if
+// it was
+func (t *Tracker[T]) SetIfAbsent(key string, val T) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.vals[key]; ok {
+		return false
+	}
+	t.vals[key] = val
+	return true
+}
+
 func (t *Tracker[T]) SetAll(val T) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -42,3 +54,16 @@ func (t *Tracker[T]) SetAll(val T) {
 		t.vals[k] = val
 	}
 }
+
+func (t *Tracker[T]) Lookup(key string) (T, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	val, ok := t.vals[key]
+	return val, ok
+}
+
+func (t *Tracker[T]) Delete(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.vals, key)
+}
@@ -0,0 +1,13 @@
+package utils
+
+import "testing"
+
+func TestSetIfAbsent(t *testing.T) {
+	tracker := NewTracker[int]()
+	if !tracker.SetIfAbsent("a", 1) {
+		t.Error("Expected a missing key to be set")
+	}
+	if tracker.SetIfAbsent("a", 2) || tracker.Get("a") != 1 {
+		t.Errorf("Expected a set key to be kept, got %d", tracker.Get("a"))
+	}
+}